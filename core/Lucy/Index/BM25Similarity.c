@@ -0,0 +1,175 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#define C_LUCY_BM25SIMILARITY
+#include "Lucy/Util/ToolSet.h"
+
+#include "math.h"
+
+#include "Lucy/Index/BM25Similarity.h"
+#include "Lucy/Index/Posting/BM25Posting.h"
+#include "Lucy/Index/PolyReader.h"
+#include "Lucy/Index/Segment.h"
+#include "Lucy/Index/Snapshot.h"
+#include "Lucy/Plan/Schema.h"
+#include "Lucy/Store/InStream.h"
+#include "Lucy/Store/OutStream.h"
+#include "Lucy/Util/Json.h"
+
+BM25Similarity*
+BM25Sim_new(float k1, float b) {
+    BM25Similarity *self = (BM25Similarity*)Class_Make_Obj(BM25SIMILARITY);
+    return BM25Sim_init(self, k1, b);
+}
+
+BM25Similarity*
+BM25Sim_init(BM25Similarity *self, float k1, float b) {
+    Sim_init((Similarity*)self);
+    BM25SimilarityIVARS *const ivars = BM25Sim_IVARS(self);
+    ivars->k1 = k1;
+    ivars->b  = b;
+    return self;
+}
+
+float
+BM25Sim_Get_K1_IMP(BM25Similarity *self) {
+    return BM25Sim_IVARS(self)->k1;
+}
+
+float
+BM25Sim_Get_B_IMP(BM25Similarity *self) {
+    return BM25Sim_IVARS(self)->b;
+}
+
+float
+BM25Sim_TF_Norm_IMP(BM25Similarity *self, float freq, float length,
+                    float avg_length) {
+    BM25SimilarityIVARS *const ivars = BM25Sim_IVARS(self);
+    const float k1 = ivars->k1;
+    const float b  = ivars->b;
+    if (freq == 0.0f) {
+        return 0.0f;
+    }
+    // Treat fields as being of average length if there are no totals.
+    const float relative_length = avg_length > 0.0f
+                                  ? length / avg_length
+                                  : 1.0f;
+    return freq * (k1 + 1.0f) / (freq + k1 * (1.0f - b + b * relative_length));
+}
+
+Posting*
+BM25Sim_Make_Posting_IMP(BM25Similarity *self) {
+    return (Posting*)BM25Post_new((Similarity*)self);
+}
+
+PostingWriter*
+BM25Sim_Make_Posting_Writer_IMP(BM25Similarity *self, Schema *schema,
+                                Snapshot *snapshot, Segment *segment,
+                                PolyReader *polyreader, int32_t field_num) {
+    UNUSED_VAR(self);
+    return (PostingWriter*)BM25PostWriter_new(schema, snapshot, segment,
+                                              polyreader, field_num);
+}
+
+float
+BM25Sim_TF_IMP(BM25Similarity *self, float freq) {
+    return BM25Sim_TF_Norm(self, freq, 1.0f, 1.0f);
+}
+
+float
+BM25Sim_IDF_IMP(BM25Similarity *self, int64_t doc_freq, int64_t total_docs) {
+    UNUSED_VAR(self);
+    double idf = log(1.0 + ((double)total_docs - (double)doc_freq + 0.5)
+                           / ((double)doc_freq + 0.5));
+    return (float)sqrt(idf > 0.0 ? idf : 0.0);
+}
+
+float
+BM25Sim_Coord_IMP(BM25Similarity *self, uint32_t overlap,
+                  uint32_t max_overlap) {
+    UNUSED_VAR(self);
+    UNUSED_VAR(overlap);
+    UNUSED_VAR(max_overlap);
+    return 1.0f;
+}
+
+float
+BM25Sim_Query_Norm_IMP(BM25Similarity *self, float sum_of_squared_weights) {
+    UNUSED_VAR(self);
+    UNUSED_VAR(sum_of_squared_weights);
+    return 1.0f;
+}
+
+Obj*
+BM25Sim_Dump_IMP(BM25Similarity *self) {
+    BM25SimilarityIVARS *const ivars = BM25Sim_IVARS(self);
+    BM25Sim_Dump_t super_dump
+        = SUPER_METHOD_PTR(BM25SIMILARITY, LUCY_BM25Sim_Dump);
+    Hash *dump = (Hash*)CERTIFY(super_dump(self), HASH);
+    Hash_Store_Utf8(dump, "k1", 2, (Obj*)Str_newf("%f64", (double)ivars->k1));
+    Hash_Store_Utf8(dump, "b", 1, (Obj*)Str_newf("%f64", (double)ivars->b));
+    return (Obj*)dump;
+}
+
+BM25Similarity*
+BM25Sim_Load_IMP(BM25Similarity *self, Obj *dump) {
+    Hash *source = (Hash*)CERTIFY(dump, HASH);
+    BM25Sim_Load_t super_load
+        = SUPER_METHOD_PTR(BM25SIMILARITY, LUCY_BM25Sim_Load);
+    BM25Similarity *loaded = super_load(self, dump);
+    BM25SimilarityIVARS *const loaded_ivars = BM25Sim_IVARS(loaded);
+    Obj *k1_dump = Hash_Fetch_Utf8(source, "k1", 2);
+    Obj *b_dump  = Hash_Fetch_Utf8(source, "b", 1);
+    loaded_ivars->k1 = k1_dump ? (float)Json_obj_to_f64(k1_dump) : 1.2f;
+    loaded_ivars->b  = b_dump  ? (float)Json_obj_to_f64(b_dump)  : 0.75f;
+    return loaded;
+}
+
+bool
+BM25Sim_Equals_IMP(BM25Similarity *self, Obj *other) {
+    if ((BM25Similarity*)other == self)  { return true; }
+    BM25Sim_Equals_t super_equals
+        = (BM25Sim_Equals_t)SUPER_METHOD_PTR(BM25SIMILARITY,
+                                             LUCY_BM25Sim_Equals);
+    if (!super_equals(self, other))      { return false; }
+    BM25SimilarityIVARS *const ivars = BM25Sim_IVARS(self);
+    BM25SimilarityIVARS *const ovars = BM25Sim_IVARS((BM25Similarity*)other);
+    if (ivars->k1 != ovars->k1)          { return false; }
+    if (ivars->b  != ovars->b)           { return false; }
+    return true;
+}
+
+void
+BM25Sim_Serialize_IMP(BM25Similarity *self, OutStream *outstream) {
+    BM25SimilarityIVARS *const ivars = BM25Sim_IVARS(self);
+    BM25Sim_Serialize_t super_serialize
+        = SUPER_METHOD_PTR(BM25SIMILARITY, LUCY_BM25Sim_Serialize);
+    super_serialize(self, outstream);
+    OutStream_Write_F32(outstream, ivars->k1);
+    OutStream_Write_F32(outstream, ivars->b);
+}
+
+BM25Similarity*
+BM25Sim_Deserialize_IMP(BM25Similarity *self, InStream *instream) {
+    BM25Sim_Deserialize_t super_deserialize
+        = SUPER_METHOD_PTR(BM25SIMILARITY, LUCY_BM25Sim_Deserialize);
+    self = super_deserialize(self, instream);
+    BM25SimilarityIVARS *const ivars = BM25Sim_IVARS(self);
+    ivars->k1 = InStream_Read_F32(instream);
+    ivars->b  = InStream_Read_F32(instream);
+    return self;
+}
+
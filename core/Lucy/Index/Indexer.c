@@ -64,6 +64,18 @@ S_init_folder(Obj *index, bool create);
 static String*
 S_find_schema_file(Snapshot *snapshot);
 
+// Throw an exception if the Indexer has been rolled back or closed.
+static void
+S_check_open(Indexer *self, const char *method);
+
+// Delete the files written during this indexing session.
+static void
+S_discard_changes(Indexer *self);
+
+// Release the writers and the reader, invalidating the Indexer.
+static void
+S_release_writers(Indexer *self);
+
 Indexer*
 Indexer_new(Schema *schema, Obj *index, IndexManager *manager, int32_t flags) {
     Indexer *self = (Indexer*)Class_Make_Obj(INDEXER);
@@ -265,12 +277,14 @@ S_init_folder(Obj *index, bool create) {
 void
 Indexer_Add_Doc_IMP(Indexer *self, Doc *doc, float boost) {
     IndexerIVARS *const ivars = Indexer_IVARS(self);
+    S_check_open(self, "Add_Doc");
     SegWriter_Add_Doc(ivars->seg_writer, doc, boost);
 }
 
 void
 Indexer_Delete_By_Term_IMP(Indexer *self, String *field, Obj *term) {
     IndexerIVARS *const ivars = Indexer_IVARS(self);
+    S_check_open(self, "Delete_By_Term");
     Schema    *schema = ivars->schema;
     FieldType *type   = Schema_Fetch_Type(schema, field);
 
@@ -299,18 +313,21 @@ Indexer_Delete_By_Term_IMP(Indexer *self, String *field, Obj *term) {
 void
 Indexer_Delete_By_Query_IMP(Indexer *self, Query *query) {
     IndexerIVARS *const ivars = Indexer_IVARS(self);
+    S_check_open(self, "Delete_By_Query");
     DelWriter_Delete_By_Query(ivars->del_writer, query);
 }
 
 void
 Indexer_Delete_By_Doc_ID_IMP(Indexer *self, int32_t doc_id) {
     IndexerIVARS *const ivars = Indexer_IVARS(self);
+    S_check_open(self, "Delete_By_Doc_ID");
     DelWriter_Delete_By_Doc_ID(ivars->del_writer, doc_id);
 }
 
 void
 Indexer_Add_Index_IMP(Indexer *self, Obj *index) {
     IndexerIVARS *const ivars = Indexer_IVARS(self);
+    S_check_open(self, "Add_Index");
     Folder *other_folder = NULL;
     IndexReader *reader  = NULL;
 
@@ -473,6 +490,7 @@ S_maybe_merge(Indexer *self, Vector *seg_readers) {
 void
 Indexer_Prepare_Commit_IMP(Indexer *self) {
     IndexerIVARS *const ivars = Indexer_IVARS(self);
+    S_check_open(self, "Prepare_Commit");
     Vector   *seg_readers     = PolyReader_Get_Seg_Readers(ivars->polyreader);
     size_t    num_seg_readers = Vec_Get_Size(seg_readers);
     bool      merge_happened  = false;
@@ -569,6 +587,89 @@ Indexer_Commit_IMP(Indexer *self) {
     S_release_write_lock(self);
 }
 
+void
+Indexer_Rollback_IMP(Indexer *self) {
+    IndexerIVARS *const ivars = Indexer_IVARS(self);
+
+    // Safety check.
+    if (!ivars->write_lock) {
+        THROW(ERR, "Can't call Rollback() after Commit(), Rollback() or"
+              " Close()");
+    }
+
+    S_discard_changes(self);
+
+    // Release locks and writers, invalidating the Indexer.
+    S_release_merge_lock(self);
+    S_release_write_lock(self);
+    S_release_writers(self);
+}
+
+void
+Indexer_Close_IMP(Indexer *self) {
+    IndexerIVARS *const ivars = Indexer_IVARS(self);
+
+    // Abandon uncommitted changes.
+    if (ivars->write_lock) {
+        S_discard_changes(self);
+    }
+    S_release_merge_lock(self);
+    S_release_write_lock(self);
+    S_release_writers(self);
+}
+
+static void
+S_check_open(Indexer *self, const char *method) {
+    if (!Indexer_IVARS(self)->seg_writer) {
+        THROW(ERR, "Can't call %s() after Rollback() or Close()", method);
+    }
+}
+
+static void
+S_release_writers(Indexer *self) {
+    IndexerIVARS *const ivars = Indexer_IVARS(self);
+
+    // The Schema and the stock Doc stay around so that accessors remain
+    // safe to call.
+    DECREF(ivars->seg_writer);
+    DECREF(ivars->del_writer);
+    DECREF(ivars->polyreader);
+    DECREF(ivars->file_purger);
+    ivars->seg_writer  = NULL;
+    ivars->del_writer  = NULL;
+    ivars->polyreader  = NULL;
+    ivars->file_purger = NULL;
+}
+
+static void
+S_discard_changes(Indexer *self) {
+    IndexerIVARS *const ivars = Indexer_IVARS(self);
+    Folder *folder = ivars->folder;
+
+    // Close reader, so that we can delete its files if appropriate.
+    // Prepare_Commit has already closed it.
+    if (!ivars->prepared && ivars->polyreader) {
+        PolyReader_Close(ivars->polyreader);
+    }
+
+    // Zap the temporary snapshot file and the new schema file written by
+    // Prepare_Commit.
+    if (ivars->needs_commit) {
+        String *schema_file = S_find_schema_file(ivars->snapshot);
+        if (schema_file) {
+            Folder_Delete(folder, schema_file);
+        }
+        Folder_Delete(folder, ivars->snapfile);
+        ivars->needs_commit = false;
+    }
+
+    // Zap the new segment, which no snapshot refers to yet.
+    String *seg_name = Seg_Get_Name(ivars->segment);
+    if (Folder_Exists(folder, seg_name)) {
+        Folder_Delete_Tree(folder, seg_name);
+    }
+}
+
 Schema*
 Indexer_Get_Schema_IMP(Indexer *self) {
     return Indexer_IVARS(self)->schema;
@@ -55,4 +55,9 @@ PostWriter_init(PostingWriter *self, Schema *schema, Snapshot *snapshot,
     return self;
 }
 
+void
+PostWriter_Finish_IMP(PostingWriter *self) {
+    UNUSED_VAR(self);
+}
+
 
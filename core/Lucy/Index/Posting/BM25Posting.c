@@ -0,0 +1,354 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#define C_LUCY_BM25POSTING
+#define C_LUCY_BM25POSTINGMATCHER
+#define C_LUCY_BM25POSTINGWRITER
+#define C_LUCY_RAWPOSTING
+#define C_LUCY_SEGPOSTINGLIST
+#define C_LUCY_TOKEN
+#include "Lucy/Util/ToolSet.h"
+
+#include "Lucy/Index/Posting/BM25Posting.h"
+#include "Lucy/Analysis/Token.h"
+#include "Lucy/Analysis/Inversion.h"
+#include "Lucy/Index/BM25Similarity.h"
+#include "Lucy/Index/DataReader.h"
+#include "Lucy/Index/Posting/RawPosting.h"
+#include "Lucy/Index/PostingList.h"
+#include "Lucy/Index/PostingPool.h"
+#include "Lucy/Index/SegPostingList.h"
+#include "Lucy/Index/Segment.h"
+#include "Lucy/Index/Similarity.h"
+#include "Lucy/Object/BitVector.h"
+#include "Lucy/Plan/FieldType.h"
+#include "Lucy/Search/Compiler.h"
+#include "Lucy/Store/InStream.h"
+#include "Lucy/Util/Json.h"
+#include "Lucy/Util/MemoryPool.h"
+#include "Lucy/Util/NumberUtils.h"
+
+#define FIELD_BOOST_LEN  1
+#define FREQ_MAX_LEN     CU32_MAX_BYTES
+#define LENGTH_MAX_LEN   CU32_MAX_BYTES
+#define MAX_RAW_POSTING_LEN(_raw_post_size, _text_len, _freq) \
+    (              _raw_post_size \
+                   + _text_len                /* term text content */ \
+                   + FIELD_BOOST_LEN          /* field boost byte */ \
+                   + LENGTH_MAX_LEN           /* field length cu32 */ \
+                   + FREQ_MAX_LEN             /* freq cu32 */ \
+                   + (CU32_MAX_BYTES * _freq)  /* positions deltas */ \
+    )
+
+static float
+S_avg_length(PostingList *plist);
+
+BM25Posting*
+BM25Post_new(Similarity *sim) {
+    BM25Posting *self = (BM25Posting*)Class_Make_Obj(BM25POSTING);
+    return BM25Post_init(self, sim);
+}
+
+BM25Posting*
+BM25Post_init(BM25Posting *self, Similarity *sim) {
+    CERTIFY(sim, BM25SIMILARITY);
+    ScorePost_init((ScorePosting*)self, sim);
+    BM25PostingIVARS *const ivars = BM25Post_IVARS(self);
+    ivars->length = 0;
+    return self;
+}
+
+uint32_t
+BM25Post_Get_Length_IMP(BM25Posting *self) {
+    return BM25Post_IVARS(self)->length;
+}
+
+void
+BM25Post_Add_Inversion_To_Pool_IMP(BM25Posting *self, PostingPool *post_pool,
+                                   Inversion *inversion, FieldType *type,
+                                   int32_t doc_id, float doc_boost,
+                                   float length_norm) {
+    BM25PostingIVARS *const ivars = BM25Post_IVARS(self);
+    MemoryPool     *mem_pool = PostPool_Get_Mem_Pool(post_pool);
+    Similarity     *sim = ivars->sim;
+    float           field_boost = doc_boost * FType_Get_Boost(type);
+    const uint8_t   field_boost_byte  = Sim_Encode_Norm(sim, field_boost);
+    const uint32_t  length = Inversion_Get_Size(inversion);
+    const size_t    base_size = Class_Get_Obj_Alloc_Size(RAWPOSTING);
+    Token         **tokens;
+    uint32_t        freq;
+    UNUSED_VAR(length_norm);
+
+    Inversion_Reset(inversion);
+    while ((tokens = Inversion_Next_Cluster(inversion, &freq)) != NULL) {
+        TokenIVARS *const token_ivars = Token_IVARS(*tokens);
+        size_t raw_post_bytes
+            = MAX_RAW_POSTING_LEN(base_size, token_ivars->len, freq);
+        RawPosting *raw_posting
+            = RawPost_new(MemPool_Grab(mem_pool, raw_post_bytes), doc_id,
+                          freq, token_ivars->text, token_ivars->len);
+        RawPostingIVARS *const raw_post_ivars = RawPost_IVARS(raw_posting);
+        char *const start  = raw_post_ivars->blob + token_ivars->len;
+        char *dest         = start;
+        int32_t last_prox = 0;
+
+        // Field_boost.
+        *((uint8_t*)dest) = field_boost_byte;
+        dest++;
+
+        // Field length.
+        NumUtil_encode_cu32(length, &dest);
+
+        // Positions.
+        for (uint32_t i = 0; i < freq; i++) {
+            TokenIVARS *const t_ivars = Token_IVARS(tokens[i]);
+            const int32_t prox_delta = t_ivars->pos - last_prox;
+            NumUtil_encode_ci32(prox_delta, &dest);
+            last_prox = t_ivars->pos;
+        }
+
+        // Resize raw posting memory allocation.
+        raw_post_ivars->aux_len = (size_t)(dest - start);
+        raw_post_bytes = (size_t)(dest - (char*)raw_posting);
+        MemPool_Resize(mem_pool, raw_posting, raw_post_bytes);
+        PostPool_Feed(post_pool, (Obj*)raw_posting);
+    }
+}
+
+void
+BM25Post_Reset_IMP(BM25Posting *self) {
+    BM25Post_Reset_t super_reset
+        = SUPER_METHOD_PTR(BM25POSTING, LUCY_BM25Post_Reset);
+    super_reset(self);
+    BM25Post_IVARS(self)->length = 0;
+}
+
+void
+BM25Post_Read_Record_IMP(BM25Posting *self, InStream *instream) {
+    BM25PostingIVARS *const ivars = BM25Post_IVARS(self);
+    uint32_t  position = 0;
+    const size_t max_start_bytes = (CU32_MAX_BYTES * 3) + 1;
+    const char *buf = InStream_Buf(instream, max_start_bytes);
+    const uint32_t doc_code = NumUtil_decode_cu32(&buf);
+    const uint32_t doc_delta = doc_code >> 1;
+
+    // Apply delta doc and retrieve freq.
+    ivars->doc_id   += doc_delta;
+    if (doc_code & 1) {
+        ivars->freq = 1;
+    }
+    else {
+        ivars->freq = NumUtil_decode_cu32(&buf);
+    }
+
+    // Decode boost byte and field length.
+    ivars->weight = ivars->norm_decoder[*(uint8_t*)buf];
+    buf++;
+    ivars->length = NumUtil_decode_cu32(&buf);
+
+    // Read positions.
+    uint32_t num_prox = ivars->freq;
+    if (num_prox > ivars->prox_cap) {
+        ivars->prox = (uint32_t*)REALLOCATE(
+                         ivars->prox, num_prox * sizeof(uint32_t));
+        ivars->prox_cap = num_prox;
+    }
+    uint32_t *positions = ivars->prox;
+
+    InStream_Advance_Buf(instream, buf);
+    buf = InStream_Buf(instream, num_prox * CU32_MAX_BYTES);
+    while (num_prox--) {
+        position += NumUtil_decode_cu32(&buf);
+        *positions++ = position;
+    }
+
+    InStream_Advance_Buf(instream, buf);
+}
+
+RawPosting*
+BM25Post_Read_Raw_IMP(BM25Posting *self, InStream *instream,
+                      int32_t last_doc_id, String *term_text,
+                      MemoryPool *mem_pool) {
+    const char *const text_buf  = Str_Get_Ptr8(term_text);
+    const size_t      text_size = Str_Get_Size(term_text);
+    const uint32_t    doc_code  = InStream_Read_CU32(instream);
+    const uint32_t    delta_doc = doc_code >> 1;
+    const int32_t     doc_id    = last_doc_id + (int32_t)delta_doc;
+    const uint32_t    freq      = (doc_code & 1)
+                                  ? 1
+                                  : InStream_Read_CU32(instream);
+    const size_t base_size = Class_Get_Obj_Alloc_Size(RAWPOSTING);
+    size_t raw_post_bytes  = MAX_RAW_POSTING_LEN(base_size, text_size, freq);
+    void *const allocation = MemPool_Grab(mem_pool, raw_post_bytes);
+    RawPosting *const raw_posting
+        = RawPost_new(allocation, doc_id, freq, text_buf, text_size);
+    RawPostingIVARS *const raw_post_ivars = RawPost_IVARS(raw_posting);
+    uint32_t num_prox = freq;
+    char *const start = raw_post_ivars->blob + text_size;
+    char *dest        = start;
+    UNUSED_VAR(self);
+
+    // Field_boost.
+    *((uint8_t*)dest) = InStream_Read_U8(instream);
+    dest++;
+
+    // Field length.
+    dest += InStream_Read_Raw_C64(instream, dest);
+
+    // Read positions.
+    while (num_prox--) {
+        dest += InStream_Read_Raw_C64(instream, dest);
+    }
+
+    // Resize raw posting memory allocation.
+    raw_post_ivars->aux_len = (size_t)(dest - start);
+    raw_post_bytes = (size_t)(dest - (char*)raw_posting);
+    MemPool_Resize(mem_pool, raw_posting, raw_post_bytes);
+
+    return raw_posting;
+}
+
+BM25PostingMatcher*
+BM25Post_Make_Matcher_IMP(BM25Posting *self, Similarity *sim,
+                          PostingList *plist, Compiler *compiler,
+                          bool need_score) {
+    BM25PostingMatcher *matcher
+        = (BM25PostingMatcher*)Class_Make_Obj(BM25POSTINGMATCHER);
+    UNUSED_VAR(self);
+    UNUSED_VAR(need_score);
+    return BM25PostMatcher_init(matcher, sim, plist, compiler,
+                                S_avg_length(plist));
+}
+
+// Add up the totals which BM25PostingWriter recorded for the field in every
+// segment of the snapshot.
+static float
+S_avg_length(PostingList *plist) {
+    if (!Obj_is_a((Obj*)plist, SEGPOSTINGLIST)) { return 0.0f; }
+    SegPostingListIVARS *const plist_ivars
+        = SegPList_IVARS((SegPostingList*)plist);
+    Vector *segments
+        = DataReader_Get_Segments((DataReader*)plist_ivars->plist_reader);
+    int64_t num_docs   = 0;
+    int64_t num_tokens = 0;
+    for (size_t i = 0, max = Vec_Get_Size(segments); i < max; i++) {
+        Segment *segment = (Segment*)Vec_Fetch(segments, i);
+        Hash *fields
+            = (Hash*)Seg_Fetch_Metadata_Utf8(segment, "bm25", 4);
+        Hash *totals = fields
+                       ? (Hash*)Hash_Fetch(fields, plist_ivars->field)
+                       : NULL;
+        if (!totals) { continue; }
+        Obj *docs   = Hash_Fetch_Utf8(totals, "docs", 4);
+        Obj *tokens = Hash_Fetch_Utf8(totals, "tokens", 6);
+        if (docs)   { num_docs   += Json_obj_to_i64(docs); }
+        if (tokens) { num_tokens += Json_obj_to_i64(tokens); }
+    }
+    return num_docs > 0 ? (float)num_tokens / (float)num_docs : 0.0f;
+}
+
+BM25PostingMatcher*
+BM25PostMatcher_init(BM25PostingMatcher *self, Similarity *sim,
+                     PostingList *plist, Compiler *compiler,
+                     float avg_length) {
+    ScorePostMatcher_init((ScorePostingMatcher*)self, sim, plist, compiler);
+    BM25PostingMatcherIVARS *const ivars = BM25PostMatcher_IVARS(self);
+    ivars->avg_length = avg_length;
+    return self;
+}
+
+float
+BM25PostMatcher_Get_Avg_Length_IMP(BM25PostingMatcher *self) {
+    return BM25PostMatcher_IVARS(self)->avg_length;
+}
+
+float
+BM25PostMatcher_Score_IMP(BM25PostingMatcher *self) {
+    BM25PostingMatcherIVARS *const ivars = BM25PostMatcher_IVARS(self);
+    BM25PostingIVARS *const posting_ivars
+        = BM25Post_IVARS((BM25Posting*)ivars->posting);
+    BM25Similarity *const sim = (BM25Similarity*)posting_ivars->sim;
+    const float tf_norm
+        = BM25Sim_TF_Norm(sim, (float)posting_ivars->freq,
+                          (float)posting_ivars->length, ivars->avg_length);
+
+    // Factor in the doc and field boosts.
+    return ivars->weight * tf_norm * posting_ivars->weight;
+}
+
+/***************************************************************************/
+
+BM25PostingWriter*
+BM25PostWriter_new(Schema *schema, Snapshot *snapshot, Segment *segment,
+                   PolyReader *polyreader, int32_t field_num) {
+    BM25PostingWriter *self
+        = (BM25PostingWriter*)Class_Make_Obj(BM25POSTINGWRITER);
+    return BM25PostWriter_init(self, schema, snapshot, segment, polyreader,
+                               field_num);
+}
+
+BM25PostingWriter*
+BM25PostWriter_init(BM25PostingWriter *self, Schema *schema,
+                    Snapshot *snapshot, Segment *segment,
+                    PolyReader *polyreader, int32_t field_num) {
+    MatchPostWriter_init((MatchPostingWriter*)self, schema, snapshot, segment,
+                         polyreader, field_num);
+    BM25PostingWriterIVARS *const ivars = BM25PostWriter_IVARS(self);
+    ivars->docs       = BitVec_new((size_t)Seg_Get_Count(segment) + 1);
+    ivars->num_tokens = 0;
+    return self;
+}
+
+void
+BM25PostWriter_Destroy_IMP(BM25PostingWriter *self) {
+    BM25PostingWriterIVARS *const ivars = BM25PostWriter_IVARS(self);
+    DECREF(ivars->docs);
+    SUPER_DESTROY(self, BM25POSTINGWRITER);
+}
+
+void
+BM25PostWriter_Write_Posting_IMP(BM25PostingWriter *self,
+                                 RawPosting *posting) {
+    BM25PostingWriterIVARS *const ivars = BM25PostWriter_IVARS(self);
+    RawPostingIVARS *const posting_ivars = RawPost_IVARS(posting);
+    BM25PostWriter_Write_Posting_t super_write_posting
+        = SUPER_METHOD_PTR(BM25POSTINGWRITER,
+                           LUCY_BM25PostWriter_Write_Posting);
+
+    // Each token of a field belongs to exactly one of its postings.
+    BitVec_Set(ivars->docs, (size_t)posting_ivars->doc_id);
+    ivars->num_tokens += posting_ivars->freq;
+    super_write_posting(self, posting);
+}
+
+void
+BM25PostWriter_Finish_IMP(BM25PostingWriter *self) {
+    BM25PostingWriterIVARS *const ivars = BM25PostWriter_IVARS(self);
+    Segment *segment = ivars->segment;
+    String  *field   = Seg_Field_Name(segment, ivars->field_num);
+    Hash    *fields  = (Hash*)Seg_Fetch_Metadata_Utf8(segment, "bm25", 4);
+    if (!fields) {
+        fields = Hash_new(0);
+        Seg_Store_Metadata_Utf8(segment, "bm25", 4, (Obj*)fields);
+    }
+    uint64_t num_docs = (uint64_t)BitVec_Count(ivars->docs);
+    Hash *totals = Hash_new(2);
+    Hash_Store_Utf8(totals, "docs", 4, (Obj*)Str_newf("%u64", num_docs));
+    Hash_Store_Utf8(totals, "tokens", 6,
+                    (Obj*)Str_newf("%i64", ivars->num_tokens));
+    Hash_Store(fields, field, (Obj*)totals);
+}
+
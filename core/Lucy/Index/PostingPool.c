@@ -333,6 +333,7 @@ PostPool_Finish_IMP(PostingPool *self) {
                                   ivars->field_num);
     LexWriter_Start_Field(ivars->lex_writer, ivars->field_num);
     S_write_terms_and_postings(self, post_writer, ivars->skip_out);
+    PostWriter_Finish(post_writer);
     LexWriter_Finish_Field(ivars->lex_writer, ivars->field_num);
     DECREF(post_writer);
 }
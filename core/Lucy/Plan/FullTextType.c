@@ -50,6 +50,7 @@ FullTextType_init2(FullTextType *self, Analyzer *analyzer, float boost,
     ivars->sortable      = sortable;
     ivars->highlightable = highlightable;
     ivars->analyzer      = (Analyzer*)INCREF(analyzer);
+    ivars->sim           = NULL;
 
     return self;
 }
@@ -58,6 +59,7 @@ void
 FullTextType_Destroy_IMP(FullTextType *self) {
     FullTextTypeIVARS *const ivars = FullTextType_IVARS(self);
     DECREF(ivars->analyzer);
+    DECREF(ivars->sim);
     SUPER_DESTROY(self, FULLTEXTTYPE);
 }
 
@@ -76,6 +78,10 @@ FullTextType_Equals_IMP(FullTextType *self, Obj *other) {
     if (!Analyzer_Equals(ivars->analyzer, (Obj*)ovars->analyzer)) {
         return false;
     }
+    if (!ivars->sim != !ovars->sim)                       { return false; }
+    if (ivars->sim && !Sim_Equals(ivars->sim, (Obj*)ovars->sim)) {
+        return false;
+    }
     return true;
 }
 
@@ -101,6 +107,9 @@ FullTextType_Dump_For_Schema_IMP(FullTextType *self) {
     if (ivars->highlightable) {
         Hash_Store_Utf8(dump, "highlightable", 13, (Obj*)CFISH_TRUE);
     }
+    if (ivars->sim) {
+        Hash_Store_Utf8(dump, "similarity", 10, Sim_Dump(ivars->sim));
+    }
 
     return dump;
 }
@@ -160,6 +169,15 @@ FullTextType_Load_IMP(FullTextType *self, Obj *dump) {
     FullTextType_init2(loaded, analyzer, boost, indexed, stored,
                        sortable, hl);
     DECREF(analyzer);
+
+    // Extract a Similarity, if one was set.
+    Obj *sim_dump = Hash_Fetch_Utf8(source, "similarity", 10);
+    if (sim_dump) {
+        Similarity *sim
+            = (Similarity*)CERTIFY(Freezer_load(sim_dump), SIMILARITY);
+        FullTextType_IVARS(loaded)->sim = sim;
+    }
+
     return loaded;
 }
 
@@ -178,10 +196,23 @@ FullTextType_Highlightable_IMP(FullTextType *self) {
     return FullTextType_IVARS(self)->highlightable;
 }
 
+void
+FullTextType_Set_Similarity_IMP(FullTextType *self, Similarity *sim) {
+    FullTextTypeIVARS *const ivars = FullTextType_IVARS(self);
+    Similarity *temp = ivars->sim;
+    ivars->sim = (Similarity*)INCREF(sim);
+    DECREF(temp);
+}
+
+Similarity*
+FullTextType_Get_Similarity_IMP(FullTextType *self) {
+    return FullTextType_IVARS(self)->sim;
+}
+
 Similarity*
 FullTextType_Make_Similarity_IMP(FullTextType *self) {
-    UNUSED_VAR(self);
-    return Sim_new();
+    FullTextTypeIVARS *const ivars = FullTextType_IVARS(self);
+    return ivars->sim ? (Similarity*)INCREF(ivars->sim) : Sim_new();
 }
 
 
@@ -224,6 +224,14 @@ Schema_Get_Similarity_IMP(Schema *self) {
     return Schema_IVARS(self)->sim;
 }
 
+void
+Schema_Set_Similarity_IMP(Schema *self, Similarity *sim) {
+    SchemaIVARS *const ivars = Schema_IVARS(self);
+    Similarity *temp = ivars->sim;
+    ivars->sim = (Similarity*)INCREF(sim);
+    DECREF(temp);
+}
+
 Vector*
 Schema_All_Fields_IMP(Schema *self) {
     return Hash_Keys(Schema_IVARS(self)->types);
@@ -260,6 +268,13 @@ Schema_Dump_IMP(Schema *self) {
     Hash_Store_Utf8(dump, "analyzers", 9,
                     Freezer_dump((Obj*)ivars->uniq_analyzers));
 
+    // Record a Similarity which differs from the Architecture's.
+    Similarity *arch_sim = Arch_Make_Similarity(ivars->arch);
+    if (!Sim_Equals(ivars->sim, (Obj*)arch_sim)) {
+        Hash_Store_Utf8(dump, "similarity", 10, Sim_Dump(ivars->sim));
+    }
+    DECREF(arch_sim);
+
     // Dump FieldTypes.
     Hash_Store_Utf8(dump, "fields", 6, (Obj*)type_dumps);
     HashIterator *iter = HashIter_new(ivars->types);
@@ -324,6 +339,14 @@ Schema_Load_IMP(Schema *self, Obj *dump) {
     SchemaIVARS *const loaded_ivars = Schema_IVARS(loaded);
     Vec_Grow(loaded_ivars->uniq_analyzers, Vec_Get_Size(analyzers));
 
+    Obj *sim_dump = Hash_Fetch_Utf8(source, "similarity", 10);
+    if (sim_dump) {
+        Similarity *sim
+            = (Similarity*)CERTIFY(Freezer_load(sim_dump), SIMILARITY);
+        Schema_Set_Similarity(loaded, sim);
+        DECREF(sim);
+    }
+
     HashIterator *iter = HashIter_new(type_dumps);
     while (HashIter_Next(iter)) {
         String *field     = HashIter_Get_Key(iter);
@@ -21,6 +21,7 @@
 #include "Clownfish/Boolean.h"
 #include "Lucy/Index/Posting/ScorePosting.h"
 #include "Lucy/Index/Similarity.h"
+#include "Lucy/Util/Freezer.h"
 #include "Lucy/Util/Json.h"
 
 StringType*
@@ -43,9 +44,17 @@ StringType_init2(StringType *self, float boost, bool indexed,
     ivars->indexed    = indexed;
     ivars->stored     = stored;
     ivars->sortable   = sortable;
+    ivars->sim        = NULL;
     return self;
 }
 
+void
+StringType_Destroy_IMP(StringType *self) {
+    StringTypeIVARS *const ivars = StringType_IVARS(self);
+    DECREF(ivars->sim);
+    SUPER_DESTROY(self, STRINGTYPE);
+}
+
 bool
 StringType_Equals_IMP(StringType *self, Obj *other) {
     if ((StringType*)other == self) { return true; }
@@ -53,6 +62,12 @@ StringType_Equals_IMP(StringType *self, Obj *other) {
         = (StringType_Equals_t)SUPER_METHOD_PTR(STRINGTYPE,
                                                 LUCY_StringType_Equals);
     if (!super_equals(self, other)) { return false; }
+    StringTypeIVARS *const ivars = StringType_IVARS(self);
+    StringTypeIVARS *const ovars = StringType_IVARS((StringType*)other);
+    if (!ivars->sim != !ovars->sim) { return false; }
+    if (ivars->sim && !Sim_Equals(ivars->sim, (Obj*)ovars->sim)) {
+        return false;
+    }
     return true;
 }
 
@@ -75,6 +90,9 @@ StringType_Dump_For_Schema_IMP(StringType *self) {
     if (ivars->sortable) {
         Hash_Store_Utf8(dump, "sortable", 8, (Obj*)CFISH_TRUE);
     }
+    if (ivars->sim) {
+        Hash_Store_Utf8(dump, "similarity", 10, Sim_Dump(ivars->sim));
+    }
 
     return dump;
 }
@@ -108,13 +126,36 @@ StringType_Load_IMP(StringType *self, Obj *dump) {
     bool  stored   = stored_dump   ? Json_obj_to_bool(stored_dump)      : true;
     bool  sortable = sortable_dump ? Json_obj_to_bool(sortable_dump)    : false;
 
-    return StringType_init2(loaded, boost, indexed, stored, sortable);
+    StringType_init2(loaded, boost, indexed, stored, sortable);
+
+    // Extract a Similarity, if one was set.
+    Obj *sim_dump = Hash_Fetch_Utf8(source, "similarity", 10);
+    if (sim_dump) {
+        Similarity *sim
+            = (Similarity*)CERTIFY(Freezer_load(sim_dump), SIMILARITY);
+        StringType_IVARS(loaded)->sim = sim;
+    }
+
+    return loaded;
+}
+
+void
+StringType_Set_Similarity_IMP(StringType *self, Similarity *sim) {
+    StringTypeIVARS *const ivars = StringType_IVARS(self);
+    Similarity *temp = ivars->sim;
+    ivars->sim = (Similarity*)INCREF(sim);
+    DECREF(temp);
+}
+
+Similarity*
+StringType_Get_Similarity_IMP(StringType *self) {
+    return StringType_IVARS(self)->sim;
 }
 
 Similarity*
 StringType_Make_Similarity_IMP(StringType *self) {
-    UNUSED_VAR(self);
-    return Sim_new();
+    StringTypeIVARS *const ivars = StringType_IVARS(self);
+    return ivars->sim ? (Similarity*)INCREF(ivars->sim) : Sim_new();
 }
 
 Posting*
@@ -177,9 +177,16 @@ func specClasses(parcel *cfc.Parcel) {
 	heatMapBinding.SpecMethod("Get_Spans", "getSpans() []Span")
 	heatMapBinding.Register()
 
+	highlighterBinding := cfc.NewGoClass(parcel, "Lucy::Highlight::Highlighter")
+	highlighterBinding.SpecMethod("", "Excerpt(HitDoc) (string, error)")
+	highlighterBinding.Register()
+
 	indexerBinding := cfc.NewGoClass(parcel, "Lucy::Index::Indexer")
-	indexerBinding.SpecMethod("", "Close() error")
+	indexerBinding.SpecMethod("Close", "Close() error")
+	indexerBinding.SpecMethod("Rollback", "Rollback() error")
 	indexerBinding.SpecMethod("Add_Doc", "AddDoc(doc interface{}) error")
+	indexerBinding.SpecMethod("", "AddDocWithBoost(doc interface{}, boost float32) error")
+	indexerBinding.SpecMethod("", "UpdateDoc(keyField string, doc interface{}) (bool, error)")
 	indexerBinding.SpecMethod("Add_Index", "AddIndex(interface{}) error")
 	indexerBinding.SpecMethod("Delete_By_Term", "DeleteByTerm(string, interface{}) error")
 	indexerBinding.SpecMethod("Delete_By_Query", "DeleteByQuery(Query) error")
@@ -301,19 +308,35 @@ func specClasses(parcel *cfc.Parcel) {
 	searcherBinding.SpecMethod("Fetch_Doc", "FetchDoc(int32) (HitDoc, error)")
 	searcherBinding.SpecMethod("Fetch_Doc_Vec", "fetchDocVec(int32) (DocVector, error)")
 	searcherBinding.SpecMethod("", "ReadDoc(int32, interface{}) error")
+	searcherBinding.SpecMethod("Collect", "Collect(query interface{}, collector interface{}) error")
+	searcherBinding.SpecMethod("", "Facets(query interface{}, fields []string, topN int, minCount int) ([]Facet, error)")
+	searcherBinding.SpecMethod("", "Aggregate(query interface{}, aggs []Aggregation) ([]Stats, error)")
+	searcherBinding.SpecMethod("", "GroupedHits(query interface{}, field string, offset, numGroups, groupSize int) (HitGroups, error)")
+	searcherBinding.SpecMethod("", "HitsAfter(query interface{}, after string, numWanted uint32, sortSpec SortSpec) (Hits, error)")
+	searcherBinding.SpecMethod("", "Scan(query interface{}, fields []string) ScanHits")
+	searcherBinding.SpecMethod("", "Explain(query interface{}, docID int32) (*Explanation, error)")
 	searcherBinding.Register()
 
+	polySearcherBinding := cfc.NewGoClass(parcel, "Lucy::Search::PolySearcher")
+	polySearcherBinding.SpecMethod("Get_Searchers", "GetSearchers() []Searcher")
+	polySearcherBinding.SetSuppressCtor(true)
+	polySearcherBinding.Register()
+
 	qParserBinding := cfc.NewGoClass(parcel, "Lucy::Search::QueryParser")
 	qParserBinding.SetSuppressCtor(true)
 	qParserBinding.SpecMethod("Make_Phrase_Query", "MakePhraseQuery(string, []interface{}) PhraseQuery")
 	qParserBinding.SpecMethod("Make_AND_Query", "MakeANDQuery([]Query) ANDQuery")
 	qParserBinding.SpecMethod("Make_OR_Query", "MakeORQuery([]Query) ORQuery")
 	qParserBinding.SpecMethod("Get_Fields", "getFields() []string")
+	qParserBinding.SpecMethod("", "EnableTermExpansion(args *TermExpansionArgs) error")
 	qParserBinding.Register()
 
 	hitsBinding := cfc.NewGoClass(parcel, "Lucy::Search::Hits")
 	hitsBinding.SpecMethod("Next", "Next(hit interface{}) bool")
 	hitsBinding.SpecMethod("", "Error() error")
+	hitsBinding.SpecMethod("", "nextMatch() (int32, float32, bool)")
+	hitsBinding.SpecMethod("", "AddHighlighter(Highlighter)")
+	hitsBinding.SpecMethod("", "Cursor() string")
 	hitsBinding.SetSuppressStruct(true)
 	hitsBinding.Register()
 
@@ -0,0 +1,59 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * lucy-searchd serves an index to clients created with
+ * lucy.OpenRemoteSearcher.
+ *
+ *     lucy-searchd -index /path/to/index -addr :7890
+ */
+
+package main
+
+import "git-wip-us.apache.org/repos/asf/lucy.git/go/lucy"
+import "errors"
+import "flag"
+import "log"
+import "net"
+
+func main() {
+	index := flag.String("index", "", "path to the index to serve")
+	network := flag.String("network", "tcp", "network to listen on")
+	addr := flag.String("addr", ":7890", "address to listen on")
+	flag.Parse()
+	if err := run(*index, *network, *addr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Serve the index until the listener fails.
+func run(index, network, addr string) error {
+	if index == "" {
+		return errors.New("-index is required")
+	}
+	searcher, err := lucy.OpenIndexSearcher(index)
+	if err != nil {
+		return err
+	}
+	defer searcher.Close()
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving %s on %s", index, listener.Addr())
+	return lucy.NewSearchServer(searcher).Serve(listener)
+}
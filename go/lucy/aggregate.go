@@ -0,0 +1,327 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "Clownfish/Obj.h"
+*/
+import "C"
+import "fmt"
+import "math"
+import "sort"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// Aggregation requests statistics for a sortable numeric field.
+type Aggregation struct {
+	Field string
+	// Percentiles to estimate, each between 0 and 100.
+	Percentiles []float64
+}
+
+// Stats holds the statistics for an Aggregation over the docs matching a
+// query.  Docs with no value for the field are counted in Missing and
+// otherwise ignored.  If Count is 0, Min, Max and Mean are NaN.
+type Stats struct {
+	Field   string
+	Count   int
+	Missing int
+	Min     float64
+	Max     float64
+	Sum     float64
+	Mean    float64
+	// Approximate values at the requested percentiles, keyed by percentile.
+	Percentiles map[float64]float64
+}
+
+// Running totals for one Aggregation.
+type aggState struct {
+	agg    Aggregation
+	stats  Stats
+	digest *tDigest
+}
+
+// AggregateCollector is a CustomCollector which computes statistics for
+// sortable numeric fields over the docs it collects, reading values from
+// each segment's NumericSortCache.  Percentiles are estimated with a
+// t-digest.
+type AggregateCollector struct {
+	aggs     []*aggState
+	segments []facetSegment
+	reader   SegReader
+	err      error
+}
+
+// NewAggregateCollector creates an AggregateCollector for the supplied
+// aggregations.  Collecting with a field which is unknown, unsortable or not
+// numeric fails.
+func NewAggregateCollector(aggs []Aggregation) *AggregateCollector {
+	ac := &AggregateCollector{}
+	for _, agg := range aggs {
+		for _, pct := range agg.Percentiles {
+			if pct < 0 || pct > 100 || math.IsNaN(pct) {
+				mess := fmt.Sprintf("Invalid percentile for '%s': %v", agg.Field, pct)
+				ac.err = clownfish.NewErr(mess)
+			}
+		}
+		state := &aggState{
+			agg: agg,
+			stats: Stats{
+				Field: agg.Field,
+				Min:   math.Inf(1),
+				Max:   math.Inf(-1),
+			},
+		}
+		if len(agg.Percentiles) > 0 {
+			state.digest = newTDigest(100)
+		}
+		ac.aggs = append(ac.aggs, state)
+	}
+	return ac
+}
+
+// Verify that every aggregated field is known to the Schema, sortable and
+// numeric.
+func (ac *AggregateCollector) checkFields(schema Schema) error {
+	for _, state := range ac.aggs {
+		if err := checkFacetField(schema, state.agg.Field, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ac *AggregateCollector) SetReader(reader SegReader) {
+	ac.flush()
+	ac.reader = reader
+	if ac.err == nil {
+		ac.err = ac.checkFields(reader.GetSchema())
+	}
+	ac.segments = make([]facetSegment, len(ac.aggs))
+	for i, state := range ac.aggs {
+		seg, err := openFacetSegment(reader, state.agg.Field)
+		if err != nil {
+			ac.err = err
+		}
+		ac.segments[i] = seg
+	}
+}
+
+func (ac *AggregateCollector) SetBase(base int32) {}
+
+func (ac *AggregateCollector) NeedScore() bool {
+	return false
+}
+
+func (ac *AggregateCollector) Collect(docID int32) error {
+	if ac.err != nil {
+		return ac.err
+	}
+	for i := range ac.segments {
+		if !ac.segments[i].collect(docID) {
+			ac.aggs[i].stats.Missing++
+		}
+	}
+	return nil
+}
+
+// Fold the ordinal counts for the current segment into the running totals.
+// Since all docs which share an ordinal share a value, each ordinal is
+// visited once no matter how many docs it covers.
+func (ac *AggregateCollector) flush() {
+	for i := range ac.segments {
+		seg := &ac.segments[i]
+		state := ac.aggs[i]
+		if seg.sortCache == nil {
+			continue
+		}
+		for ord, count := range seg.ordCounts {
+			if count == 0 {
+				continue
+			}
+			if int32(ord) == seg.nullOrd {
+				state.stats.Missing += count
+				continue
+			}
+			value, err := seg.sortCache.Value(int32(ord))
+			if err != nil {
+				ac.err = err
+				continue
+			}
+			if value == nil {
+				state.stats.Missing += count
+				continue
+			}
+			num, ok := facetNumber(value)
+			if !ok {
+				mess := fmt.Sprintf("Field '%s' isn't numeric", state.agg.Field)
+				ac.err = clownfish.NewErr(mess)
+				continue
+			}
+			state.add(num, count)
+		}
+	}
+	ac.segments = nil
+	ac.reader = nil
+}
+
+func (state *aggState) add(num float64, count int) {
+	stats := &state.stats
+	stats.Count += count
+	stats.Sum += num * float64(count)
+	stats.Min = math.Min(stats.Min, num)
+	stats.Max = math.Max(stats.Max, num)
+	if state.digest != nil {
+		state.digest.add(num, float64(count))
+	}
+}
+
+// Stats returns the statistics for each aggregation, in the order they were
+// supplied.
+func (ac *AggregateCollector) Stats() ([]Stats, error) {
+	ac.flush()
+	if ac.err != nil {
+		return nil, ac.err
+	}
+	retval := make([]Stats, len(ac.aggs))
+	for i, state := range ac.aggs {
+		stats := state.stats
+		if stats.Count == 0 {
+			stats.Min, stats.Max, stats.Mean = math.NaN(), math.NaN(), math.NaN()
+		} else {
+			stats.Mean = stats.Sum / float64(stats.Count)
+		}
+		if state.digest != nil {
+			stats.Percentiles = make(map[float64]float64)
+			for _, pct := range state.agg.Percentiles {
+				stats.Percentiles[pct] = state.digest.quantile(pct / 100)
+			}
+		}
+		retval[i] = stats
+	}
+	return retval, nil
+}
+
+// Aggregate computes statistics for sortable numeric fields over the docs
+// matching the query.  Other fields are an error.
+func (s *SearcherIMP) Aggregate(query interface{}, aggs []Aggregation) ([]Stats, error) {
+	collector := NewAggregateCollector(aggs)
+	searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+		clownfish.Unwrap(s, "s")))).(Searcher)
+	if err := collector.checkFields(searcher.GetSchema()); err != nil {
+		return nil, err
+	}
+	err := searcher.Collect(query, collector)
+	if err != nil {
+		return nil, err
+	}
+	return collector.Stats()
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tDigest estimates quantiles from a stream of weighted values using
+// Dunning's merging t-digest.  Centroids near the tails are kept small so
+// that extreme quantiles stay accurate.
+type tDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	total       float64
+	min         float64
+	max         float64
+}
+
+func newTDigest(compression float64) *tDigest {
+	return &tDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func (td *tDigest) add(value, weight float64) {
+	td.buffer = append(td.buffer, centroid{value, weight})
+	td.total += weight
+	td.min = math.Min(td.min, value)
+	td.max = math.Max(td.max, value)
+	if len(td.buffer) >= int(5*td.compression) {
+		td.compress()
+	}
+}
+
+// Merge buffered values into the centroids.
+func (td *tDigest) compress() {
+	if len(td.buffer) == 0 {
+		return
+	}
+	all := append(td.centroids, td.buffer...)
+	td.buffer = nil
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	merged := make([]centroid, 0, len(all))
+	cur := all[0]
+	weightSoFar := 0.0
+	for _, c := range all[1:] {
+		q := (weightSoFar + (cur.weight+c.weight)/2) / td.total
+		limit := 4 * td.total * q * (1 - q) / td.compression
+		if cur.weight+c.weight <= limit {
+			newWeight := cur.weight + c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / newWeight
+			cur.weight = newWeight
+		} else {
+			weightSoFar += cur.weight
+			merged = append(merged, cur)
+			cur = c
+		}
+	}
+	td.centroids = append(merged, cur)
+}
+
+// Estimate the value at quantile q, interpolating between the midpoints of
+// adjacent centroids.
+func (td *tDigest) quantile(q float64) float64 {
+	td.compress()
+	cents := td.centroids
+	if len(cents) == 0 {
+		return math.NaN()
+	}
+	if len(cents) == 1 {
+		return cents[0].mean
+	}
+	index := q * td.total
+	first := cents[0]
+	if index < first.weight/2 {
+		return td.min + (first.mean-td.min)*index/(first.weight/2)
+	}
+	cumulative := 0.0
+	for i := 0; i < len(cents)-1; i++ {
+		left := cumulative + cents[i].weight/2
+		right := cumulative + cents[i].weight + cents[i+1].weight/2
+		if index <= right {
+			t := (index - left) / (right - left)
+			return cents[i].mean + t*(cents[i+1].mean-cents[i].mean)
+		}
+		cumulative += cents[i].weight
+	}
+	last := cents[len(cents)-1]
+	left := td.total - last.weight/2
+	return last.mean + (td.max-last.mean)*(index-left)/(last.weight/2)
+}
@@ -0,0 +1,86 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import "math"
+import "testing"
+
+func TestAggregate(t *testing.T) {
+	index := createPriceTestIndex(2.5, 9.99, 10, 12, 49, 75, 120)
+	searcher, _ := OpenIndexSearcher(index)
+
+	aggs := []Aggregation{{Field: "price", Percentiles: []float64{50}}}
+	results, err := searcher.Aggregate("widget", aggs)
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	stats := results[0]
+	if stats.Field != "price" || stats.Count != 7 || stats.Missing != 0 {
+		t.Errorf("Count: %+v", stats)
+	}
+	if stats.Min != 2.5 || stats.Max != 120 {
+		t.Errorf("Min/Max: %v, %v", stats.Min, stats.Max)
+	}
+	if math.Abs(stats.Sum-278.49) > 1e-9 || math.Abs(stats.Mean-278.49/7) > 1e-9 {
+		t.Errorf("Sum/Mean: %v, %v", stats.Sum, stats.Mean)
+	}
+	if median := stats.Percentiles[50]; median != 12 {
+		t.Errorf("Median: %v", median)
+	}
+
+	results, _ = searcher.Aggregate("nomatch", []Aggregation{{Field: "price"}})
+	if results[0].Count != 0 || !math.IsNaN(results[0].Mean) {
+		t.Errorf("No matches: %+v", results[0])
+	}
+
+	bogus := []Aggregation{{Field: "price", Percentiles: []float64{150}}}
+	if _, err := searcher.Aggregate("widget", bogus); err == nil {
+		t.Error("Out of range percentile should fail")
+	}
+	if _, err := searcher.Aggregate("widget", []Aggregation{{Field: "title"}}); err == nil {
+		t.Error("Unsortable field should fail")
+	}
+	if _, err := searcher.Aggregate("nomatch", []Aggregation{{Field: "nope"}}); err == nil {
+		t.Error("Unknown field should fail even without matches")
+	}
+
+	collector := NewAggregateCollector([]Aggregation{{Field: "title"}})
+	if err := searcher.Collect("widget", collector); err == nil {
+		t.Error("AggregateCollector on unsortable field should fail")
+	}
+
+	textSearcher, _ := OpenIndexSearcher(createTestIndex("a"))
+	if _, err := textSearcher.Aggregate("a", []Aggregation{{Field: "content"}}); err == nil {
+		t.Error("Non-numeric field should fail")
+	}
+}
+
+func TestTDigest(t *testing.T) {
+	digest := newTDigest(100)
+	for i := 0; i < 100000; i++ {
+		digest.add(float64(i%1000), 1)
+	}
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.999} {
+		expected := q * 1000
+		if got := digest.quantile(q); math.Abs(got-expected) > 10 {
+			t.Errorf("Quantile %v: expected %v, got %v", q, expected, got)
+		}
+	}
+	if len(digest.centroids) > 1000 {
+		t.Errorf("Digest didn't compress: %d centroids", len(digest.centroids))
+	}
+}
@@ -20,11 +20,53 @@ package lucy
 #include <stdlib.h>
 
 #include "Lucy/Analysis/Analyzer.h"
+#include "Lucy/Analysis/Inversion.h"
 #include "Lucy/Analysis/PolyAnalyzer.h"
 #include "Lucy/Analysis/Token.h"
+#include "Clownfish/Class.h"
+#include "Clownfish/Hash.h"
+#include "Clownfish/String.h"
 #include "Clownfish/Vector.h"
+
+extern lucy_Inversion*
+GOLUCY_GoAnalyzer_Transform(lucy_Analyzer *self, lucy_Inversion *inversion);
+extern cfish_Obj*
+GOLUCY_GoAnalyzer_Dump(lucy_Analyzer *self);
+extern cfish_Obj*
+GOLUCY_GoAnalyzer_Load(lucy_Analyzer *self, cfish_Obj *dump);
+extern bool
+GOLUCY_GoAnalyzer_Equals(lucy_Analyzer *self, cfish_Obj *other);
+extern void
+GOLUCY_GoAnalyzer_Destroy(lucy_Analyzer *self);
+
+// Create a subclass of Analyzer whose methods are implemented by Go
+// CustomAnalyzers.
+static cfish_Class*
+init_go_analyzer_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Analysis::GoAnalyzer");
+	cfish_Class *klass = cfish_Class_singleton(name, LUCY_ANALYZER);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoAnalyzer_Transform,
+						 LUCY_Analyzer_Transform_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoAnalyzer_Dump,
+						 LUCY_Analyzer_Dump_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoAnalyzer_Load,
+						 LUCY_Analyzer_Load_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoAnalyzer_Equals,
+						 CFISH_Obj_Equals_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoAnalyzer_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return klass;
+}
+
+static lucy_Analyzer*
+make_go_analyzer(cfish_Class *klass) {
+	return lucy_Analyzer_init((lucy_Analyzer*)CFISH_Class_Make_Obj(klass));
+}
 */
 import "C"
+import "fmt"
+import "iter"
 import "unsafe"
 
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
@@ -37,6 +79,19 @@ func NewToken(text string) Token {
 	return WRAPToken(unsafe.Pointer(objC))
 }
 
+// NewTokenWithOffsets creates a Token whose start and end offsets, measured
+// in Unicode code points, locate it within the original field value.
+// Custom Analyzers which break a Token into pieces should use this to
+// preserve offsets for highlighting.
+func NewTokenWithOffsets(text string, startOffset, endOffset uint32) Token {
+	chars := C.CString(text)
+	defer C.free(unsafe.Pointer(chars))
+	size := C.size_t(len(text))
+	objC := C.lucy_Token_new(chars, size, C.uint32_t(startOffset),
+		C.uint32_t(endOffset), 1.0, 1)
+	return WRAPToken(unsafe.Pointer(objC))
+}
+
 func (t *TokenIMP) SetText(text string) {
 	self := (*C.lucy_Token)(clownfish.Unwrap(t, "t"))
 	chars := C.CString(text)
@@ -81,3 +136,151 @@ func (p *PolyAnalyzerIMP) GetAnalyzers() []Analyzer {
 	}
 	return retval
 }
+
+// Tokens returns an iterator over the Tokens in an Inversion.  The Inversion
+// is reset before iteration begins.
+func Tokens(inversion Inversion) iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		inversion.Reset()
+		for token := inversion.Next(); token != nil; token = inversion.Next() {
+			if !yield(token) {
+				return
+			}
+		}
+	}
+}
+
+// TransformTokens builds a new Inversion by passing each Token in the
+// supplied Inversion through `transform`, which may return zero, one or many
+// Tokens in its place.
+func TransformTokens(inversion Inversion, transform func(Token) []Token) Inversion {
+	retval := NewInversion(nil)
+	for token := range Tokens(inversion) {
+		for _, newToken := range transform(token) {
+			retval.Append(newToken)
+		}
+	}
+	return retval
+}
+
+// CustomAnalyzer is implemented by Go types which transform an Inversion.
+// Wrap one with NewCustomAnalyzer to use it anywhere an Analyzer is
+// accepted, such as in a FullTextType or a PolyAnalyzer.
+type CustomAnalyzer interface {
+	Transform(inversion Inversion) Inversion
+}
+
+type customAnalyzerEntry struct {
+	name string
+	impl CustomAnalyzer
+}
+
+var goAnalyzerClass *C.cfish_Class
+
+// Named CustomAnalyzers, used to reconstitute analyzers loaded from a
+// serialized Schema.
+var customAnalyzers = newNameRegistry("CustomAnalyzer")
+
+// The CustomAnalyzers backing live GoAnalyzer objects, keyed by C pointer.
+var goAnalyzerObjs = newHostObjRegistry()
+
+func initGoAnalyzerClass() {
+	goAnalyzerClass = C.init_go_analyzer_class()
+	clownfish.RegisterWrapFuncs(map[unsafe.Pointer]clownfish.WrapFunc{
+		unsafe.Pointer(goAnalyzerClass): WRAPAnalyzerASOBJ,
+	})
+}
+
+// NewCustomAnalyzer wraps a CustomAnalyzer as an Analyzer.  The name
+// identifies the analyzer when a Schema is serialized: an index which uses a
+// custom analyzer can only be opened after an analyzer with the same name
+// has been created in the current process.  Reusing a name for a different
+// CustomAnalyzer is an error.
+func NewCustomAnalyzer(name string, impl CustomAnalyzer) (Analyzer, error) {
+	if err := customAnalyzers.register(name, impl); err != nil {
+		return nil, err
+	}
+	return WRAPAnalyzer(unsafe.Pointer(newGoAnalyzer(name, impl))), nil
+}
+
+// Find the CustomAnalyzer created under a name.
+func lookupCustomAnalyzer(name string) (CustomAnalyzer, bool) {
+	impl, ok := customAnalyzers.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return impl.(CustomAnalyzer), true
+}
+
+func newGoAnalyzer(name string, impl CustomAnalyzer) *C.lucy_Analyzer {
+	objC := C.make_go_analyzer(goAnalyzerClass)
+	goAnalyzerObjs.store(unsafe.Pointer(objC), &customAnalyzerEntry{name, impl})
+	return objC
+}
+
+func fetchGoAnalyzer(self *C.lucy_Analyzer) *customAnalyzerEntry {
+	entry, ok := goAnalyzerObjs.fetch(unsafe.Pointer(self)).(*customAnalyzerEntry)
+	if !ok {
+		panic(clownfish.NewErr("No CustomAnalyzer registered for GoAnalyzer"))
+	}
+	return entry
+}
+
+//export GOLUCY_GoAnalyzer_Transform
+func GOLUCY_GoAnalyzer_Transform(self *C.lucy_Analyzer,
+	inversion *C.lucy_Inversion) *C.lucy_Inversion {
+	entry := fetchGoAnalyzer(self)
+	invGo := WRAPInversion(unsafe.Pointer(C.cfish_incref(unsafe.Pointer(inversion))))
+	retval := entry.impl.Transform(invGo)
+	if retval == nil {
+		mess := fmt.Sprintf("CustomAnalyzer '%s' returned a nil Inversion", entry.name)
+		panic(clownfish.NewErr(mess))
+	}
+	retvalC := clownfish.Unwrap(retval, "retval")
+	return (*C.lucy_Inversion)(C.cfish_incref(retvalC))
+}
+
+//export GOLUCY_GoAnalyzer_Dump
+func GOLUCY_GoAnalyzer_Dump(self *C.lucy_Analyzer) *C.cfish_Obj {
+	entry := fetchGoAnalyzer(self)
+	dump := map[string]interface{}{
+		"_class": "Lucy::Analysis::GoAnalyzer",
+		"name":   entry.name,
+	}
+	return (*C.cfish_Obj)(clownfish.GoToClownfish(dump,
+		unsafe.Pointer(C.CFISH_HASH), false))
+}
+
+//export GOLUCY_GoAnalyzer_Load
+func GOLUCY_GoAnalyzer_Load(self *C.lucy_Analyzer, dump *C.cfish_Obj) *C.cfish_Obj {
+	dumpGo, ok := clownfish.ToGo(unsafe.Pointer(dump)).(map[string]interface{})
+	if !ok {
+		panic(clownfish.NewErr("GoAnalyzer dump is not a Hash"))
+	}
+	name, _ := dumpGo["name"].(string)
+	impl, ok := lookupCustomAnalyzer(name)
+	if !ok {
+		mess := fmt.Sprintf("No CustomAnalyzer named '%s' has been created", name)
+		panic(clownfish.NewErr(mess))
+	}
+	return (*C.cfish_Obj)(unsafe.Pointer(newGoAnalyzer(name, impl)))
+}
+
+//export GOLUCY_GoAnalyzer_Equals
+func GOLUCY_GoAnalyzer_Equals(self *C.lucy_Analyzer, other *C.cfish_Obj) C.bool {
+	if unsafe.Pointer(self) == unsafe.Pointer(other) {
+		return true
+	}
+	if C.cfish_Obj_get_class(other) != goAnalyzerClass {
+		return false
+	}
+	entry, ok := goAnalyzerObjs.fetch(unsafe.Pointer(self)).(*customAnalyzerEntry)
+	otherEntry, otherOK := goAnalyzerObjs.fetch(unsafe.Pointer(other)).(*customAnalyzerEntry)
+	return C.bool(ok && otherOK && entry.name == otherEntry.name)
+}
+
+//export GOLUCY_GoAnalyzer_Destroy
+func GOLUCY_GoAnalyzer_Destroy(self *C.lucy_Analyzer) {
+	goAnalyzerObjs.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), goAnalyzerClass)
+}
@@ -17,7 +17,11 @@
 package lucy
 
 import "testing"
+import "fmt"
 import "reflect"
+import "slices"
+import "strings"
+import "unicode"
 
 func TestTokenBasics(t *testing.T) {
 	token := NewToken("foo")
@@ -119,3 +123,146 @@ func TestRegexTokenizerSplit(t *testing.T) {
 		t.Errorf("Expected %v, got %v", expected, got)
 	}
 }
+
+type skuTokenizer struct{}
+
+// Split on whitespace, keeping hyphenated SKUs together but also emitting
+// each hyphen-delimited part.  Offsets are counted in code points.
+func (skuTokenizer) Transform(inversion Inversion) Inversion {
+	return TransformTokens(inversion, func(token Token) []Token {
+		var tokens []Token
+		runes := []rune(token.GetText())
+		base := token.GetStartOffset()
+		for i := 0; i < len(runes); {
+			if unicode.IsSpace(runes[i]) {
+				i++
+				continue
+			}
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			word := runes[start:i]
+			tokens = append(tokens, newSKUToken(word, base+uint32(start)))
+			if !slices.Contains(word, '-') {
+				continue
+			}
+			partStart := 0
+			for j := 0; j <= len(word); j++ {
+				if j == len(word) || word[j] == '-' {
+					if j > partStart {
+						offset := base + uint32(start+partStart)
+						tokens = append(tokens, newSKUToken(word[partStart:j], offset))
+					}
+					partStart = j + 1
+				}
+			}
+		}
+		return tokens
+	})
+}
+
+func newSKUToken(text []rune, start uint32) Token {
+	return NewTokenWithOffsets(string(text), start, start+uint32(len(text)))
+}
+
+func TestCustomAnalyzer(t *testing.T) {
+	analyzer, err := NewCustomAnalyzer("test-sku", skuTokenizer{})
+	if err != nil {
+		t.Fatalf("NewCustomAnalyzer: %v", err)
+	}
+	runAnalyzerTests(t, analyzer)
+
+	got := analyzer.Split("AB-12 foo")
+	expected := []string{"AB-12", "AB", "12", "foo"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Split: %v", got)
+	}
+
+	var offsets []string
+	for token := range Tokens(analyzer.TransformText("Café AB-12")) {
+		offsets = append(offsets, fmt.Sprintf("%s:%d-%d", token.GetText(),
+			token.GetStartOffset(), token.GetEndOffset()))
+	}
+	expected = []string{"Café:0-4", "AB-12:5-10", "AB:5-7", "12:8-10"}
+	if !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("Offsets: %v", offsets)
+	}
+
+	// The same implementation may be registered again, a different one may not.
+	if _, err := NewCustomAnalyzer("test-sku", skuTokenizer{}); err != nil {
+		t.Errorf("Re-registering the same CustomAnalyzer: %v", err)
+	}
+	if _, err := NewCustomAnalyzer("test-sku", otherTokenizer{}); err == nil {
+		t.Error("Registering a different CustomAnalyzer under a taken name should fail")
+	}
+
+	polyAnalyzer := NewPolyAnalyzer([]Analyzer{analyzer, NewCaseFolder()})
+	runAnalyzerTests(t, polyAnalyzer)
+	got = polyAnalyzer.Split("AB-12")
+	expected = []string{"ab-12", "ab", "12"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Split in PolyAnalyzer: %v", got)
+	}
+	if _, ok := polyAnalyzer.GetAnalyzers()[0].(Analyzer); !ok {
+		t.Errorf("GetAnalyzers didn't wrap custom analyzer")
+	}
+}
+
+func TestCustomAnalyzerIndexing(t *testing.T) {
+	schema := NewSchema()
+	skuAnalyzer, err := NewCustomAnalyzer("test-sku", skuTokenizer{})
+	if err != nil {
+		t.Fatalf("NewCustomAnalyzer: %v", err)
+	}
+	analyzer := NewPolyAnalyzer([]Analyzer{skuAnalyzer, NewCaseFolder()})
+	fieldType := NewFullTextType(analyzer)
+	fieldType.SetHighlightable(true)
+	schema.SpecField("content", fieldType)
+	folder := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Schema: schema, Index: folder, Create: true})
+	indexer.AddDoc(&testDoc{"Café XY-99 part"})
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// Opening the searcher loads the Schema, reconstituting the analyzer.
+	searcher, err := OpenIndexSearcher(folder)
+	if err != nil {
+		t.Fatalf("OpenIndexSearcher: %v", err)
+	}
+	for _, term := range []string{"xy-99", "99"} {
+		hits, _ := searcher.Hits(NewTermQuery("content", term), 0, 10, nil)
+		if hits.TotalHits() != 1 {
+			t.Errorf("Expected a hit for '%s'", term)
+		}
+	}
+
+	// The highlighter relies on the code point offsets.
+	hl := NewHighlighter(searcher, "99", "content", 200)
+	doc, _ := searcher.FetchDoc(1)
+	excerpt, err := hl.Excerpt(doc)
+	if err != nil || !strings.Contains(excerpt, "XY-<strong>99</strong> part") {
+		t.Errorf("Excerpt: '%s', %v", excerpt, err)
+	}
+}
+
+type otherTokenizer struct{}
+
+func (otherTokenizer) Transform(inversion Inversion) Inversion {
+	return inversion
+}
+
+func TestTokens(t *testing.T) {
+	inv := NewInversion(nil)
+	inv.Append(NewToken("foo"))
+	inv.Append(NewToken("bar"))
+	inv.Next()
+	var texts []string
+	for token := range Tokens(inv) {
+		texts = append(texts, token.GetText())
+	}
+	if !reflect.DeepEqual(texts, []string{"foo", "bar"}) {
+		t.Errorf("Tokens: %v", texts)
+	}
+}
@@ -0,0 +1,384 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#define C_LUCY_HITS
+
+#include "Lucy/Search/Hits.h"
+#include "Lucy/Search/MatchDoc.h"
+#include "Lucy/Search/Searcher.h"
+#include "Lucy/Search/SortRule.h"
+#include "Lucy/Search/SortSpec.h"
+#include "Lucy/Search/TopDocs.h"
+#include "Lucy/Search/Collector.h"
+#include "Lucy/Search/Collector/SortCollector.h"
+#include "Lucy/Index/SegReader.h"
+#include "Lucy/Search/Matcher.h"
+#include "Clownfish/Vector.h"
+*/
+import "C"
+import "encoding/base64"
+import "encoding/json"
+import "fmt"
+import "math"
+import "strconv"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// The position of a hit within sorted results: the values which the hit
+// was sorted by.  Values holds one entry per SortRule, nil unless the rule
+// sorts by a field.
+type cursor struct {
+	docID  int32
+	score  float32
+	values []interface{}
+}
+
+// The serialized form of a cursor.  The score is stored as bits so that it
+// survives JSON exactly, and each value is tagged with its Go type.
+type cursorJSON struct {
+	DocID  int32       `json:"d"`
+	Score  uint32      `json:"s"`
+	Values [][2]string `json:"v,omitempty"`
+}
+
+func (c *cursor) String() string {
+	data := cursorJSON{DocID: c.docID, Score: math.Float32bits(c.score)}
+	for _, value := range c.values {
+		var tagged [2]string
+		switch v := value.(type) {
+		case string:
+			tagged = [2]string{"s", v}
+		case int32:
+			tagged = [2]string{"i32", strconv.FormatInt(int64(v), 10)}
+		case int64:
+			tagged = [2]string{"i64", strconv.FormatInt(v, 10)}
+		case float32:
+			tagged = [2]string{"f32", strconv.FormatUint(uint64(math.Float32bits(v)), 10)}
+		case float64:
+			tagged = [2]string{"f64", strconv.FormatUint(math.Float64bits(v), 10)}
+		case []byte:
+			tagged = [2]string{"b", base64.StdEncoding.EncodeToString(v)}
+		}
+		data.Values = append(data.Values, tagged)
+	}
+	encoded, _ := json.Marshal(data)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func parseCursor(s string) (*cursor, error) {
+	encoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, clownfish.NewErr("Malformed cursor")
+	}
+	var data cursorJSON
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return nil, clownfish.NewErr("Malformed cursor")
+	}
+	c := &cursor{docID: data.DocID, score: math.Float32frombits(data.Score)}
+	for _, tagged := range data.Values {
+		var value interface{}
+		var err error
+		switch tagged[0] {
+		case "":
+		case "s":
+			value = tagged[1]
+		case "i32":
+			var num int64
+			num, err = strconv.ParseInt(tagged[1], 10, 32)
+			value = int32(num)
+		case "i64":
+			value, err = strconv.ParseInt(tagged[1], 10, 64)
+		case "f32":
+			var bits uint64
+			bits, err = strconv.ParseUint(tagged[1], 10, 32)
+			value = math.Float32frombits(uint32(bits))
+		case "f64":
+			var bits uint64
+			bits, err = strconv.ParseUint(tagged[1], 10, 64)
+			value = math.Float64frombits(bits)
+		case "b":
+			value, err = base64.StdEncoding.DecodeString(tagged[1])
+		default:
+			err = fmt.Errorf("unknown type %q", tagged[0])
+		}
+		if err != nil {
+			return nil, clownfish.NewErr("Malformed cursor")
+		}
+		c.values = append(c.values, value)
+	}
+	return c, nil
+}
+
+// Compare two sort values of the same Go type, as returned by
+// SortCache.Value.  Missing values sort after all others, as they do in
+// HitQueue.
+func compareSortValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	switch av := a.(type) {
+	case string:
+		bv, _ := b.(string)
+		return compareOrdered(av, bv)
+	case int32:
+		bv, _ := b.(int32)
+		return compareOrdered(av, bv)
+	case int64:
+		bv, _ := b.(int64)
+		return compareOrdered(av, bv)
+	case float32:
+		bv, _ := b.(float32)
+		return compareOrdered(av, bv)
+	case float64:
+		bv, _ := b.(float64)
+		return compareOrdered(av, bv)
+	case []byte:
+		bv, _ := b.([]byte)
+		return compareOrdered(string(av), string(bv))
+	}
+	return compareOrdered(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareOrdered[T string | int32 | int64 | float32 | float64](a, b T) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+type cursorRule struct {
+	kind    C.int32_t
+	field   string
+	reverse bool
+}
+
+func extractCursorRules(sortSpec *C.lucy_SortSpec) []cursorRule {
+	rulesC := C.LUCY_SortSpec_Get_Rules(sortSpec)
+	rules := make([]cursorRule, int(C.CFISH_Vec_Get_Size(rulesC)))
+	for i := range rules {
+		ruleC := (*C.lucy_SortRule)(unsafe.Pointer(C.CFISH_Vec_Fetch(rulesC, C.size_t(i))))
+		rules[i].kind = C.LUCY_SortRule_Get_Type(ruleC)
+		rules[i].reverse = bool(C.LUCY_SortRule_Get_Reverse(ruleC))
+		if fieldC := C.LUCY_SortRule_Get_Field(ruleC); fieldC != nil {
+			rules[i].field = clownfish.CFStringToGo(unsafe.Pointer(fieldC))
+		}
+	}
+	return rules
+}
+
+// cursorCollector feeds a SortCollector only those docs which sort after a
+// cursor, so that the SortCollector's HitQueue never holds more than one
+// page of hits.
+type cursorCollector struct {
+	after     *cursor
+	rules     []cursorRule
+	inner     SortCollector
+	innerC    *C.lucy_Collector
+	segments  []facetSegment
+	ordCmps   [][]int8
+	base      int32
+	matcher   Matcher
+	reader    SegReader
+	totalHits int
+}
+
+// Sentinel for ordinals not yet compared against the cursor.
+const ordCmpUnknown = 2
+
+func (c *cursorCollector) SetReader(reader SegReader) {
+	c.reader = reader
+	C.LUCY_Coll_Set_Reader(c.innerC, (*C.lucy_SegReader)(clownfish.Unwrap(reader, "reader")))
+	c.segments = make([]facetSegment, len(c.rules))
+	c.ordCmps = make([][]int8, len(c.rules))
+	for i, rule := range c.rules {
+		if rule.kind != C.lucy_SortRule_FIELD || c.after == nil {
+			continue
+		}
+		c.segments[i], _ = openFacetSegment(reader, rule.field)
+		c.ordCmps[i] = make([]int8, len(c.segments[i].ordCounts))
+		for ord := range c.ordCmps[i] {
+			c.ordCmps[i][ord] = ordCmpUnknown
+		}
+	}
+}
+
+func (c *cursorCollector) SetBase(base int32) {
+	c.base = base
+	C.LUCY_Coll_Set_Base(c.innerC, C.int32_t(base))
+}
+
+func (c *cursorCollector) SetMatcher(matcher Matcher) {
+	c.matcher = matcher
+	C.LUCY_Coll_Set_Matcher(c.innerC, (*C.lucy_Matcher)(clownfish.UnwrapNullable(matcher)))
+}
+
+func (c *cursorCollector) NeedScore() bool {
+	return bool(C.LUCY_Coll_Need_Score(c.innerC))
+}
+
+func (c *cursorCollector) Collect(docID int32) error {
+	c.totalHits++
+	if c.after == nil || c.compare(docID) > 0 {
+		C.LUCY_Coll_Collect(c.innerC, C.int32_t(docID))
+	}
+	return nil
+}
+
+// Compare a doc against the cursor, returning a positive number if the doc
+// sorts after it.
+func (c *cursorCollector) compare(docID int32) int {
+	for i, rule := range c.rules {
+		var cmp int
+		switch rule.kind {
+		case C.lucy_SortRule_SCORE:
+			// High scores come first.
+			cmp = compareOrdered(c.after.score, c.matcher.Score())
+		case C.lucy_SortRule_DOC_ID:
+			cmp = compareOrdered(c.base+docID, c.after.docID)
+		case C.lucy_SortRule_FIELD:
+			cmp = c.compareField(i, docID)
+		}
+		if rule.reverse {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return compareOrdered(c.base+docID, c.after.docID)
+}
+
+func (c *cursorCollector) compareField(tick int, docID int32) int {
+	var afterValue interface{}
+	if tick < len(c.after.values) {
+		afterValue = c.after.values[tick]
+	}
+	seg := &c.segments[tick]
+	ord, ok := seg.ordinal(docID)
+	if !ok {
+		return compareSortValues(nil, afterValue)
+	}
+	if cmp := c.ordCmps[tick][ord]; cmp != ordCmpUnknown {
+		return int(cmp)
+	}
+	var value interface{}
+	if ord != seg.nullOrd {
+		value, _ = seg.sortCache.Value(ord)
+	}
+	cmp := compareSortValues(value, afterValue)
+	c.ordCmps[tick][ord] = int8(cmp)
+	return cmp
+}
+
+// HitsAfter returns up to numWanted hits which sort after the hit identified
+// by a cursor, as returned by Hits.Cursor.  Pass an empty cursor to fetch
+// the first page.  The same query and sortSpec must be used for every page.
+//
+// Unlike paging with an offset, the cost of fetching a page doesn't grow
+// with its depth.  Ties are broken by doc ID, so that each hit appears on
+// exactly one page.
+func (s *SearcherIMP) HitsAfter(query interface{}, after string, numWanted uint32,
+	sortSpec SortSpec) (hits Hits, err error) {
+	self := (*C.lucy_Searcher)(clownfish.Unwrap(s, "s"))
+	collector := &cursorCollector{}
+	if after != "" {
+		collector.after, err = parseCursor(after)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Build the inner SortCollector, always ending with a doc ID rule so
+	// that the sort order is total.
+	rules := []SortRule{NewScoreSortRule(false), NewDocIDSortRule(false)}
+	if sortSpec != nil {
+		rules = append(sortSpec.GetRules(), NewDocIDSortRule(false))
+	}
+	fullSpec := NewSortSpec(rules)
+	fullSpecC := (*C.lucy_SortSpec)(clownfish.Unwrap(fullSpec, "sortSpec"))
+	collector.rules = extractCursorRules(fullSpecC)
+	if collector.after != nil && len(collector.after.values) > len(collector.rules) {
+		return nil, clownfish.NewErr("Cursor doesn't match SortSpec")
+	}
+	err = clownfish.TrapErr(func() {
+		docMax := uint32(C.LUCY_Searcher_Doc_Max(self))
+		if numWanted > docMax {
+			numWanted = docMax
+		}
+		schemaC := C.LUCY_Searcher_Get_Schema(self)
+		collC := C.lucy_SortColl_new(schemaC, fullSpecC, C.uint32_t(numWanted))
+		collector.inner = WRAPSortCollector(unsafe.Pointer(collC))
+		collector.innerC = (*C.lucy_Collector)(unsafe.Pointer(collC))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(unsafe.Pointer(self)))).(Searcher)
+	if err = searcher.Collect(query, collector); err != nil {
+		return nil, err
+	}
+	sortColl := (*C.lucy_SortCollector)(unsafe.Pointer(collector.innerC))
+	matchDocs := C.LUCY_SortColl_Pop_Match_Docs(sortColl)
+	defer C.cfish_decref(unsafe.Pointer(matchDocs))
+	topDocs := C.lucy_TopDocs_new(matchDocs, C.uint32_t(collector.totalHits))
+	defer C.cfish_decref(unsafe.Pointer(topDocs))
+	hitsC := C.lucy_Hits_new(self, topDocs, 0)
+	return WRAPHits(unsafe.Pointer(hitsC)), nil
+}
+
+// Cursor returns an opaque string identifying the position of the hit most
+// recently returned by Next, which may be passed to Searcher.HitsAfter to
+// fetch the hits which follow it.  It returns "" if Next hasn't been called.
+func (h *HitsIMP) Cursor() string {
+	self := (*C.lucy_Hits)(clownfish.Unwrap(h, "h"))
+	ivars := C.lucy_Hits_IVARS(self)
+	// Next advances the offset even when it runs out of hits.
+	tick := int(ivars.offset)
+	if size := int(C.CFISH_Vec_Get_Size(ivars.match_docs)); tick > size {
+		tick = size
+	}
+	if tick == 0 {
+		return ""
+	}
+	matchDoc := (*C.lucy_MatchDoc)(unsafe.Pointer(
+		C.CFISH_Vec_Fetch(ivars.match_docs, C.size_t(tick-1))))
+	c := &cursor{
+		docID: int32(C.LUCY_MatchDoc_Get_Doc_ID(matchDoc)),
+		score: float32(C.LUCY_MatchDoc_Get_Score(matchDoc)),
+	}
+	if values := C.LUCY_MatchDoc_Get_Values(matchDoc); values != nil {
+		for i := 0; i < int(C.CFISH_Vec_Get_Size(values)); i++ {
+			var value interface{}
+			if elem := C.CFISH_Vec_Fetch(values, C.size_t(i)); elem != nil {
+				value = clownfish.ToGo(unsafe.Pointer(elem))
+			}
+			c.values = append(c.values, value)
+		}
+	}
+	return c.String()
+}
@@ -21,10 +21,16 @@ package lucy
 
 #include "Lucy/Document/Doc.h"
 #include "Lucy/Document/HitDoc.h"
+#include "Lucy/Plan/FieldType.h"
 */
 import "C"
 import "unsafe"
 import "fmt"
+import "reflect"
+import "math"
+import "strings"
+import "sync"
+import "time"
 
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
 
@@ -69,3 +75,300 @@ func (d *DocIMP) FieldNames() []string {
 	defer C.cfish_decref(unsafe.Pointer(fieldsCF))
 	return vecToStringSlice(fieldsCF)
 }
+
+// structField describes how a single Go struct field maps onto an index
+// field, as controlled by an optional `lucy:"name,omitempty"` struct tag.
+type structField struct {
+	index     int
+	name      string
+	tagged    bool
+	omitEmpty bool
+	boost     bool
+	excerpt   bool
+	opts      []string // options not handled here, e.g. for SchemaFor
+}
+
+// Booster may be implemented by documents passed to Indexer.AddDoc in order
+// to supply an index-time boost.
+type Booster interface {
+	LucyBoost() float32
+}
+
+var structFieldCache = struct {
+	sync.Mutex
+	fields map[reflect.Type][]structField
+}{fields: make(map[reflect.Type][]structField)}
+
+// Return the mappable fields of the supplied struct type.  Unexported fields
+// and fields tagged with `lucy:"-"` are left out.
+func structFields(t reflect.Type) []structField {
+	structFieldCache.Lock()
+	defer structFieldCache.Unlock()
+	if fields, ok := structFieldCache.fields[t]; ok {
+		return fields
+	}
+	fields := make([]structField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		field := structField{index: i, name: sf.Name}
+		if tag, ok := sf.Tag.Lookup("lucy"); ok {
+			if tag == "-" {
+				continue
+			}
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				field.name = opts[0]
+				field.tagged = true
+			}
+			for _, opt := range opts[1:] {
+				switch opt {
+				case "omitempty":
+					field.omitEmpty = true
+				case "boost":
+					field.boost = true
+				case "excerpt":
+					field.excerpt = true
+				default:
+					field.opts = append(field.opts, opt)
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+	structFieldCache.fields[t] = fields
+	return fields
+}
+
+// Find the struct field which corresponds to the supplied index field name.
+// Tagged names must match exactly; untagged Go field names match
+// case-insensitively.  Returns an invalid Value if there is no match.
+func findStructField(structValue reflect.Value, field string) reflect.Value {
+	fields := structFields(structValue.Type())
+	for _, sf := range fields {
+		if sf.tagged && sf.holdsValue() && sf.name == field {
+			return structValue.Field(sf.index)
+		}
+	}
+	for _, sf := range fields {
+		if !sf.tagged && sf.holdsValue() && strings.EqualFold(sf.name, field) {
+			return structValue.Field(sf.index)
+		}
+	}
+	return reflect.Value{}
+}
+
+// Report whether the struct field holds the value of an index field, as
+// opposed to a boost or an excerpt.
+func (sf structField) holdsValue() bool {
+	return !sf.boost && !sf.excerpt
+}
+
+// Determine the index-time boost for a document, either from its LucyBoost
+// method or from a struct field tagged with `lucy:",boost"`.  A zero-valued
+// boost field means that the document is not boosted.
+func docBoost(doc interface{}) (float32, error) {
+	if booster, ok := doc.(Booster); ok {
+		return booster.LucyBoost(), nil
+	}
+	docValue := reflect.ValueOf(doc)
+	if docValue.Kind() != reflect.Ptr || docValue.Elem().Kind() != reflect.Struct {
+		return 1.0, nil
+	}
+	docValue = docValue.Elem()
+	for _, sf := range structFields(docValue.Type()) {
+		if !sf.boost {
+			continue
+		}
+		fieldValue := docValue.Field(sf.index)
+		switch fieldValue.Kind() {
+		case reflect.Float32, reflect.Float64:
+			if boost := fieldValue.Float(); boost != 0.0 {
+				return float32(boost), nil
+			}
+			return 1.0, nil
+		default:
+			mess := fmt.Sprintf("Boost field '%s' must be a float, not %v",
+				docValue.Type().Field(sf.index).Name, fieldValue.Type())
+			return 0.0, clownfish.NewErr(mess)
+		}
+	}
+	return 1.0, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// Convert the value of a struct field to a Go value suitable for an index
+// field with the supplied primitive type ID.
+func structValueToField(value reflect.Value, primitiveID int, field string) (interface{}, error) {
+	badConversion := func() (interface{}, error) {
+		mess := fmt.Sprintf("Can't convert %v to the type of field '%s'",
+			value.Type(), field)
+		return nil, clownfish.NewErr(mess)
+	}
+	if value.Type() == timeType {
+		t := value.Interface().(time.Time)
+		switch primitiveID {
+		case C.lucy_FType_TEXT:
+			return t.Format(time.RFC3339Nano), nil
+		case C.lucy_FType_INT64:
+			return t.UnixNano(), nil
+		default:
+			return badConversion()
+		}
+	}
+	kind := value.Kind()
+	switch primitiveID {
+	case C.lucy_FType_TEXT:
+		if kind == reflect.String {
+			return value.String(), nil
+		} else if value.Type() == bytesType {
+			return string(value.Bytes()), nil
+		}
+	case C.lucy_FType_BLOB:
+		if value.Type() == bytesType {
+			return value.Bytes(), nil
+		} else if kind == reflect.String {
+			return []byte(value.String()), nil
+		}
+	case C.lucy_FType_INT32:
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if i := value.Int(); i >= math.MinInt32 && i <= math.MaxInt32 {
+				return int32(i), nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if u := value.Uint(); u <= math.MaxInt32 {
+				return int32(u), nil
+			}
+		}
+	case C.lucy_FType_INT64:
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return value.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if u := value.Uint(); u <= math.MaxInt64 {
+				return int64(u), nil
+			}
+		}
+	case C.lucy_FType_FLOAT32:
+		switch kind {
+		case reflect.Float32, reflect.Float64:
+			return float32(value.Float()), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float32(value.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float32(value.Uint()), nil
+		}
+	case C.lucy_FType_FLOAT64:
+		switch kind {
+		case reflect.Float32, reflect.Float64:
+			return value.Float(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(value.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(value.Uint()), nil
+		}
+	}
+	return badConversion()
+}
+
+// Store a value read from the index in a struct field, converting it to the
+// struct field's type.
+func setStructValue(dest reflect.Value, val interface{}, field string) error {
+	badConversion := func() error {
+		mess := fmt.Sprintf("Can't store field '%s' of type %T in %v",
+			field, val, dest.Type())
+		return clownfish.NewErr(mess)
+	}
+	if dest.Type() == timeType {
+		switch v := val.(type) {
+		case int64:
+			dest.Set(reflect.ValueOf(time.Unix(0, v).UTC()))
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return clownfish.NewErr(fmt.Sprintf("Field '%s': %v", field, err))
+			}
+			dest.Set(reflect.ValueOf(t))
+		default:
+			return badConversion()
+		}
+		return nil
+	}
+	switch dest.Kind() {
+	case reflect.String:
+		switch v := val.(type) {
+		case string:
+			dest.SetString(v)
+		case []byte:
+			dest.SetString(string(v))
+		default:
+			return badConversion()
+		}
+	case reflect.Slice:
+		if dest.Type() != bytesType {
+			return badConversion()
+		}
+		switch v := val.(type) {
+		case []byte:
+			dest.SetBytes(v)
+		case string:
+			dest.SetBytes([]byte(v))
+		default:
+			return badConversion()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch v := val.(type) {
+		case int32:
+			i = int64(v)
+		case int64:
+			i = v
+		default:
+			return badConversion()
+		}
+		if dest.OverflowInt(i) {
+			return badConversion()
+		}
+		dest.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var i int64
+		switch v := val.(type) {
+		case int32:
+			i = int64(v)
+		case int64:
+			i = v
+		default:
+			return badConversion()
+		}
+		if i < 0 || dest.OverflowUint(uint64(i)) {
+			return badConversion()
+		}
+		dest.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		switch v := val.(type) {
+		case float32:
+			dest.SetFloat(float64(v))
+		case float64:
+			dest.SetFloat(v)
+		case int32:
+			dest.SetFloat(float64(v))
+		case int64:
+			dest.SetFloat(float64(v))
+		default:
+			return badConversion()
+		}
+	case reflect.Interface:
+		if dest.NumMethod() != 0 {
+			return badConversion()
+		}
+		dest.Set(reflect.ValueOf(val))
+	default:
+		return badConversion()
+	}
+	return nil
+}
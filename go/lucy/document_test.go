@@ -85,3 +85,36 @@ func checkdocDumpLoad(t *testing.T, doc Doc) {
 				 doc.ToString(), dupe.ToString())
 	}
 }
+
+func TestStructFields(t *testing.T) {
+	type tagged struct {
+		Title   string `lucy:"title"`
+		Content string `lucy:",omitempty"`
+		Skipped string `lucy:"-"`
+		Plain   string
+		private string
+	}
+	fields := structFields(reflect.TypeOf(tagged{}))
+	if len(fields) != 3 {
+		t.Fatalf("Expected 3 mapped fields, got %d", len(fields))
+	}
+	if !fields[0].tagged || fields[0].name != "title" {
+		t.Errorf("Tagged name: %v", fields[0])
+	}
+	if fields[1].tagged || fields[1].name != "Content" || !fields[1].omitEmpty {
+		t.Errorf("omitempty without name: %v", fields[1])
+	}
+	value := reflect.ValueOf(&tagged{}).Elem()
+	if got := findStructField(value, "title"); !got.IsValid() {
+		t.Error("findStructField should match tagged name")
+	}
+	if got := findStructField(value, "Title"); got.IsValid() {
+		t.Error("findStructField should match tagged name exactly")
+	}
+	if got := findStructField(value, "plain"); !got.IsValid() {
+		t.Error("findStructField should match untagged name case-insensitively")
+	}
+	if got := findStructField(value, "skipped"); got.IsValid() {
+		t.Error("findStructField should ignore skipped fields")
+	}
+}
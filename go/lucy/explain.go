@@ -0,0 +1,291 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#define C_LUCY_COMPILER
+#define C_LUCY_POLYCOMPILER
+#define C_LUCY_TERMCOMPILER
+#define C_LUCY_PHRASECOMPILER
+#define C_LUCY_TERMMATCHER
+#define C_LUCY_PHRASEMATCHER
+#define C_LUCY_MATCHPOSTING
+#define C_LUCY_SCOREPOSTING
+#define C_LUCY_SCOREPOSTINGMATCHER
+
+#include "Lucy/Search/Searcher.h"
+#include "Lucy/Search/Query.h"
+#include "Lucy/Search/Compiler.h"
+#include "Lucy/Search/PolyQuery.h"
+#include "Lucy/Search/TermQuery.h"
+#include "Lucy/Search/PhraseQuery.h"
+#include "Lucy/Search/Matcher.h"
+#include "Lucy/Search/TermMatcher.h"
+#include "Lucy/Search/PhraseMatcher.h"
+#include "Lucy/Index/PostingList.h"
+#include "Lucy/Index/Posting/MatchPosting.h"
+#include "Lucy/Index/Posting/ScorePosting.h"
+#include "Lucy/Index/Posting/BM25Posting.h"
+#include "Lucy/Index/SegReader.h"
+#include "Lucy/Index/Similarity.h"
+#include "Lucy/Index/BM25Similarity.h"
+#include "Clownfish/String.h"
+#include "Clownfish/Vector.h"
+
+static lucy_Posting*
+first_phrase_posting(lucy_PhraseMatcher *matcher) {
+	return LUCY_PList_Get_Posting(lucy_PhraseMatcher_IVARS(matcher)->plists[0]);
+}
+*/
+import "C"
+import "fmt"
+import "strings"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// Explanation is a node in the tree of contributions which make up a doc's
+// score.  Value is the node's contribution, and for nodes with Details, is
+// derived from the Details as the Description states.
+type Explanation struct {
+	Match       bool           `json:"match"`
+	Value       float32        `json:"value"`
+	Description string         `json:"description"`
+	Details     []*Explanation `json:"details,omitempty"`
+}
+
+// String renders the tree as indented text, one node per line.
+func (e *Explanation) String() string {
+	var b strings.Builder
+	e.write(&b, 0)
+	return b.String()
+}
+
+func (e *Explanation) write(b *strings.Builder, depth int) {
+	fmt.Fprintf(b, "%s%g = %s\n", strings.Repeat("  ", depth), e.Value, e.Description)
+	for _, detail := range e.Details {
+		detail.write(b, depth+1)
+	}
+}
+
+// Explain describes how a doc's score for a query was computed.  The query
+// is weighted by the Searcher's Compiler exactly as it would be for a
+// search, and each node's Value is the score produced by its own Matcher.
+// If the doc doesn't match, the returned Explanation's Match is false.
+func (s *SearcherIMP) Explain(query interface{}, docID int32) (retval *Explanation, err error) {
+	searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+		clownfish.Unwrap(s, "s")))).(Searcher)
+	segReader, segDocID, err := locateDoc(searcher, docID)
+	if err != nil {
+		return nil, err
+	}
+	self := (*C.lucy_Searcher)(clownfish.Unwrap(s, "s"))
+	queryC := (*C.cfish_Obj)(clownfish.GoToClownfish(query, unsafe.Pointer(C.CFISH_OBJ), false))
+	defer C.cfish_decref(unsafe.Pointer(queryC))
+	segReaderC := (*C.lucy_SegReader)(clownfish.Unwrap(segReader, "segReader"))
+	err = clownfish.TrapErr(func() {
+		realQueryC := C.LUCY_Searcher_Glean_Query(self, queryC)
+		defer C.cfish_decref(unsafe.Pointer(realQueryC))
+		compilerC := C.LUCY_Query_Make_Compiler(realQueryC, self,
+			C.LUCY_Query_Get_Boost(realQueryC), false)
+		defer C.cfish_decref(unsafe.Pointer(compilerC))
+		retval = explainCompiler(compilerC, segReaderC, C.int32_t(segDocID))
+	})
+	return retval, err
+}
+
+// Find the segment which holds a doc, along with the doc's ID within it.
+func locateDoc(searcher Searcher, docID int32) (SegReader, int32, error) {
+	switch s := searcher.(type) {
+	case PolySearcher:
+		offset := int32(0)
+		for _, sub := range s.GetSearchers() {
+			if docMax := sub.DocMax(); docID <= offset+docMax {
+				return locateDoc(sub, docID-offset)
+			}
+			offset += sub.DocMax()
+		}
+	case IndexSearcher:
+		reader := s.GetReader()
+		segReaders := reader.SegReaders()
+		starts := reader.Offsets()
+		if docID <= reader.DocMax() {
+			for i := len(segReaders) - 1; i >= 0; i-- {
+				if docID > starts[i] {
+					return segReaders[i], docID - starts[i], nil
+				}
+			}
+		}
+	default:
+		return nil, 0, clownfish.NewErr(fmt.Sprintf("Explain not supported for %T", searcher))
+	}
+	return nil, 0, clownfish.NewErr(fmt.Sprintf("Invalid docID: %d", docID))
+}
+
+func compilerDescription(compiler *C.lucy_Compiler) string {
+	parent := C.lucy_Compiler_IVARS(compiler).parent
+	strC := C.LUCY_Query_To_String(parent)
+	defer C.cfish_decref(unsafe.Pointer(strC))
+	return clownfish.CFStringToGo(unsafe.Pointer(strC))
+}
+
+func explainCompiler(compiler *C.lucy_Compiler, segReader *C.lucy_SegReader,
+	docID C.int32_t) *Explanation {
+	expl := &Explanation{Description: compilerDescription(compiler)}
+	matcher := C.LUCY_Compiler_Make_Matcher(compiler, segReader, true)
+	if matcher != nil {
+		defer C.cfish_decref(unsafe.Pointer(matcher))
+		if C.LUCY_Matcher_Advance(matcher, docID) == docID {
+			expl.Match = true
+			expl.Value = float32(C.LUCY_Matcher_Score(matcher))
+		}
+	}
+	compilerObj := (*C.cfish_Obj)(unsafe.Pointer(compiler))
+	matcherObj := (*C.cfish_Obj)(unsafe.Pointer(matcher))
+	switch {
+	case C.cfish_Obj_is_a(compilerObj, C.LUCY_POLYCOMPILER):
+		explainPolyCompiler(expl, (*C.lucy_PolyCompiler)(unsafe.Pointer(compiler)),
+			segReader, docID)
+	case !expl.Match:
+	case C.cfish_Obj_is_a(compilerObj, C.LUCY_TERMCOMPILER) &&
+		C.cfish_Obj_is_a(matcherObj, C.LUCY_TERMMATCHER):
+		ivars := C.lucy_TermCompiler_IVARS((*C.lucy_TermCompiler)(unsafe.Pointer(compiler)))
+		posting := C.lucy_TermMatcher_IVARS((*C.lucy_TermMatcher)(unsafe.Pointer(matcher))).posting
+		freq := C.lucy_MatchPost_IVARS((*C.lucy_MatchPosting)(unsafe.Pointer(posting))).freq
+		if C.cfish_Obj_is_a(matcherObj, C.LUCY_BM25POSTINGMATCHER) {
+			explainBM25(expl, compiler, float32(ivars.idf), float32(ivars.query_norm_factor),
+				(*C.lucy_BM25PostingMatcher)(unsafe.Pointer(matcher)), posting)
+			break
+		}
+		explainWeighted(expl, compiler, float32(ivars.idf), float32(ivars.query_norm_factor),
+			posting, "freq", float32(freq))
+	case C.cfish_Obj_is_a(compilerObj, C.LUCY_PHRASECOMPILER) &&
+		C.cfish_Obj_is_a(matcherObj, C.LUCY_PHRASEMATCHER):
+		ivars := C.lucy_PhraseCompiler_IVARS((*C.lucy_PhraseCompiler)(unsafe.Pointer(compiler)))
+		phraseMatcher := (*C.lucy_PhraseMatcher)(unsafe.Pointer(matcher))
+		explainWeighted(expl, compiler, float32(ivars.idf), float32(ivars.query_norm_factor),
+			C.first_phrase_posting(phraseMatcher), "phraseFreq",
+			float32(C.lucy_PhraseMatcher_IVARS(phraseMatcher).phrase_freq))
+	}
+	return expl
+}
+
+// Explain a TermQuery or PhraseQuery match, whose score is
+//
+//	tf(freq) * weight * fieldNorm
+//
+// where the Compiler's weight is boost * idf * idf * queryNorm.  Matches on
+// fields whose postings don't record norms score the weight alone.
+func explainWeighted(expl *Explanation, compiler *C.lucy_Compiler, idf, queryNorm float32,
+	posting *C.lucy_Posting, freqName string, freq float32) {
+	boost := float32(C.LUCY_Query_Get_Boost((*C.lucy_Query)(unsafe.Pointer(compiler))))
+	idfExpl := &Explanation{Match: true, Value: idf, Description: "idf"}
+	queryWeight := &Explanation{
+		Match:       true,
+		Value:       boost * idf * queryNorm,
+		Description: "queryWeight, product of:",
+		Details: []*Explanation{
+			{Match: true, Value: boost, Description: "boost"},
+			idfExpl,
+			{Match: true, Value: queryNorm, Description: "queryNorm"},
+		},
+	}
+	expl.Description = fmt.Sprintf("weight(%s), product of:", expl.Description)
+	if !C.cfish_Obj_is_a((*C.cfish_Obj)(unsafe.Pointer(posting)), C.LUCY_SCOREPOSTING) {
+		expl.Details = []*Explanation{queryWeight, idfExpl}
+		return
+	}
+	sim := C.LUCY_Compiler_Get_Similarity(compiler)
+	tf := float32(C.LUCY_Sim_TF(sim, C.float(freq)))
+	norm := float32(C.lucy_ScorePost_IVARS((*C.lucy_ScorePosting)(unsafe.Pointer(posting))).weight)
+	fieldWeight := &Explanation{
+		Match:       true,
+		Value:       tf * idf * norm,
+		Description: "fieldWeight, product of:",
+		Details: []*Explanation{
+			{Match: true, Value: tf, Description: fmt.Sprintf("tf(%s=%g)", freqName, freq)},
+			idfExpl,
+			{Match: true, Value: norm, Description: "fieldNorm"},
+		},
+	}
+	expl.Details = []*Explanation{queryWeight, fieldWeight}
+}
+
+// Explain a TermQuery match scored by a BM25Similarity, whose score is
+//
+//	weight * tfNorm * docBoost
+//
+// where the Compiler's weight is boost * idf * queryNorm, and the
+// Similarity's IDF is the square root of the BM25 idf.
+func explainBM25(expl *Explanation, compiler *C.lucy_Compiler, sqrtIDF, queryNorm float32,
+	matcher *C.lucy_BM25PostingMatcher, posting *C.lucy_Posting) {
+	boost := float32(C.LUCY_Query_Get_Boost((*C.lucy_Query)(unsafe.Pointer(compiler))))
+	idf := sqrtIDF * sqrtIDF
+	sim := (*C.lucy_BM25Similarity)(unsafe.Pointer(C.LUCY_Compiler_Get_Similarity(compiler)))
+	freq := C.float(C.LUCY_MatchPost_Get_Freq((*C.lucy_MatchPosting)(unsafe.Pointer(posting))))
+	length := C.float(C.LUCY_BM25Post_Get_Length((*C.lucy_BM25Posting)(unsafe.Pointer(posting))))
+	avgLength := C.LUCY_BM25PostMatcher_Get_Avg_Length(matcher)
+	tfNorm := float32(C.LUCY_BM25Sim_TF_Norm(sim, freq, length, avgLength))
+	docBoost := float32(C.lucy_ScorePost_IVARS((*C.lucy_ScorePosting)(unsafe.Pointer(posting))).weight)
+	expl.Description = fmt.Sprintf("weight(%s), product of:", expl.Description)
+	expl.Details = []*Explanation{
+		{Match: true, Value: boost, Description: "boost"},
+		{Match: true, Value: idf, Description: "idf"},
+		{Match: true, Value: queryNorm, Description: "queryNorm"},
+		{
+			Match:       true,
+			Value:       tfNorm,
+			Description: "tfNorm, computed from:",
+			Details: []*Explanation{
+				{Match: true, Value: float32(freq), Description: "freq"},
+				{Match: true, Value: float32(C.LUCY_BM25Sim_Get_K1(sim)), Description: "k1"},
+				{Match: true, Value: float32(C.LUCY_BM25Sim_Get_B(sim)), Description: "b"},
+				{Match: true, Value: float32(length), Description: "fieldLength"},
+				{Match: true, Value: float32(avgLength), Description: "avgFieldLength"},
+			},
+		},
+		{Match: true, Value: docBoost, Description: "docBoost"},
+	}
+}
+
+// Explain each clause of a compound query, followed by the coordination
+// factor which scales the sum of the matching clauses' scores.
+func explainPolyCompiler(expl *Explanation, compiler *C.lucy_PolyCompiler,
+	segReader *C.lucy_SegReader, docID C.int32_t) {
+	children := C.lucy_PolyCompiler_IVARS(compiler).children
+	total := int(C.CFISH_Vec_Get_Size(children))
+	matching := 0
+	var sum float32
+	for i := 0; i < total; i++ {
+		child := (*C.lucy_Compiler)(unsafe.Pointer(C.CFISH_Vec_Fetch(children, C.size_t(i))))
+		childExpl := explainCompiler(child, segReader, docID)
+		if childExpl.Match {
+			matching++
+			sum += childExpl.Value
+		}
+		expl.Details = append(expl.Details, childExpl)
+	}
+	if !expl.Match || sum == 0 {
+		return
+	}
+	expl.Description = fmt.Sprintf("%s, sum of matching clauses times coord:", expl.Description)
+	expl.Details = append(expl.Details, &Explanation{
+		Match:       true,
+		Value:       expl.Value / sum,
+		Description: fmt.Sprintf("coord(%d/%d)", matching, total),
+	})
+}
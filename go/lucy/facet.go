@@ -0,0 +1,409 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "Lucy/Index/SortCache.h"
+#include "Clownfish/Obj.h"
+*/
+import "C"
+import "fmt"
+import "math"
+import "sort"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// FacetCount is the number of matching docs which share a field value.
+type FacetCount struct {
+	Value interface{}
+	Count int
+}
+
+// Facet summarizes the values of one field over the docs matching a query.
+type Facet struct {
+	Field string
+	// The most frequent values, sorted by descending count.
+	Counts []FacetCount
+	// Number of docs whose value was left out of Counts, either because of
+	// the limit on the number of values or because of the minimum count.
+	Other int
+	// Number of docs with no value for the field.
+	Missing int
+}
+
+// FacetRange is a bucket for a range facet, covering numeric values from
+// From up to but not including To.  Use math.Inf for an open end.
+type FacetRange struct {
+	Label string
+	From  float64
+	To    float64
+}
+
+// RangeCount is the number of matching docs with a value in a FacetRange.
+type RangeCount struct {
+	FacetRange
+	Count int
+}
+
+// HistogramBucket is the number of matching docs with a value from Start up
+// to but not including Start plus the histogram's interval.
+type HistogramBucket struct {
+	Start float64
+	Count int
+}
+
+const (
+	valueFacet = iota
+	rangeFacet
+	histogramFacet
+)
+
+// A facet requested from a FacetCollector and its running totals.
+type facetSpec struct {
+	field    string
+	kind     int
+	counts   map[interface{}]int
+	missing  int
+	ranges   []FacetRange
+	interval float64
+}
+
+// Per-segment state for one facet: the segment's SortCache and a count for
+// each of its ordinals.
+type facetSegment struct {
+	sortCache SortCache
+	cacheC    *C.lucy_SortCache
+	nullOrd   int32
+	ordCounts []int
+}
+
+// FacetCollector is a CustomCollector which counts the values of sortable
+// fields over the docs it collects, using the ordinals in each segment's
+// SortCache rather than stored documents.  Pass it to Searcher.Collect, then
+// read the results with Facet, Ranges or Histogram.
+type FacetCollector struct {
+	facets   []*facetSpec
+	segments []facetSegment
+	reader   SegReader
+	err      error
+}
+
+// NewFacetCollector creates a FacetCollector which counts the values of the
+// supplied fields, which must be sortable.  Collecting with an unknown or
+// unsortable field fails.
+func NewFacetCollector(fields ...string) *FacetCollector {
+	fc := &FacetCollector{}
+	for _, field := range fields {
+		fc.addFacet(&facetSpec{field: field, kind: valueFacet})
+	}
+	return fc
+}
+
+// AddRangeFacet asks the collector to count the docs whose value for a
+// sortable numeric field falls into each of the supplied ranges.  Ranges may
+// overlap.  It must be called before collecting.
+func (fc *FacetCollector) AddRangeFacet(field string, ranges []FacetRange) {
+	fc.addFacet(&facetSpec{field: field, kind: rangeFacet, ranges: ranges})
+}
+
+// AddHistogramFacet asks the collector to count the docs whose value for a
+// sortable numeric field falls into each bucket of the supplied width.  It
+// must be called before collecting.
+func (fc *FacetCollector) AddHistogramFacet(field string, interval float64) {
+	if interval <= 0 {
+		mess := fmt.Sprintf("Invalid histogram interval for '%s': %v", field, interval)
+		fc.err = clownfish.NewErr(mess)
+		return
+	}
+	fc.addFacet(&facetSpec{field: field, kind: histogramFacet, interval: interval})
+}
+
+func (fc *FacetCollector) addFacet(spec *facetSpec) {
+	spec.counts = make(map[interface{}]int)
+	fc.facets = append(fc.facets, spec)
+}
+
+// Verify that every facet's field is known to the Schema and sortable, and
+// numeric for range and histogram facets.
+func (fc *FacetCollector) checkFields(schema Schema) error {
+	for _, spec := range fc.facets {
+		if err := checkFacetField(schema, spec.field, spec.kind != valueFacet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify that a field can be read from SortCaches.
+func checkFacetField(schema Schema, field string, numeric bool) error {
+	fieldType := schema.FetchType(field)
+	if fieldType == nil {
+		return clownfish.NewErr(fmt.Sprintf("Unknown field: '%s'", field))
+	}
+	if !fieldType.Sortable() {
+		return clownfish.NewErr(fmt.Sprintf("Field '%s' isn't sortable", field))
+	}
+	if _, ok := fieldType.(NumericType); numeric && !ok {
+		return clownfish.NewErr(fmt.Sprintf("Field '%s' isn't numeric", field))
+	}
+	return nil
+}
+
+func (fc *FacetCollector) SetReader(reader SegReader) {
+	fc.flush()
+	fc.reader = reader
+	if fc.err == nil {
+		fc.err = fc.checkFields(reader.GetSchema())
+	}
+	fc.segments = make([]facetSegment, len(fc.facets))
+	for i, spec := range fc.facets {
+		seg, err := openFacetSegment(reader, spec.field)
+		if err != nil {
+			fc.err = err
+		}
+		fc.segments[i] = seg
+	}
+}
+
+// Prepare to count the ordinals of a field's SortCache in one segment.  If
+// the segment has no values for the field, the facetSegment's cache is nil.
+func openFacetSegment(reader SegReader, field string) (seg facetSegment, err error) {
+	sortReader, ok := reader.Fetch("Lucy::Index::SortReader").(SortReader)
+	if !ok {
+		return seg, nil
+	}
+	sortCache, err := sortReader.fetchSortCache(field)
+	if err != nil || sortCache == nil {
+		return seg, err
+	}
+	seg.sortCache = sortCache
+	seg.cacheC = (*C.lucy_SortCache)(clownfish.Unwrap(sortCache, "sortCache"))
+	seg.nullOrd = sortCache.GetNullOrd()
+	seg.ordCounts = make([]int, sortCache.GetCardinality())
+	return seg, nil
+}
+
+// Look up the doc's ordinal, returning false if the segment has no values
+// for the field.
+func (seg *facetSegment) ordinal(docID int32) (int32, bool) {
+	if seg.cacheC == nil {
+		return 0, false
+	}
+	return int32(C.LUCY_SortCache_Ordinal(seg.cacheC, C.int32_t(docID))), true
+}
+
+// Count the doc's ordinal, returning false if the segment has no values
+// for the field.
+func (seg *facetSegment) collect(docID int32) bool {
+	ord, ok := seg.ordinal(docID)
+	if ok {
+		seg.ordCounts[ord]++
+	}
+	return ok
+}
+
+func (fc *FacetCollector) SetBase(base int32) {}
+
+func (fc *FacetCollector) NeedScore() bool {
+	return false
+}
+
+func (fc *FacetCollector) Collect(docID int32) error {
+	if fc.err != nil {
+		return fc.err
+	}
+	for i := range fc.segments {
+		if !fc.segments[i].collect(docID) {
+			fc.facets[i].missing++
+		}
+	}
+	return nil
+}
+
+// Convert the ordinal counts for the current segment into value counts, or
+// bucket counts for numeric facets.
+func (fc *FacetCollector) flush() {
+	for i := range fc.segments {
+		seg := &fc.segments[i]
+		spec := fc.facets[i]
+		if seg.sortCache == nil {
+			continue
+		}
+		for ord, count := range seg.ordCounts {
+			if count == 0 {
+				continue
+			}
+			if int32(ord) == seg.nullOrd {
+				spec.missing += count
+				continue
+			}
+			value, err := seg.sortCache.Value(int32(ord))
+			if err != nil {
+				fc.err = err
+				continue
+			}
+			if value == nil {
+				spec.missing += count
+				continue
+			}
+			if spec.kind == valueFacet {
+				if blob, ok := value.([]byte); ok {
+					value = string(blob) // slices can't be map keys
+				}
+				spec.counts[value] += count
+				continue
+			}
+			num, ok := facetNumber(value)
+			if !ok {
+				mess := fmt.Sprintf("Field '%s' isn't numeric", spec.field)
+				fc.err = clownfish.NewErr(mess)
+				continue
+			}
+			if spec.kind == rangeFacet {
+				for j, r := range spec.ranges {
+					if num >= r.From && num < r.To {
+						spec.counts[j] += count
+					}
+				}
+			} else {
+				spec.counts[int64(math.Floor(num/spec.interval))] += count
+			}
+		}
+	}
+	fc.segments = nil
+	fc.reader = nil
+}
+
+// Convert a value from a NumericSortCache to float64.
+func facetNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Finish collecting and find the facet for a field.
+func (fc *FacetCollector) finish(field string, kind int) (*facetSpec, error) {
+	fc.flush()
+	if fc.err != nil {
+		return nil, fc.err
+	}
+	for _, spec := range fc.facets {
+		if spec.field == field && spec.kind == kind {
+			return spec, nil
+		}
+	}
+	mess := fmt.Sprintf("FacetCollector doesn't count field '%s'", field)
+	return nil, clownfish.NewErr(mess)
+}
+
+// Facet returns up to topN of the most frequent values of a field, leaving
+// out values seen in fewer than minCount docs.  If topN is 0, all values
+// are returned.
+func (fc *FacetCollector) Facet(field string, topN int, minCount int) (Facet, error) {
+	spec, err := fc.finish(field, valueFacet)
+	if err != nil {
+		return Facet{}, err
+	}
+	facet := Facet{Field: field, Missing: spec.missing}
+	for value, count := range spec.counts {
+		if count < minCount {
+			facet.Other += count
+			continue
+		}
+		facet.Counts = append(facet.Counts, FacetCount{value, count})
+	}
+	sort.Slice(facet.Counts, func(i, j int) bool {
+		a, b := facet.Counts[i], facet.Counts[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return compareSortValues(a.Value, b.Value) < 0
+	})
+	if topN > 0 && len(facet.Counts) > topN {
+		for _, fCount := range facet.Counts[topN:] {
+			facet.Other += fCount.Count
+		}
+		facet.Counts = facet.Counts[:topN]
+	}
+	return facet, nil
+}
+
+// Ranges returns the counts for a range facet added with AddRangeFacet, in
+// the order the ranges were supplied.
+func (fc *FacetCollector) Ranges(field string) ([]RangeCount, error) {
+	spec, err := fc.finish(field, rangeFacet)
+	if err != nil {
+		return nil, err
+	}
+	retval := make([]RangeCount, len(spec.ranges))
+	for i, r := range spec.ranges {
+		retval[i] = RangeCount{r, spec.counts[i]}
+	}
+	return retval, nil
+}
+
+// Histogram returns the non-empty buckets for a histogram facet added with
+// AddHistogramFacet, in ascending order.
+func (fc *FacetCollector) Histogram(field string) ([]HistogramBucket, error) {
+	spec, err := fc.finish(field, histogramFacet)
+	if err != nil {
+		return nil, err
+	}
+	retval := make([]HistogramBucket, 0, len(spec.counts))
+	for key, count := range spec.counts {
+		start := float64(key.(int64)) * spec.interval
+		retval = append(retval, HistogramBucket{start, count})
+	}
+	sort.Slice(retval, func(i, j int) bool {
+		return retval[i].Start < retval[j].Start
+	})
+	return retval, nil
+}
+
+// Facets counts the values of each of the supplied sortable fields over the
+// docs matching the query.  Unknown or unsortable fields are an error.  See
+// FacetCollector.Facet for topN and minCount.
+func (s *SearcherIMP) Facets(query interface{}, fields []string, topN int,
+	minCount int) ([]Facet, error) {
+	collector := NewFacetCollector(fields...)
+	searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+		clownfish.Unwrap(s, "s")))).(Searcher)
+	if err := collector.checkFields(searcher.GetSchema()); err != nil {
+		return nil, err
+	}
+	err := searcher.Collect(query, collector)
+	if err != nil {
+		return nil, err
+	}
+	facets := make([]Facet, len(fields))
+	for i, field := range fields {
+		facets[i], err = collector.Facet(field, topN, minCount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return facets, nil
+}
@@ -0,0 +1,160 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import "math"
+import "reflect"
+import "testing"
+
+func TestFacets(t *testing.T) {
+	index := createTestIndex("x a", "y a", "x a", "b", "x a", "z a")
+	searcher, _ := OpenIndexSearcher(index)
+
+	facets, err := searcher.Facets("a", []string{"content"}, 2, 1)
+	if err != nil {
+		t.Fatalf("Facets: %v", err)
+	}
+	expected := []Facet{{
+		Field:  "content",
+		Counts: []FacetCount{{"x a", 3}, {"y a", 1}},
+		Other:  1,
+	}}
+	if !reflect.DeepEqual(facets, expected) {
+		t.Errorf("Facets with topN: %v", facets)
+	}
+
+	facets, _ = searcher.Facets("a", []string{"content"}, 0, 2)
+	if len(facets[0].Counts) != 1 || facets[0].Other != 2 {
+		t.Errorf("Facets with minCount: %v", facets)
+	}
+
+	if _, err := searcher.Facets("a", []string{"nope"}, 0, 1); err == nil {
+		t.Error("Unknown field should fail")
+	}
+	if _, err := searcher.Facets("nomatch", []string{"nope"}, 0, 1); err == nil {
+		t.Error("Unknown field should fail even without matches")
+	}
+}
+
+func TestFacetCollectorSegments(t *testing.T) {
+	first, _ := OpenIndexSearcher(createTestIndex("x", "y", "x"))
+	second, _ := OpenIndexSearcher(createTestIndex("y", "x", "y", "y"))
+	searcher, _ := NewPolySearcher(first.GetSchema(), []Searcher{first, second})
+
+	collector := NewFacetCollector("content")
+	if err := searcher.Collect(NewORQuery([]Query{
+		NewTermQuery("content", "x"),
+		NewTermQuery("content", "y"),
+	}), collector); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	facet, err := collector.Facet("content", 0, 1)
+	if err != nil {
+		t.Fatalf("Facet: %v", err)
+	}
+	expected := []FacetCount{{"y", 4}, {"x", 3}}
+	if !reflect.DeepEqual(facet.Counts, expected) || facet.Other != 0 {
+		t.Errorf("Counts merged across segments: %v", facet)
+	}
+	if _, err := collector.Facet("nope", 0, 1); err == nil {
+		t.Error("Facet for uncounted field should fail")
+	}
+}
+
+type priceTestDoc struct {
+	Title string  `lucy:"title,fulltext,analyzer=standard"`
+	Price float64 `lucy:"price,float64,sortable"`
+}
+
+func createPriceTestIndex(prices ...float64) Folder {
+	schema, err := SchemaFor[priceTestDoc]()
+	if err != nil {
+		panic(err)
+	}
+	folder := NewRAMFolder("")
+	args := &OpenIndexerArgs{Index: folder, Schema: schema, Create: true}
+	indexer, err := OpenIndexer(args)
+	if err != nil {
+		panic(err)
+	}
+	defer indexer.Close()
+	for _, price := range prices {
+		if err := indexer.AddDoc(&priceTestDoc{"widget", price}); err != nil {
+			panic(err)
+		}
+	}
+	if err := indexer.Commit(); err != nil {
+		panic(err)
+	}
+	return folder
+}
+
+func TestRangeAndHistogramFacets(t *testing.T) {
+	index := createPriceTestIndex(2.5, 9.99, 10, 12, 49, 75, 120)
+	searcher, _ := OpenIndexSearcher(index)
+
+	collector := NewFacetCollector()
+	collector.AddRangeFacet("price", []FacetRange{
+		{"cheap", 0, 10},
+		{"mid", 10, 50},
+		{"pricey", 50, math.Inf(1)},
+	})
+	collector.AddHistogramFacet("price", 25)
+	if err := searcher.Collect("widget", collector); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+
+	ranges, err := collector.Ranges("price")
+	if err != nil {
+		t.Fatalf("Ranges: %v", err)
+	}
+	var rangeCounts []int
+	for _, r := range ranges {
+		rangeCounts = append(rangeCounts, r.Count)
+	}
+	if !reflect.DeepEqual(rangeCounts, []int{2, 3, 2}) || ranges[0].Label != "cheap" {
+		t.Errorf("Ranges: %v", ranges)
+	}
+
+	buckets, err := collector.Histogram("price")
+	if err != nil {
+		t.Fatalf("Histogram: %v", err)
+	}
+	expected := []HistogramBucket{{0, 4}, {25, 1}, {50, 1}, {100, 1}}
+	if !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("Histogram: %v", buckets)
+	}
+
+	if _, err := collector.Histogram("title"); err == nil {
+		t.Error("Histogram for uncounted field should fail")
+	}
+
+	bogus := NewFacetCollector()
+	bogus.AddHistogramFacet("price", 0)
+	if err := searcher.Collect("widget", bogus); err == nil {
+		t.Error("Zero histogram interval should fail")
+	}
+
+	unsortable := NewFacetCollector()
+	unsortable.AddRangeFacet("title", []FacetRange{{"all", 0, math.Inf(1)}})
+	if err := searcher.Collect("widget", unsortable); err == nil {
+		t.Error("Range facet on unsortable field should fail")
+	}
+	if _, err := searcher.Facets("widget", []string{"title"}, 0, 1); err == nil {
+		t.Error("Facets on unsortable field should fail")
+	}
+}
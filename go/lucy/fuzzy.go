@@ -0,0 +1,358 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import "fmt"
+import "sort"
+import "strings"
+import "unicode"
+import "unicode/utf8"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// MaxFuzzyEdits is the largest edit distance a FuzzyQuery accepts.
+const MaxFuzzyEdits = 2
+
+// DefaultMaxFuzzyExpansions is the number of terms a FuzzyQuery expands to.
+// When more terms are within reach, the closest are kept.
+const DefaultMaxFuzzyExpansions = 50
+
+// fuzzyQuery is the CustomQuery behind FuzzyQuery.
+type fuzzyQuery struct {
+	field        string
+	term         string
+	maxEdits     int
+	prefixLength int
+}
+
+// NewFuzzyQuery returns a Query which matches docs containing terms in field
+// within maxEdits edits of term, where an edit inserts, deletes or
+// substitutes a character or transposes two adjacent characters.  The first
+// prefixLength characters must match exactly, which greatly reduces the
+// number of terms examined.  Each matching term is scored like a TermQuery
+// boosted by its similarity to term, and at most DefaultMaxFuzzyExpansions
+// of the closest terms are used.
+func NewFuzzyQuery(field, term string, maxEdits, prefixLength int) (Query, error) {
+	if maxEdits < 0 || maxEdits > MaxFuzzyEdits {
+		mess := fmt.Sprintf("maxEdits must be between 0 and %d: %d", MaxFuzzyEdits, maxEdits)
+		return nil, clownfish.NewErr(mess)
+	}
+	if prefixLength < 0 {
+		return nil, clownfish.NewErr(fmt.Sprintf("Invalid prefixLength: %d", prefixLength))
+	}
+	return NewCustomQuery(&fuzzyQuery{field, term, maxEdits, prefixLength}), nil
+}
+
+func (q *fuzzyQuery) String() string {
+	return fmt.Sprintf("%s:%s~%d", q.field, q.term, q.maxEdits)
+}
+
+// Split the term into the prefix which must match exactly and the rest.
+func (q *fuzzyQuery) split() (string, []rune) {
+	runes := []rune(q.term)
+	if q.prefixLength >= len(runes) {
+		return q.term, nil
+	}
+	return string(runes[:q.prefixLength]), runes[q.prefixLength:]
+}
+
+func (q *fuzzyQuery) MakeCompiler(searcher Searcher, boost float32) (CustomCompiler, error) {
+	segReaders, err := searcherSegReaders(searcher)
+	if err != nil {
+		return nil, err
+	}
+	prefix, rest := q.split()
+	found := make(map[string]int)
+	automaton := newLevenshteinAutomaton(rest, q.maxEdits)
+	err = findFuzzyTerms(segLexiconReaders(segReaders), q.field, prefix, automaton, found)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the closest terms which are similar enough to be worth having.
+	var candidates fuzzyCandidates
+	termLength := utf8.RuneCountInString(q.term)
+	for term, edits := range found {
+		shorter := utf8.RuneCountInString(term)
+		if termLength < shorter {
+			shorter = termLength
+		}
+		similarity := float32(1)
+		if edits > 0 {
+			similarity = 1 - float32(edits)/float32(shorter)
+		}
+		if similarity > 0 {
+			candidates = append(candidates, fuzzyCandidate{term, similarity})
+		}
+	}
+	sort.Sort(candidates)
+	if len(candidates) > DefaultMaxFuzzyExpansions {
+		candidates = candidates[:DefaultMaxFuzzyExpansions]
+	}
+	if len(candidates) == 0 {
+		return &constantTermsCompiler{field: q.field}, nil
+	}
+	children := make([]Query, len(candidates))
+	for i, cand := range candidates {
+		child := NewTermQuery(q.field, cand.term)
+		child.SetBoost(cand.similarity)
+		children[i] = child
+	}
+	return NewORQuery(children).MakeCompiler(searcher, boost, true)
+}
+
+func segLexiconReaders(segReaders []SegReader) []LexiconReader {
+	var lexReaders []LexiconReader
+	for _, segReader := range segReaders {
+		if lexReader, ok := segReader.Fetch("Lucy::Index::LexiconReader").(LexiconReader); ok {
+			lexReaders = append(lexReaders, lexReader)
+		}
+	}
+	return lexReaders
+}
+
+// Record the terms in field which begin with prefix and whose remainder the
+// automaton accepts, along with the fewest edits found for each.
+func findFuzzyTerms(lexReaders []LexiconReader, field, prefix string,
+	automaton *levenshteinAutomaton, found map[string]int) error {
+	for _, lexReader := range lexReaders {
+		var lexicon Lexicon
+		var err error
+		if prefix == "" {
+			lexicon, err = lexReader.Lexicon(field, nil)
+		} else {
+			lexicon, err = lexReader.Lexicon(field, prefix)
+		}
+		if err != nil {
+			return err
+		}
+		if lexicon != nil {
+			automaton.intersect(&lexiconCursor{lexicon, prefix != ""}, prefix, found)
+		}
+	}
+	return nil
+}
+
+type fuzzyCandidate struct {
+	term       string
+	similarity float32
+}
+
+// fuzzyCandidates sorts the most similar terms first.
+type fuzzyCandidates []fuzzyCandidate
+
+func (c fuzzyCandidates) Len() int      { return len(c) }
+func (c fuzzyCandidates) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c fuzzyCandidates) Less(i, j int) bool {
+	if c[i].similarity != c[j].similarity {
+		return c[i].similarity > c[j].similarity
+	}
+	return c[i].term < c[j].term
+}
+
+type runeSlice []rune
+
+func (s runeSlice) Len() int           { return len(s) }
+func (s runeSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s runeSlice) Less(i, j int) bool { return s[i] < s[j] }
+
+// termCursor walks a sorted list of terms.  current reports false once the
+// terms are exhausted.
+type termCursor interface {
+	current() (string, bool)
+	next()
+	seek(target string)
+}
+
+// lexiconCursor adapts a Lexicon, which is either sought to its first term
+// or still needs to be advanced to it.
+type lexiconCursor struct {
+	lexicon    Lexicon
+	positioned bool
+}
+
+func (c *lexiconCursor) current() (string, bool) {
+	if !c.positioned {
+		c.positioned = true
+		if !c.lexicon.Next() {
+			return "", false
+		}
+	}
+	term, ok := c.lexicon.GetTerm().(string)
+	return term, ok
+}
+
+func (c *lexiconCursor) next() {
+	c.lexicon.Next()
+}
+
+func (c *lexiconCursor) seek(target string) {
+	c.lexicon.Seek(target)
+}
+
+// levenshteinAutomaton accepts the strings within maxEdits edits of a word,
+// counting the transposition of adjacent characters as a single edit.  Its
+// states are simulated a row of the edit distance matrix at a time.
+type levenshteinAutomaton struct {
+	word     []rune
+	maxEdits int
+	// The distinct characters of the word, in ascending order.  Every
+	// other character leads to the same state.
+	alphabet []rune
+}
+
+type levenshteinState struct {
+	row  []int
+	prev []int
+	last rune
+}
+
+func newLevenshteinAutomaton(word []rune, maxEdits int) *levenshteinAutomaton {
+	seen := make(map[rune]bool)
+	var alphabet []rune
+	for _, r := range word {
+		if !seen[r] {
+			seen[r] = true
+			alphabet = append(alphabet, r)
+		}
+	}
+	sort.Sort(runeSlice(alphabet))
+	return &levenshteinAutomaton{word: word, maxEdits: maxEdits, alphabet: alphabet}
+}
+
+func (a *levenshteinAutomaton) start() levenshteinState {
+	row := make([]int, len(a.word)+1)
+	for i := range row {
+		row[i] = minInt(i, a.maxEdits+1)
+	}
+	return levenshteinState{row: row, last: -1}
+}
+
+func (a *levenshteinAutomaton) step(state levenshteinState, c rune) levenshteinState {
+	row := make([]int, len(a.word)+1)
+	row[0] = minInt(state.row[0]+1, a.maxEdits+1)
+	for i := 1; i <= len(a.word); i++ {
+		cost := 1
+		if a.word[i-1] == c {
+			cost = 0
+		}
+		dist := minInt(state.row[i-1]+cost, minInt(state.row[i]+1, row[i-1]+1))
+		if i > 1 && state.prev != nil && a.word[i-1] == state.last && a.word[i-2] == c {
+			dist = minInt(dist, state.prev[i-2]+1)
+		}
+		row[i] = minInt(dist, a.maxEdits+1)
+	}
+	return levenshteinState{row: row, prev: state.row, last: c}
+}
+
+// Report whether some continuation of the state can be accepted.
+func (a *levenshteinAutomaton) viable(state levenshteinState) bool {
+	return slicesMin(state.row) <= a.maxEdits
+}
+
+// Report the edit distance of an accepted state.
+func (a *levenshteinAutomaton) distance(state levenshteinState) (int, bool) {
+	dist := state.row[len(a.word)]
+	return dist, dist <= a.maxEdits
+}
+
+// Find the least character greater than c which leads from the state to a
+// viable one.
+func (a *levenshteinAutomaton) nextChar(state levenshteinState, c rune) (rune, bool) {
+	best, found := rune(0), false
+	for _, r := range a.alphabet {
+		if r > c && a.viable(a.step(state, r)) {
+			best, found = r, true
+			break
+		}
+	}
+	other := c + 1
+	for {
+		if other >= 0xD800 && other <= 0xDFFF {
+			other = 0xE000
+		}
+		i := sort.Search(len(a.alphabet), func(i int) bool { return a.alphabet[i] >= other })
+		if i == len(a.alphabet) || a.alphabet[i] != other {
+			break
+		}
+		other++
+	}
+	if other <= unicode.MaxRune && (!found || other < best) && a.viable(a.step(state, other)) {
+		best, found = other, true
+	}
+	return best, found
+}
+
+// Visit the terms under the cursor which share the prefix and whose
+// remainder the automaton accepts, recording the fewest edits found for
+// each.  Rather than stepping through every term, the cursor is sought past
+// runs of terms which can't be accepted.
+func (a *levenshteinAutomaton) intersect(cursor termCursor, prefix string, found map[string]int) {
+	for {
+		term, ok := cursor.current()
+		if !ok || !strings.HasPrefix(term, prefix) {
+			return
+		}
+		rest := []rune(term[len(prefix):])
+		states := []levenshteinState{a.start()}
+		for _, r := range rest {
+			next := a.step(states[len(states)-1], r)
+			if !a.viable(next) {
+				break
+			}
+			states = append(states, next)
+		}
+		if len(states) == len(rest)+1 {
+			if dist, ok := a.distance(states[len(rest)]); ok {
+				if prev, seen := found[term]; !seen || dist < prev {
+					found[term] = dist
+				}
+			}
+			cursor.next()
+			continue
+		}
+
+		// The term left the automaton partway through, so look for the
+		// least greater string which could still be accepted.
+		sought := false
+		for i := len(states) - 1; i >= 0; i-- {
+			if c, ok := a.nextChar(states[i], rest[i]); ok {
+				cursor.seek(prefix + string(rest[:i]) + string(c))
+				sought = true
+				break
+			}
+		}
+		if !sought {
+			return
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func slicesMin(values []int) int {
+	least := values[0]
+	for _, v := range values[1:] {
+		least = minInt(least, v)
+	}
+	return least
+}
@@ -0,0 +1,312 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "Lucy/Search/Collector.h"
+#include "Lucy/Search/Collector/SortCollector.h"
+#include "Lucy/Index/SegReader.h"
+#include "Lucy/Search/Matcher.h"
+#include "Clownfish/Obj.h"
+*/
+import "C"
+import "fmt"
+import "sort"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// GroupHit identifies one doc within a HitGroup.
+type GroupHit struct {
+	DocID int32
+	Score float32
+}
+
+// HitGroup holds the top docs which share a value for the grouping field.
+// Docs with no value for the field are grouped under a nil Value.
+type HitGroup struct {
+	Value     interface{}
+	TotalHits int
+	Hits      []GroupHit
+}
+
+// HitGroups is the result of Searcher.GroupedHits.
+type HitGroups struct {
+	TotalHits   int
+	TotalGroups int
+	Groups      []HitGroup
+}
+
+// The best doc seen so far for a group.
+type groupRank struct {
+	value interface{}
+	score float32
+	docID int32
+	hits  int
+}
+
+func (g *groupRank) better(score float32, docID int32) bool {
+	return score > g.score || (score == g.score && docID < g.docID)
+}
+
+func (g *groupRank) update(score float32, docID int32, hits int) {
+	if g.hits == 0 || g.better(score, docID) {
+		g.score = score
+		g.docID = docID
+	}
+	g.hits += hits
+}
+
+// groupRankCollector is the first pass of GroupedHits.  It finds the best
+// doc in each group, tracking groups by SortCache ordinal within a segment
+// and by value across segments.
+type groupRankCollector struct {
+	field     string
+	seg       facetSegment
+	ordRanks  []groupRank
+	base      int32
+	matcher   Matcher
+	reader    SegReader
+	groups    map[interface{}]*groupRank
+	totalHits int
+	err       error
+}
+
+func (c *groupRankCollector) SetReader(reader SegReader) {
+	c.flush()
+	c.reader = reader
+	if c.err == nil {
+		c.err = checkFacetField(reader.GetSchema(), c.field, false)
+	}
+	seg, err := openFacetSegment(reader, c.field)
+	if err != nil {
+		c.err = err
+	}
+	c.seg = seg
+	c.ordRanks = make([]groupRank, len(c.seg.ordCounts))
+}
+
+func (c *groupRankCollector) SetBase(base int32)         { c.base = base }
+func (c *groupRankCollector) SetMatcher(matcher Matcher) { c.matcher = matcher }
+func (c *groupRankCollector) NeedScore() bool            { return true }
+
+func (c *groupRankCollector) Collect(docID int32) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.totalHits++
+	score := c.matcher.Score()
+	ord, ok := c.seg.ordinal(docID)
+	if !ok || ord == c.seg.nullOrd {
+		c.rank(nil).update(score, c.base+docID, 1)
+		return nil
+	}
+	c.ordRanks[ord].update(score, c.base+docID, 1)
+	return nil
+}
+
+func (c *groupRankCollector) rank(value interface{}) *groupRank {
+	g, ok := c.groups[value]
+	if !ok {
+		g = &groupRank{value: value}
+		c.groups[value] = g
+	}
+	return g
+}
+
+// Merge the per-ordinal ranks for the current segment into the groups.
+func (c *groupRankCollector) flush() {
+	for ord, r := range c.ordRanks {
+		if r.hits == 0 {
+			continue
+		}
+		value, err := c.seg.sortCache.Value(int32(ord))
+		if err != nil {
+			c.err = err
+			continue
+		}
+		if blob, ok := value.([]byte); ok {
+			value = string(blob) // slices can't be map keys
+		}
+		c.rank(value).update(r.score, r.docID, r.hits)
+	}
+	c.ordRanks = nil
+	c.seg = facetSegment{}
+	c.reader = nil
+}
+
+// groupDocsCollector is the second pass of GroupedHits.  It feeds the docs
+// in each of the chosen groups to a SortCollector of their own.
+type groupDocsCollector struct {
+	field     string
+	groupIdx  map[interface{}]int
+	colls     []SortCollector
+	collsC    []*C.lucy_Collector
+	seg       facetSegment
+	ordGroups []int
+	reader    SegReader
+	err       error
+}
+
+func newGroupDocsCollector(field string, groups []*groupRank, groupSize int) *groupDocsCollector {
+	c := &groupDocsCollector{
+		field:    field,
+		groupIdx: make(map[interface{}]int),
+	}
+	for i, g := range groups {
+		c.groupIdx[g.value] = i
+		collC := C.lucy_SortColl_new(nil, nil, C.uint32_t(groupSize))
+		c.colls = append(c.colls, WRAPSortCollector(unsafe.Pointer(collC)))
+		c.collsC = append(c.collsC, (*C.lucy_Collector)(unsafe.Pointer(collC)))
+	}
+	return c
+}
+
+func (c *groupDocsCollector) SetReader(reader SegReader) {
+	c.reader = reader
+	readerC := (*C.lucy_SegReader)(clownfish.Unwrap(reader, "reader"))
+	for _, collC := range c.collsC {
+		C.LUCY_Coll_Set_Reader(collC, readerC)
+	}
+	seg, err := openFacetSegment(reader, c.field)
+	if err != nil {
+		c.err = err
+	}
+	c.seg = seg
+	c.ordGroups = make([]int, len(c.seg.ordCounts))
+	for i := range c.ordGroups {
+		c.ordGroups[i] = -2 // not yet looked up
+	}
+}
+
+func (c *groupDocsCollector) SetBase(base int32) {
+	for _, collC := range c.collsC {
+		C.LUCY_Coll_Set_Base(collC, C.int32_t(base))
+	}
+}
+
+func (c *groupDocsCollector) SetMatcher(matcher Matcher) {
+	matcherC := (*C.lucy_Matcher)(clownfish.UnwrapNullable(matcher))
+	for _, collC := range c.collsC {
+		C.LUCY_Coll_Set_Matcher(collC, matcherC)
+	}
+}
+
+func (c *groupDocsCollector) NeedScore() bool { return true }
+
+func (c *groupDocsCollector) Collect(docID int32) error {
+	if c.err != nil {
+		return c.err
+	}
+	if idx := c.group(docID); idx >= 0 {
+		C.LUCY_Coll_Collect(c.collsC[idx], C.int32_t(docID))
+	}
+	return nil
+}
+
+// Find the index of the doc's group, or -1 if the group wasn't chosen.
+func (c *groupDocsCollector) group(docID int32) int {
+	ord, ok := c.seg.ordinal(docID)
+	if !ok || ord == c.seg.nullOrd {
+		return c.lookup(nil)
+	}
+	if c.ordGroups[ord] == -2 {
+		value, err := c.seg.sortCache.Value(ord)
+		if err != nil {
+			c.err = err
+			return -1
+		}
+		if blob, ok := value.([]byte); ok {
+			value = string(blob)
+		}
+		c.ordGroups[ord] = c.lookup(value)
+	}
+	return c.ordGroups[ord]
+}
+
+func (c *groupDocsCollector) lookup(value interface{}) int {
+	if idx, ok := c.groupIdx[value]; ok {
+		return idx
+	}
+	return -1
+}
+
+// GroupedHits collapses the docs matching the query by the value of a
+// sortable field.  Groups are ranked by their best scoring doc; numGroups
+// groups are returned starting at offset, each holding up to groupSize of
+// its top scoring docs.  TotalGroups counts every group, so that callers can
+// paginate by groups rather than by docs.  Unknown or unsortable fields are
+// an error.
+func (s *SearcherIMP) GroupedHits(query interface{}, field string, offset, numGroups,
+	groupSize int) (retval HitGroups, err error) {
+	if offset < 0 || numGroups < 0 || groupSize < 1 {
+		mess := fmt.Sprintf("Invalid GroupedHits args: offset %d, numGroups %d, groupSize %d",
+			offset, numGroups, groupSize)
+		return retval, clownfish.NewErr(mess)
+	}
+	searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+		clownfish.Unwrap(s, "s")))).(Searcher)
+	if err = checkFacetField(searcher.GetSchema(), field, false); err != nil {
+		return retval, err
+	}
+
+	ranker := &groupRankCollector{
+		field:  field,
+		groups: make(map[interface{}]*groupRank),
+	}
+	if err = searcher.Collect(query, ranker); err != nil {
+		return retval, err
+	}
+	ranker.flush()
+	if ranker.err != nil {
+		return retval, ranker.err
+	}
+	ranked := make([]*groupRank, 0, len(ranker.groups))
+	for _, g := range ranker.groups {
+		ranked = append(ranked, g)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[j].better(ranked[i].score, ranked[i].docID)
+	})
+	retval.TotalHits = ranker.totalHits
+	retval.TotalGroups = len(ranked)
+	if offset >= len(ranked) || numGroups == 0 {
+		return retval, nil
+	}
+	chosen := ranked[offset:]
+	if len(chosen) > numGroups {
+		chosen = chosen[:numGroups]
+	}
+
+	docsColl := newGroupDocsCollector(field, chosen, groupSize)
+	if err = searcher.Collect(query, docsColl); err != nil {
+		return retval, err
+	}
+	if docsColl.err != nil {
+		return retval, docsColl.err
+	}
+	retval.Groups = make([]HitGroup, len(chosen))
+	for i, g := range chosen {
+		group := HitGroup{Value: g.value, TotalHits: g.hits}
+		for _, matchDoc := range docsColl.colls[i].PopMatchDocs() {
+			hit := GroupHit{matchDoc.getDocID(), matchDoc.getScore()}
+			group.Hits = append(group.Hits, hit)
+		}
+		retval.Groups[i] = group
+	}
+	return retval, nil
+}
@@ -20,9 +20,14 @@ package lucy
 #include <stdlib.h>
 
 #include "Lucy/Highlight/HeatMap.h"
+#include "Lucy/Highlight/Highlighter.h"
+#include "Lucy/Document/HitDoc.h"
+#include "Clownfish/String.h"
 #include "Clownfish/Vector.h"
 */
 import "C"
+import "fmt"
+import "reflect"
 import "unsafe"
 
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
@@ -83,3 +88,68 @@ func (h *HeatMapIMP) getSpans() []Span {
 	retvalCF := C.LUCY_HeatMap_Get_Spans(self)
 	return vecToSpanSlice(retvalCF)
 }
+
+// Excerpt creates an excerpt of the highlighted field for the supplied
+// HitDoc, such as one returned by Searcher.FetchDoc.
+func (h *HighlighterIMP) Excerpt(doc HitDoc) (retval string, err error) {
+	err = clownfish.TrapErr(func() {
+		self := (*C.lucy_Highlighter)(clownfish.Unwrap(h, "h"))
+		docC := (*C.lucy_HitDoc)(clownfish.Unwrap(doc, "doc"))
+		retvalC := C.LUCY_Highlighter_Create_Excerpt(self, docC)
+		if retvalC != nil {
+			defer C.cfish_decref(unsafe.Pointer(retvalC))
+			retval = clownfish.CFStringToGo(unsafe.Pointer(retvalC))
+		}
+	})
+	return retval, err
+}
+
+// Fill the string fields of a struct which are tagged `lucy:"field,excerpt"`
+// with excerpts from the Highlighter for the named field.  Fields without a
+// matching Highlighter are left alone.
+func fillExcerpts(searcher Searcher, docID int32, doc interface{},
+	highlighters []Highlighter) error {
+	if len(highlighters) == 0 {
+		return nil
+	}
+	docValue := reflect.ValueOf(doc)
+	if docValue.Kind() != reflect.Ptr || docValue.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	docValue = docValue.Elem()
+	var hitDoc HitDoc
+	for _, sf := range structFields(docValue.Type()) {
+		if !sf.excerpt {
+			continue
+		}
+		var highlighter Highlighter
+		for _, hl := range highlighters {
+			if hl.GetField() == sf.name {
+				highlighter = hl
+				break
+			}
+		}
+		if highlighter == nil {
+			continue
+		}
+		fieldValue := docValue.Field(sf.index)
+		if fieldValue.Kind() != reflect.String {
+			mess := fmt.Sprintf("Excerpt field '%s' must be a string, not %v",
+				docValue.Type().Field(sf.index).Name, fieldValue.Type())
+			return clownfish.NewErr(mess)
+		}
+		if hitDoc == nil {
+			var err error
+			hitDoc, err = searcher.FetchDoc(docID)
+			if err != nil {
+				return err
+			}
+		}
+		excerpt, err := highlighter.Excerpt(hitDoc)
+		if err != nil {
+			return err
+		}
+		fieldValue.SetString(excerpt)
+	}
+	return nil
+}
@@ -63,6 +63,17 @@ func TestHighlighterBasics(t *testing.T) {
 	}
 }
 
+func TestHighlighterExcerpt(t *testing.T) {
+	folder := createTestIndex("foo bar baz")
+	searcher := NewIndexSearcher(folder)
+	hl := NewHighlighter(searcher, "bar", "content", 200)
+	doc, _ := searcher.FetchDoc(1)
+	excerpt, err := hl.Excerpt(doc)
+	if err != nil || !strings.Contains(excerpt, "<strong>bar</strong>") {
+		t.Errorf("Excerpt: '%s', %v", excerpt, err)
+	}
+}
+
 func TestHighlighterAccessors(t *testing.T) {
 	folder := createTestIndex("foo bar baz")
 	searcher := NewIndexSearcher(folder)
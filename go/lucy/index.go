@@ -17,6 +17,8 @@
 package lucy
 
 /*
+#define C_LUCY_INDEXER
+
 #include "Lucy/Index/Indexer.h"
 #include "Lucy/Index/IndexReader.h"
 #include "Lucy/Index/DataReader.h"
@@ -36,12 +38,63 @@ package lucy
 #include "Lucy/Index/SortCache.h"
 #include "Lucy/Document/Doc.h"
 #include "Lucy/Plan/Schema.h"
+#include "Lucy/Plan/FieldType.h"
+#include "Lucy/Plan/FullTextType.h"
+#include "Lucy/Plan/StringType.h"
+#include "Lucy/Search/IndexSearcher.h"
 #include "Clownfish/Hash.h"
 #include "Clownfish/String.h"
 #include "Clownfish/Vector.h"
 #include "Clownfish/Err.h"
+#include "Lucy/Index/PolyReader.h"
+#include "Lucy/Index/PostingList.h"
+#include "Lucy/Index/SegReader.h"
+#include "Lucy/Search/Matcher.h"
+#include "Clownfish/Class.h"
+
+// Count the docs committed before this indexing session began which contain
+// the term and which haven't been deleted, either before or during the
+// session.
+static uint32_t
+count_live_docs(lucy_Indexer *self, cfish_String *field, cfish_String *term) {
+	lucy_IndexerIVARS *const ivars = lucy_Indexer_IVARS(self);
+	cfish_Vector *seg_readers = LUCY_PolyReader_Get_Seg_Readers(ivars->polyreader);
+	cfish_String *lex_api = CFISH_Class_Get_Name(LUCY_LEXICONREADER);
+	cfish_String *plist_api = CFISH_Class_Get_Name(LUCY_POSTINGLISTREADER);
+	uint32_t count = 0;
+	for (size_t i = 0, max = CFISH_Vec_Get_Size(seg_readers); i < max; i++) {
+		lucy_SegReader *seg_reader = (lucy_SegReader*)CFISH_Vec_Fetch(seg_readers, i);
+		lucy_LexiconReader *lex_reader
+			= (lucy_LexiconReader*)LUCY_SegReader_Fetch(seg_reader, lex_api);
+		if (!lex_reader
+			|| !LUCY_LexReader_Doc_Freq(lex_reader, field, (cfish_Obj*)term)
+		   ) {
+			continue;
+		}
+		lucy_PostingListReader *plist_reader
+			= (lucy_PostingListReader*)LUCY_SegReader_Fetch(seg_reader, plist_api);
+		lucy_PostingList *plist = plist_reader
+			? LUCY_PListReader_Posting_List(plist_reader, field, (cfish_Obj*)term)
+			: NULL;
+		if (!plist) {
+			continue;
+		}
+		lucy_Matcher *deletions
+			= LUCY_DelWriter_Seg_Deletions(ivars->del_writer, seg_reader);
+		int32_t doc_id;
+		while (0 != (doc_id = LUCY_PList_Next(plist))) {
+			if (!deletions || LUCY_Matcher_Advance(deletions, doc_id) != doc_id) {
+				count++;
+			}
+		}
+		CFISH_DECREF(deletions);
+		CFISH_DECREF(plist);
+	}
+	return count;
+}
 */
 import "C"
+import "context"
 import "fmt"
 import "reflect"
 import "strings"
@@ -51,6 +104,19 @@ import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
 type IndexerIMP struct {
 	clownfish.ObjIMP
 	fieldNames map[string]string
+	// The fields which UpdateDoc has used as primary keys during this
+	// session, and the keys found in those fields in docs added since.
+	keyFields map[string]bool
+	addedKeys map[string]bool
+	// Docs supplied to UpdateDoc, which are held until PrepareCommit so
+	// that a later update of the same key can replace them.
+	pendingUpdates []pendingUpdate
+	pendingKeys    map[string]int
+}
+
+type pendingUpdate struct {
+	doc   Doc
+	boost float32
 }
 
 type OpenIndexerArgs struct {
@@ -81,21 +147,88 @@ func OpenIndexer(args *OpenIndexerArgs) (obj Indexer, err error) {
 }
 
 func (obj *IndexerIMP) Close() error {
-	// TODO: implement Close in core Lucy rather than bindings.
-	return nil // TODO catch errors
+	obj.discardSession()
+	return clownfish.TrapErr(func() {
+		self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
+		C.LUCY_Indexer_Close(self)
+	})
 }
 
-func (obj *IndexerIMP) addDocObj(doc Doc, boost float32) error {
-	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
-	d := (*C.lucy_Doc)(clownfish.Unwrap(doc, "doc"))
+func (obj *IndexerIMP) Rollback() error {
+	obj.discardSession()
 	return clownfish.TrapErr(func() {
+		self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
+		C.LUCY_Indexer_Rollback(self)
+	})
+}
+
+// Forget the docs added and updated during this indexing session.
+func (obj *IndexerIMP) discardSession() {
+	obj.keyFields = nil
+	obj.addedKeys = nil
+	obj.pendingUpdates = nil
+	obj.pendingKeys = nil
+}
+
+// Add a Doc to the index, remembering its keys so that UpdateDoc won't
+// silently duplicate it.  Docs whose keys have pending updates are rejected,
+// since the update would otherwise leave both docs in place.
+func (obj *IndexerIMP) addDocC(d *C.lucy_Doc, boost float32) error {
+	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
+	var keys []string
+	docFields := fetchDocFields(d)
+	for field := range obj.keyFields {
+		key, ok := docFields[field].(string)
+		if !ok || key == "" {
+			continue
+		}
+		sessionKey := field + "\x00" + key
+		if _, ok := obj.pendingKeys[sessionKey]; ok {
+			mess := fmt.Sprintf("Key '%s' in field '%s' has a pending update in this"+
+				" indexing session and must be replaced with UpdateDoc", key, field)
+			return clownfish.NewErr(mess)
+		}
+		keys = append(keys, sessionKey)
+	}
+	err := clownfish.TrapErr(func() {
 		C.LUCY_Indexer_Add_Doc(self, d, C.float(boost))
 	})
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 && obj.addedKeys == nil {
+		obj.addedKeys = make(map[string]bool)
+	}
+	for _, sessionKey := range keys {
+		obj.addedKeys[sessionKey] = true
+	}
+	return nil
+}
+
+func (obj *IndexerIMP) addDocObj(doc Doc, boost float32) error {
+	return obj.addDocC((*C.lucy_Doc)(clownfish.Unwrap(doc, "doc")), boost)
 }
 
 func (obj *IndexerIMP) addMapAsDoc(doc map[string]interface{}, boost float32) error {
 	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
 	d := C.LUCY_Indexer_Get_Stock_Doc(self)
+	if err := obj.fillDocFromMap(d, doc); err != nil {
+		return err
+	}
+	return obj.addDocC(d, boost)
+}
+
+func (obj *IndexerIMP) addStructAsDoc(doc interface{}, boost float32) error {
+	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
+	d := C.LUCY_Indexer_Get_Stock_Doc(self)
+	if err := obj.fillDocFromStruct(d, doc); err != nil {
+		return err
+	}
+	return obj.addDocC(d, boost)
+}
+
+// Replace the fields of a Doc with the entries of a map.
+func (obj *IndexerIMP) fillDocFromMap(d *C.lucy_Doc, doc map[string]interface{}) error {
 	docFields := fetchDocFields(d)
 	for field := range docFields {
 		delete(docFields, field)
@@ -107,14 +240,12 @@ func (obj *IndexerIMP) addMapAsDoc(doc map[string]interface{}, boost float32) er
 		}
 		docFields[field] = value
 	}
-	return clownfish.TrapErr(func() {
-		C.LUCY_Indexer_Add_Doc(self, d, C.float(boost))
-	})
+	return nil
 }
 
-func (obj *IndexerIMP) addStructAsDoc(doc interface{}, boost float32) error {
+// Replace the fields of a Doc with the fields of a struct.
+func (obj *IndexerIMP) fillDocFromStruct(d *C.lucy_Doc, doc interface{}) error {
 	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
-	d := C.LUCY_Indexer_Get_Stock_Doc(self)
 	docFields := fetchDocFields(d)
 	for field := range docFields {
 		delete(docFields, field)
@@ -135,28 +266,41 @@ func (obj *IndexerIMP) addStructAsDoc(doc interface{}, boost float32) error {
 		return clownfish.NewErr(mess)
 	}
 
-	// Copy field values into stockDoc.
-	docType := docValue.Type()
-	for i := 0; i < docValue.NumField(); i++ {
-		field := docType.Field(i).Name
-		value := docValue.Field(i).String()
-		realField, err := obj.findRealField(field)
+	// Copy field values into the Doc.
+	schema := C.LUCY_Indexer_Get_Schema(self)
+	for _, sf := range structFields(docValue.Type()) {
+		fieldValue := docValue.Field(sf.index)
+		if !sf.holdsValue() || (sf.omitEmpty && fieldValue.IsZero()) {
+			continue
+		}
+		realField, err := obj.findRealField(sf.name)
+		if err != nil {
+			return err
+		}
+		primitiveID := schemaPrimitiveID(schema, realField)
+		value, err := structValueToField(fieldValue, primitiveID, realField)
 		if err != nil {
 			return err
 		}
 		docFields[realField] = value
 	}
-
-	return clownfish.TrapErr(func() {
-		C.LUCY_Indexer_Add_Doc(self, d, C.float(boost))
-	})
+	return nil
 }
 
+// Add a document to the index.  The document may be a Doc, a
+// map[string]interface{} or a pointer to a struct.  Structs may supply a
+// boost by implementing Booster or through a field tagged `lucy:",boost"`.
 func (obj *IndexerIMP) AddDoc(doc interface{}) error {
-	// TODO create an additional method AddDocWithBoost which allows the
-	// client to supply `boost`.
-	boost := float32(1.0)
+	boost, err := docBoost(doc)
+	if err != nil {
+		return err
+	}
+	return obj.AddDocWithBoost(doc, boost)
+}
 
+// Add a document to the index, overriding any boost the document supplies
+// itself.
+func (obj *IndexerIMP) AddDocWithBoost(doc interface{}, boost float32) error {
 	if suppliedDoc, ok := doc.(Doc); ok {
 		return obj.addDocObj(suppliedDoc, boost)
 	} else if m, ok := doc.(map[string]interface{}); ok {
@@ -166,6 +310,175 @@ func (obj *IndexerIMP) AddDoc(doc interface{}) error {
 	}
 }
 
+// Replace the document whose primary key matches that of the supplied doc,
+// or add the doc if there is no match.  The key field must be an indexed
+// StringType field.  Reports whether an existing document was replaced,
+// either one committed earlier and not yet deleted or one supplied to
+// UpdateDoc earlier in this indexing session.
+//
+// Updated docs are held in memory until PrepareCommit.  A doc added with
+// AddDoc during this session can't be replaced until it has been committed,
+// so UpdateDoc returns an error for its key, and AddDoc returns an error for
+// a key with a pending update.  To detect this, the Indexer remembers the
+// keys of docs added once UpdateDoc has been used with their key field; docs
+// added before then aren't tracked.
+func (obj *IndexerIMP) UpdateDoc(keyField string, doc interface{}) (replaced bool, err error) {
+	keyField, err = obj.findRealField(keyField)
+	if err != nil {
+		return false, err
+	}
+	err = obj.checkKeyField(keyField)
+	if err != nil {
+		return false, err
+	}
+	key, err := obj.extractKey(keyField, doc)
+	if err != nil {
+		return false, err
+	}
+	if obj.keyFields == nil {
+		obj.keyFields = make(map[string]bool)
+	}
+	obj.keyFields[keyField] = true
+	sessionKey := keyField + "\x00" + key
+	if obj.addedKeys[sessionKey] {
+		mess := fmt.Sprintf("Key '%s' in field '%s' was added in this indexing session"+
+			" and can't be updated until it has been committed", key, keyField)
+		return false, clownfish.NewErr(mess)
+	}
+	boost, err := docBoost(doc)
+	if err != nil {
+		return false, err
+	}
+	pending, err := obj.copyDoc(doc)
+	if err != nil {
+		return false, err
+	}
+	count, err := obj.countCommitted(keyField, key)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		err = obj.DeleteByTerm(keyField, key)
+		if err != nil {
+			return false, err
+		}
+	}
+	if tick, ok := obj.pendingKeys[sessionKey]; ok {
+		obj.pendingUpdates[tick] = pendingUpdate{pending, boost}
+		return true, nil
+	}
+	if obj.pendingKeys == nil {
+		obj.pendingKeys = make(map[string]int)
+	}
+	obj.pendingKeys[sessionKey] = len(obj.pendingUpdates)
+	obj.pendingUpdates = append(obj.pendingUpdates, pendingUpdate{pending, boost})
+	return count > 0, nil
+}
+
+// Copy a Doc, map or struct into a new Doc, so that the caller may reuse it.
+func (obj *IndexerIMP) copyDoc(doc interface{}) (Doc, error) {
+	docCopy := NewDoc(0)
+	d := (*C.lucy_Doc)(clownfish.Unwrap(docCopy, "docCopy"))
+	var err error
+	switch source := doc.(type) {
+	case Doc:
+		docFields := fetchDocFields(d)
+		for field, value := range source.GetFields() {
+			docFields[field] = value
+		}
+	case map[string]interface{}:
+		err = obj.fillDocFromMap(d, source)
+	default:
+		err = obj.fillDocFromStruct(d, doc)
+	}
+	return docCopy, err
+}
+
+// Add the docs held by UpdateDoc to the index.
+func (obj *IndexerIMP) flushUpdates() error {
+	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
+	updates := obj.pendingUpdates
+	obj.pendingUpdates = nil
+	obj.pendingKeys = nil
+	for _, update := range updates {
+		d := (*C.lucy_Doc)(clownfish.Unwrap(update.doc, "doc"))
+		err := clownfish.TrapErr(func() {
+			C.LUCY_Indexer_Add_Doc(self, d, C.float(update.boost))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify that a field can serve as a primary key: it must be indexed and
+// must not be analyzed.
+func (obj *IndexerIMP) checkKeyField(field string) error {
+	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
+	schema := C.LUCY_Indexer_Get_Schema(self)
+	fieldC := (*C.cfish_String)(clownfish.GoToClownfish(field, unsafe.Pointer(C.CFISH_STRING), false))
+	defer C.cfish_decref(unsafe.Pointer(fieldC))
+	fieldType := C.LUCY_Schema_Fetch_Type(schema, fieldC)
+	fieldTypeObj := (*C.cfish_Obj)(unsafe.Pointer(fieldType))
+	if fieldType == nil || !C.LUCY_FType_Indexed(fieldType) {
+		return clownfish.NewErr(fmt.Sprintf("Key field '%s' is not indexed", field))
+	}
+	if !C.cfish_Obj_is_a(fieldTypeObj, C.LUCY_STRINGTYPE) {
+		mess := fmt.Sprintf("Key field '%s' must be an unanalyzed StringType", field)
+		return clownfish.NewErr(mess)
+	}
+	return nil
+}
+
+// Extract the value of the key field from a Doc, map or struct.
+func (obj *IndexerIMP) extractKey(keyField string, doc interface{}) (string, error) {
+	var value interface{}
+	switch d := doc.(type) {
+	case Doc:
+		value = d.Extract(keyField)
+	case map[string]interface{}:
+		for name, val := range d {
+			if field, err := obj.findRealField(name); err == nil && field == keyField {
+				value = val
+				break
+			}
+		}
+	default:
+		docValue := reflect.ValueOf(doc)
+		if docValue.Kind() == reflect.Ptr && docValue.Elem().Kind() == reflect.Struct {
+			fieldValue := findStructField(docValue.Elem(), keyField)
+			if fieldValue.IsValid() && fieldValue.Kind() == reflect.String {
+				value = fieldValue.String()
+			}
+		}
+	}
+	key, ok := value.(string)
+	if !ok || key == "" {
+		mess := fmt.Sprintf("Doc has no string value for key field '%s'", keyField)
+		return "", clownfish.NewErr(mess)
+	}
+	return key, nil
+}
+
+// Count the live docs committed before this indexing session began which
+// contain the supplied term.
+func (obj *IndexerIMP) countCommitted(field string, term string) (count uint32, err error) {
+	self := (*C.lucy_Indexer)(clownfish.Unwrap(obj, "obj"))
+	ivars := C.lucy_Indexer_IVARS(self)
+	if ivars.polyreader == nil || ivars.prepared {
+		return 0, clownfish.NewErr("Can't update docs after PrepareCommit, Rollback or Close")
+	}
+	fieldC := (*C.cfish_String)(clownfish.GoToClownfish(field, unsafe.Pointer(C.CFISH_STRING), false))
+	defer C.cfish_decref(unsafe.Pointer(fieldC))
+	termC := (*C.cfish_String)(clownfish.GoToClownfish(term, unsafe.Pointer(C.CFISH_STRING), false))
+	defer C.cfish_decref(unsafe.Pointer(termC))
+	err = clownfish.TrapErr(func() {
+		count = uint32(C.count_live_docs(self, fieldC, termC))
+	})
+	return count, err
+}
+
 func (obj *IndexerIMP) findRealField(name string) (string, error) {
 	self := ((*C.lucy_Indexer)(unsafe.Pointer(obj.TOPTR())))
 	if obj.fieldNames == nil {
@@ -226,6 +539,9 @@ func (obj *IndexerIMP) DeleteByDocID(docID int32) error {
 
 func (obj *IndexerIMP) PrepareCommit() error {
 	self := ((*C.lucy_Indexer)(unsafe.Pointer(obj.TOPTR())))
+	if err := obj.flushUpdates(); err != nil {
+		return err
+	}
 	return clownfish.TrapErr(func() {
 		C.LUCY_Indexer_Prepare_Commit(self)
 	})
@@ -233,11 +549,50 @@ func (obj *IndexerIMP) PrepareCommit() error {
 
 func (obj *IndexerIMP) Commit() error {
 	self := ((*C.lucy_Indexer)(unsafe.Pointer(obj.TOPTR())))
+	if err := obj.flushUpdates(); err != nil {
+		return err
+	}
 	return clownfish.TrapErr(func() {
 		C.LUCY_Indexer_Commit(self)
 	})
 }
 
+// CommitContext is like Indexer.Commit, but stops cleanly with ctx.Err() if
+// the context is done.  The context is only checked before and after
+// PrepareCommit.  PrepareCommit, which does the expensive merging and
+// writing, can't be interrupted, so CommitContext may keep running for as
+// long as the merge takes after the context is done.  When CommitContext
+// gives up, it rolls back the indexing session, discarding its changes and
+// releasing the write lock, so the Indexer can't be used afterwards.
+func CommitContext(ctx context.Context, indexer Indexer) error {
+	if err := ctx.Err(); err != nil {
+		indexer.Rollback()
+		return err
+	}
+	if err := indexer.PrepareCommit(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		indexer.Rollback()
+		return err
+	}
+	return indexer.Commit()
+}
+
+// BGMergerCommitContext is the BackgroundMerger counterpart of
+// CommitContext.  A BackgroundMerger can't be rolled back, so the context is
+// only checked before PrepareCommit; once the merge has been prepared, it is
+// always committed.
+func BGMergerCommitContext(ctx context.Context, bgm BackgroundMerger) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := bgm.PrepareCommit(); err != nil {
+		return err
+	}
+	return bgm.Commit()
+}
+
 func (d *DataWriterIMP) addInvertedDoc(inverter Inverter, docId int32) error {
 	return clownfish.TrapErr(func() {
 		self := (*C.lucy_DataWriter)(clownfish.Unwrap(d, "d"))
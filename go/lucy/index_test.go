@@ -16,9 +16,13 @@
 
 package lucy
 
+import "context"
+import "math"
 import "testing"
 import "os"
 import "reflect"
+import "sort"
+import "time"
 
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
 
@@ -42,6 +46,218 @@ func TestIndexerAddDoc(t *testing.T) {
 	}
 }
 
+type taggedTestDoc struct {
+	Body     string `lucy:"content"`
+	Internal string `lucy:"-"`
+	Note     string `json:"note" lucy:"-"`
+	hidden   string
+}
+
+func TestIndexerAddDocStructTags(t *testing.T) {
+	schema := createTestSchema()
+	index := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{
+		Create: true,
+		Index:  index,
+		Schema: schema,
+	})
+	err := indexer.AddDoc(&taggedTestDoc{Body: "foo", Internal: "x", Note: "y"})
+	if err != nil {
+		t.Errorf("AddDoc with tagged struct: %v", err)
+	}
+	indexer.Commit()
+	searcher, _ := OpenIndexSearcher(index)
+	if got := searcher.DocFreq("content", "foo"); got != 1 {
+		t.Errorf("Tagged field not indexed -- DocFreq: %d", got)
+	}
+}
+
+type typedTestDoc struct {
+	Content string
+	Count   int32
+	Total   int64
+	Ratio   float32
+	Price   float64
+	Data    []byte
+	Created time.Time
+}
+
+func createTypedTestSchema() Schema {
+	schema := NewSchema()
+	schema.SpecField("content", NewFullTextType(NewStandardTokenizer()))
+	schema.SpecField("count", NewInt32Type())
+	schema.SpecField("total", NewInt64Type())
+	schema.SpecField("ratio", NewFloat32Type())
+	schema.SpecField("price", NewFloat64Type())
+	schema.SpecField("data", NewBlobType(true))
+	schema.SpecField("created", NewInt64Type())
+	return schema
+}
+
+func TestIndexerTypedStructFields(t *testing.T) {
+	index := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{
+		Create: true,
+		Index:  index,
+		Schema: createTypedTestSchema(),
+	})
+	doc := typedTestDoc{
+		Content: "foo",
+		Count:   42,
+		Total:   1 << 40,
+		Ratio:   0.5,
+		Price:   9.99,
+		Data:    []byte{0, 1, 2},
+		Created: time.Unix(1500000000, 0).UTC(),
+	}
+	err := indexer.AddDoc(&doc)
+	if err != nil {
+		t.Errorf("AddDoc with typed struct: %v", err)
+	}
+	err = indexer.AddDoc(&struct{ Content chan int }{})
+	if err == nil {
+		t.Error("AddDoc should fail for unconvertible field type")
+	}
+	indexer.Commit()
+
+	searcher, _ := OpenIndexSearcher(index)
+	var got typedTestDoc
+	err = searcher.ReadDoc(1, &got)
+	if err != nil {
+		t.Errorf("ReadDoc with typed struct: %v", err)
+	}
+	if !reflect.DeepEqual(got, doc) {
+		t.Errorf("Typed round trip: expected %v, got %v", doc, got)
+	}
+	var mismatched struct{ Count string }
+	if err = searcher.ReadDoc(1, &mismatched); err == nil {
+		t.Error("ReadDoc should fail for unconvertible field type")
+	}
+}
+
+type boostedTestDoc struct {
+	Content string
+	Boost   float32 `lucy:",boost"`
+}
+
+type boosterTestDoc struct {
+	Content string
+}
+
+func (d *boosterTestDoc) LucyBoost() float32 {
+	return 100.0
+}
+
+func TestIndexerAddDocWithBoost(t *testing.T) {
+	index := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{
+		Create: true,
+		Index:  index,
+		Schema: createTestSchema(),
+	})
+	indexer.AddDoc(&testDoc{Content: "foo 1"})
+	err := indexer.AddDocWithBoost(map[string]interface{}{"content": "foo 2"}, 10.0)
+	if err != nil {
+		t.Errorf("AddDocWithBoost: %v", err)
+	}
+	err = indexer.AddDoc(&boostedTestDoc{Content: "foo 3", Boost: 1000.0})
+	if err != nil {
+		t.Errorf("AddDoc with boost field: %v", err)
+	}
+	indexer.AddDoc(&boosterTestDoc{Content: "foo 4"})
+	err = indexer.AddDoc(&struct {
+		Content string
+		Boost   string `lucy:",boost"`
+	}{"foo", "high"})
+	if err == nil {
+		t.Error("AddDoc should reject non-float boost field")
+	}
+	indexer.Commit()
+
+	searcher, _ := OpenIndexSearcher(index)
+	hits, _ := searcher.Hits("foo", 0, 10, nil)
+	var doc boostedTestDoc
+	var order []string
+	for hits.Next(&doc) {
+		order = append(order, doc.Content)
+	}
+	expected := []string{"foo 3", "foo 4", "foo 2", "foo 1"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Boosts not applied: %v", order)
+	}
+}
+
+type keyedTestDoc struct {
+	ID      string
+	Content string
+}
+
+func TestIndexerUpdateDoc(t *testing.T) {
+	schema := createTestSchema()
+	schema.SpecField("id", NewStringType())
+	index := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{
+		Create: true,
+		Index:  index,
+		Schema: schema,
+	})
+	indexer.AddDoc(&keyedTestDoc{ID: "1", Content: "foo"})
+	indexer.AddDoc(&keyedTestDoc{ID: "5", Content: "old"})
+	if keys := indexer.(*IndexerIMP).addedKeys; len(keys) != 0 {
+		t.Errorf("Keys tracked before UpdateDoc was used: %v", keys)
+	}
+	indexer.Commit()
+
+	indexer, _ = OpenIndexer(&OpenIndexerArgs{Index: index})
+	doc := &keyedTestDoc{ID: "1", Content: "bar"}
+	replaced, err := indexer.UpdateDoc("id", doc)
+	if err != nil || !replaced {
+		t.Errorf("UpdateDoc existing: %v, %v", replaced, err)
+	}
+	doc.ID, doc.Content = "2", "baz"
+	replaced, err = indexer.UpdateDoc("id", doc)
+	if err != nil || replaced {
+		t.Errorf("UpdateDoc new: %v, %v", replaced, err)
+	}
+	replaced, err = indexer.UpdateDoc("id", map[string]interface{}{"id": "2", "content": "quux"})
+	if err != nil || !replaced {
+		t.Errorf("UpdateDoc twice in one session: %v, %v", replaced, err)
+	}
+	indexer.DeleteByTerm("id", "5")
+	replaced, err = indexer.UpdateDoc("id", &keyedTestDoc{ID: "5", Content: "five"})
+	if err != nil || replaced {
+		t.Errorf("UpdateDoc after DeleteByTerm: %v, %v", replaced, err)
+	}
+	indexer.AddDoc(&keyedTestDoc{ID: "6", Content: "six"})
+	if _, err = indexer.UpdateDoc("id", &keyedTestDoc{ID: "6", Content: "x"}); err == nil {
+		t.Error("Updating a key added in the same session should fail")
+	}
+	if err = indexer.AddDoc(&keyedTestDoc{ID: "2", Content: "dup"}); err == nil {
+		t.Error("Adding a key with a pending update should fail")
+	}
+	if _, err = indexer.UpdateDoc("content", &keyedTestDoc{ID: "3", Content: "x"}); err == nil {
+		t.Error("UpdateDoc should reject an analyzed key field")
+	}
+	if _, err = indexer.UpdateDoc("id", &keyedTestDoc{Content: "x"}); err == nil {
+		t.Error("UpdateDoc should reject a doc without a key")
+	}
+	indexer.Commit()
+
+	searcher, _ := OpenIndexSearcher(index)
+	if got := searcher.GetReader().DocCount(); got != 4 {
+		t.Errorf("Expected 4 docs after UpdateDoc, got %d", got)
+	}
+	for term, expected := range map[string]uint32{
+		"foo": 0, "old": 0, "bar": 1, "baz": 0, "quux": 1, "five": 1, "six": 1,
+		"dup": 0,
+	} {
+		hits, _ := searcher.Hits(NewTermQuery("content", term), 0, 10, nil)
+		if got := hits.TotalHits(); got != expected {
+			t.Errorf("Expected %d docs containing %q, got %d", expected, term, got)
+		}
+	}
+}
+
 func TestIndexerAddIndex(t *testing.T) {
 	var err error
 	origIndex := "_test_go_indexer_add_index"
@@ -130,6 +346,130 @@ func TestIndexerMisc(t *testing.T) {
 	}
 }
 
+func TestIndexerClose(t *testing.T) {
+	index := createTestIndex("foo")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.AddDoc(&testDoc{Content: "bar"})
+	if err := indexer.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := indexer.Close(); err != nil {
+		t.Errorf("Close should be idempotent: %v", err)
+	}
+	if err := indexer.AddDoc(&testDoc{Content: "baz"}); err == nil {
+		t.Error("AddDoc after Close should fail")
+	}
+	if err := indexer.Commit(); err == nil {
+		t.Error("Commit after Close should fail")
+	}
+
+	// The write lock must have been released.
+	indexer, err := OpenIndexer(&OpenIndexerArgs{Index: index})
+	if err != nil {
+		t.Fatalf("OpenIndexer after Close: %v", err)
+	}
+	indexer.Commit()
+	indexer.Close()
+	searcher, _ := OpenIndexSearcher(index)
+	if got := searcher.DocMax(); got != 1 {
+		t.Errorf("Uncommitted doc survived Close -- DocMax: %d", got)
+	}
+}
+
+func TestIndexerRollback(t *testing.T) {
+	index := createTestIndex("foo")
+	before, _ := index.List("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.AddDoc(&testDoc{Content: "bar"})
+	if err := indexer.PrepareCommit(); err != nil {
+		t.Errorf("PrepareCommit: %v", err)
+	}
+	if err := indexer.Rollback(); err != nil {
+		t.Errorf("Rollback: %v", err)
+	}
+	if err := indexer.Rollback(); err == nil {
+		t.Error("Rollback twice should fail")
+	}
+	if err := indexer.Commit(); err == nil {
+		t.Error("Commit after Rollback should fail")
+	}
+	indexer.Close()
+	after, _ := index.List("")
+	sort.Strings(before)
+	sort.Strings(after)
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("Rollback left files behind: %v vs. %v", before, after)
+	}
+	searcher, _ := OpenIndexSearcher(index)
+	if got := searcher.DocMax(); got != 1 {
+		t.Errorf("Rolled back doc was committed -- DocMax: %d", got)
+	}
+}
+
+// expiringContext reports itself done once Err has been consulted a given
+// number of times.
+type expiringContext struct {
+	context.Context
+	checks int
+}
+
+func (ctx *expiringContext) Err() error {
+	if ctx.checks <= 0 {
+		return context.DeadlineExceeded
+	}
+	ctx.checks--
+	return nil
+}
+
+func TestCommitContext(t *testing.T) {
+	index := createTestIndex("foo")
+	reopen := func(when string) {
+		indexer, err := OpenIndexer(&OpenIndexerArgs{Index: index})
+		if err != nil {
+			t.Fatalf("OpenIndexer after CommitContext %s: %v", when, err)
+		}
+		indexer.Close()
+		searcher, _ := OpenIndexSearcher(index)
+		if got := searcher.DocMax(); got != 1 {
+			t.Errorf("CommitContext %s committed -- DocMax: %d", when, got)
+		}
+	}
+
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.AddDoc(&testDoc{Content: "bar"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CommitContext(ctx, indexer); err != context.Canceled {
+		t.Errorf("CommitContext with canceled context: %v", err)
+	}
+	if err := indexer.AddDoc(&testDoc{Content: "baz"}); err == nil {
+		t.Error("Indexer should be unusable after canceled CommitContext")
+	}
+	reopen("with canceled context")
+
+	indexer, _ = OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.AddDoc(&testDoc{Content: "bar"})
+	expiring := &expiringContext{Context: context.Background(), checks: 1}
+	if err := CommitContext(expiring, indexer); err != context.DeadlineExceeded {
+		t.Errorf("CommitContext expiring during PrepareCommit: %v", err)
+	}
+	reopen("expiring during PrepareCommit")
+
+	indexer, _ = OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.AddDoc(&testDoc{Content: "bar"})
+	if err := CommitContext(context.Background(), indexer); err != nil {
+		t.Errorf("CommitContext: %v", err)
+	}
+	searcher, _ := OpenIndexSearcher(index)
+	if got := searcher.DocMax(); got != 2 {
+		t.Errorf("CommitContext didn't commit -- DocMax: %d", got)
+	}
+	merger, _ := OpenBackgroundMerger(index, nil)
+	if err := BGMergerCommitContext(context.Background(), merger); err != nil {
+		t.Errorf("BGMergerCommitContext: %v", err)
+	}
+}
+
 func TestBackgroundMergerMisc(t *testing.T) {
 	var err error
 	index := createTestIndex("foo", "bar", "baz")
@@ -458,6 +798,187 @@ func TestSimilarityRoundTrip(t *testing.T) {
 	}
 }
 
+type flatSimilarity struct {
+	ClassicSimilarity
+}
+
+func (flatSimilarity) TF(freq float32) float32             { return 1 }
+func (flatSimilarity) LengthNorm(numTokens uint32) float32 { return 1 }
+
+func createSimilarityTestIndex(schema Schema, values ...string) Folder {
+	folder := NewRAMFolder("")
+	indexer, err := OpenIndexer(&OpenIndexerArgs{Index: folder, Schema: schema, Create: true})
+	if err != nil {
+		panic(err)
+	}
+	defer indexer.Close()
+	for _, val := range values {
+		if err := indexer.AddDoc(&testDoc{val}); err != nil {
+			panic(err)
+		}
+	}
+	if err := indexer.Commit(); err != nil {
+		panic(err)
+	}
+	return folder
+}
+
+func searchScores(t *testing.T, searcher Searcher, query string) map[int32]float32 {
+	hits, err := searcher.Hits(query, 0, 10, nil)
+	if err != nil {
+		t.Fatalf("Hits %q: %v", query, err)
+	}
+	scores := make(map[int32]float32)
+	for docID, score, ok := hits.nextMatch(); ok; docID, score, ok = hits.nextMatch() {
+		scores[docID] = score
+	}
+	return scores
+}
+
+func TestCustomSimilarity(t *testing.T) {
+	sim, err := NewCustomSimilarity("flat", flatSimilarity{})
+	if err != nil {
+		t.Fatalf("NewCustomSimilarity: %v", err)
+	}
+	schema := NewSchema()
+	fieldType := NewFullTextType(NewStandardTokenizer())
+	fieldType.SetSimilarity(sim)
+	schema.SpecField("content", fieldType)
+	index := createSimilarityTestIndex(schema, "a a", "a b c d")
+
+	// Open from the Folder so that the Similarity is loaded from the Schema.
+	searcher, err := OpenIndexSearcher(index)
+	if err != nil {
+		t.Fatalf("OpenIndexSearcher: %v", err)
+	}
+	scores := searchScores(t, searcher, "a")
+	if len(scores) != 2 || scores[1] != scores[2] {
+		t.Errorf("Expected equal scores ignoring freq and length: %v", scores)
+	}
+
+	if !sim.Equals(sim.load(sim.dump())) {
+		t.Errorf("Dump/Load round-trip")
+	}
+	other, _ := NewCustomSimilarity("other", flatSimilarity{})
+	if sim.Equals(other) {
+		t.Errorf("Similarities with different names should differ")
+	}
+	if _, err := NewCustomSimilarity("flat", ClassicSimilarity{}); err == nil {
+		t.Error("Reusing a name for a different CustomSimilarity should fail")
+	}
+}
+
+func createBM25Schema(sim BM25Similarity) Schema {
+	schema := NewSchema()
+	schema.SetSimilarity(sim)
+	fieldType := NewFullTextType(NewStandardTokenizer())
+	fieldType.SetSimilarity(sim)
+	schema.SpecField("content", fieldType)
+	return schema
+}
+
+func TestBM25Similarity(t *testing.T) {
+	sim := NewBM25Similarity(DefaultBM25K1, DefaultBM25B)
+	index := createSimilarityTestIndex(createBM25Schema(sim), "a b c d", "a", "b c")
+	searcher, err := OpenIndexSearcher(index)
+	if err != nil {
+		t.Fatalf("OpenIndexSearcher: %v", err)
+	}
+
+	// Doc 2 holds one token, and the field averages 7/3 tokens per doc.
+	k1, b, avgLength := float64(DefaultBM25K1), float64(DefaultBM25B), 7.0/3.0
+	idf := math.Log(1 + (3-2+0.5)/(2+0.5))
+	expected := idf * (k1 + 1) / (1 + k1*(1-b+b/avgLength))
+	scores := searchScores(t, searcher, "a")
+	if got := float64(scores[2]); math.Abs(got-expected) > 1e-4 {
+		t.Errorf("BM25 score %v, expected %v", got, expected)
+	}
+	if scores[1] >= scores[2] {
+		t.Errorf("Longer field should score lower: %v", scores)
+	}
+	if got := searchScores(t, searcher, "a OR b")[2]; math.Abs(float64(got-scores[2])) > 1e-6 {
+		t.Errorf("Compound score %v should be the plain sum %v", got, scores[2])
+	}
+
+	expl, err := searcher.Explain("a", 2)
+	if err != nil || math.Abs(float64(expl.Value-scores[2])) > 1e-6 {
+		t.Errorf("Explain: %v %v", expl, err)
+	}
+	if got := sim.TFNorm(1, 1, float32(avgLength)); math.Abs(float64(expl.Details[3].Value-got)) > 1e-6 {
+		t.Errorf("Explain tfNorm %v, expected %v", expl.Details[3].Value, got)
+	}
+
+	if !sim.Equals(sim.load(sim.dump())) {
+		t.Errorf("Dump/Load round-trip")
+	}
+	if sim.Equals(NewBM25Similarity(2.0, DefaultBM25B)) {
+		t.Errorf("Similarities with different k1 should differ")
+	}
+}
+
+// Find the average field length used to score the docs matching a
+// single-term query.
+func bm25AvgLength(t *testing.T, searcher Searcher, query string) float64 {
+	var docID int32
+	for id := range searchScores(t, searcher, query) {
+		docID = id
+	}
+	expl, err := searcher.Explain(query, docID)
+	if err != nil || len(expl.Details) != 5 || len(expl.Details[3].Details) != 5 {
+		t.Fatalf("Explain: %v, %v", expl, err)
+	}
+	return float64(expl.Details[3].Details[4].Value)
+}
+
+func TestBM25FieldTotals(t *testing.T) {
+	sim := NewBM25Similarity(DefaultBM25K1, DefaultBM25B)
+	index := createSimilarityTestIndex(createBM25Schema(sim), "a b c d", "a", "b c")
+
+	searcher, _ := OpenIndexSearcher(index)
+	segment := searcher.GetReader().SegReaders()[0].GetSegment()
+	expected := map[string]interface{}{
+		"content": map[string]interface{}{"docs": "3", "tokens": "7"},
+	}
+	if got := segment.FetchMetadata("bm25"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Recorded totals: %v", got)
+	}
+	if got := bm25AvgLength(t, searcher, "a"); math.Abs(got-7.0/3.0) > 1e-6 {
+		t.Errorf("Average from recorded totals: %v", got)
+	}
+
+	// Merging records fresh totals for the new segment.
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.AddDoc(&testDoc{"x y z w v"})
+	indexer.Optimize()
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	searcher, _ = OpenIndexSearcher(index)
+	if got := bm25AvgLength(t, searcher, "a"); math.Abs(got-3) > 1e-6 {
+		t.Errorf("Average after merge: %v", got)
+	}
+
+	// Deleted docs stay in the totals until their segment is merged away.
+	indexer, _ = OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.DeleteByTerm("content", "x")
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	searcher, _ = OpenIndexSearcher(index)
+	if got := bm25AvgLength(t, searcher, "a"); math.Abs(got-3) > 1e-6 {
+		t.Errorf("Average before merging away deletions: %v", got)
+	}
+	indexer, _ = OpenIndexer(&OpenIndexerArgs{Index: index})
+	indexer.Optimize()
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	searcher, _ = OpenIndexSearcher(index)
+	if got := bm25AvgLength(t, searcher, "a"); math.Abs(got-7.0/3.0) > 1e-6 {
+		t.Errorf("Average after merging away deletions: %v", got)
+	}
+}
+
 func TestSegmentMisc(t *testing.T) {
 	var err error
 
@@ -0,0 +1,45 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#ifndef GOLUCY_LIVE_DOCS_H
+#define GOLUCY_LIVE_DOCS_H
+
+#include <stdint.h>
+#include "Lucy/Search/Matcher.h"
+
+// Advance the matcher to the next doc which has not been deleted, returning
+// 0 once the matcher is exhausted.  `*next_deletion` tracks the position of
+// the `deletions` iterator across calls; start it at 0, or at INT32_MAX if
+// there are no deletions, in which case `deletions` may be NULL.
+static inline int32_t
+next_live_doc(lucy_Matcher *matcher, lucy_Matcher *deletions,
+              int32_t *next_deletion) {
+	while (1) {
+		int32_t doc_id = LUCY_Matcher_Next(matcher);
+		if (!doc_id) {
+			return 0;
+		}
+		if (doc_id > *next_deletion) {
+			*next_deletion = LUCY_Matcher_Advance(deletions, doc_id);
+			if (*next_deletion == 0) { *next_deletion = INT32_MAX; }
+		}
+		if (doc_id != *next_deletion) {
+			return doc_id;
+		}
+	}
+}
+
+#endif // GOLUCY_LIVE_DOCS_H
@@ -187,7 +187,6 @@ null_terminate_string(char *string, size_t len) {
 import "C"
 import "unsafe"
 import "fmt"
-import "strings"
 import "regexp"
 import "reflect"
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
@@ -200,6 +199,12 @@ func init() {
 	C.testlucy_bootstrap_parcel()
 	registry = newObjRegistry(16)
 	initWRAP()
+	initGoAnalyzerClass()
+	initRemoteSearcherClass()
+	initGoCollectorClass()
+	initSimilarityClasses()
+	initGoQueryClasses()
+	initGoQueryParserClass()
 }
 
 //export GOLUCY_RegexTokenizer_init
@@ -410,15 +415,11 @@ func setMapField(store interface{}, field string, val interface{}) error {
 
 func setStructField(store interface{}, field string, val interface{}) error {
 	structStore := store.(reflect.Value)
-	stringVal := val.(string) // TODO type switch
-	match := func(name string) bool {
-		return strings.EqualFold(field, name)
-	}
-	structField := structStore.FieldByNameFunc(match)
-	if structField != (reflect.Value{}) { // TODO require match?
-		structField.SetString(stringVal)
+	structField := findStructField(structStore, field)
+	if !structField.IsValid() { // TODO require match?
+		return nil
 	}
-	return nil
+	return setStructValue(structField, val, field)
 }
 
 func doReadDocData(ddrC *C.lucy_DefaultDocReader, docID int32, doc interface{}) error {
@@ -0,0 +1,571 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "Lucy/Search/Query.h"
+#include "Lucy/Search/LeafQuery.h"
+#include "Lucy/Search/QueryParser.h"
+#include "Lucy/Plan/Schema.h"
+#include "Clownfish/Class.h"
+#include "Clownfish/String.h"
+#include "Clownfish/Vector.h"
+
+extern lucy_Query*
+GOLUCY_GoQueryParser_Expand_Leaf(lucy_QueryParser *self, lucy_Query *query);
+extern void
+GOLUCY_GoQueryParser_Destroy(lucy_QueryParser *self);
+
+static cfish_Class *go_qparser_class;
+
+// Create a subclass of QueryParser which may expand wildcard and fuzzy
+// terms.
+static cfish_Class*
+init_go_qparser_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Search::GoQueryParser");
+	go_qparser_class = cfish_Class_singleton(name, LUCY_QUERYPARSER);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(go_qparser_class, (cfish_method_t)GOLUCY_GoQueryParser_Expand_Leaf,
+						 LUCY_QParser_Expand_Leaf_OFFSET);
+	CFISH_Class_Override(go_qparser_class, (cfish_method_t)GOLUCY_GoQueryParser_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return go_qparser_class;
+}
+
+static lucy_Query*
+super_expand_leaf(lucy_QueryParser *self, lucy_Query *query) {
+	LUCY_QParser_Expand_Leaf_t super_expand_leaf
+		= CFISH_SUPER_METHOD_PTR(go_qparser_class, LUCY_QParser_Expand_Leaf);
+	return super_expand_leaf(self, query);
+}
+
+static lucy_QueryParser*
+make_go_qparser(lucy_Schema *schema, cfish_String *default_boolop,
+				cfish_Vector *fields) {
+	lucy_QueryParser *self
+		= (lucy_QueryParser*)CFISH_Class_Make_Obj(go_qparser_class);
+	return lucy_QParser_init(self, schema, NULL, default_boolop, fields);
+}
+*/
+import "C"
+import "container/heap"
+import "fmt"
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// DefaultMaxExpansions is the number of terms which a PrefixQuery,
+// WildcardQuery or RegexpQuery may match unless TermExpansionArgs says
+// otherwise.
+const DefaultMaxExpansions = 1024
+
+// TermExpansionArgs configures the queries which match every term of a
+// field that fits a pattern.
+type TermExpansionArgs struct {
+	// MaxExpansions limits the number of distinct terms which the pattern
+	// may match across the index.  Searching with a query which matches
+	// more fails with an error.  Defaults to DefaultMaxExpansions.
+	MaxExpansions int
+	// Scored makes the query score docs like an ORQuery of a TermQuery for
+	// each matching term.  Otherwise every matching doc gets the same
+	// score, which is much cheaper when many terms match.
+	Scored bool
+}
+
+// A termPattern selects terms from a Lexicon, which is sought to the
+// pattern's literal prefix.
+type termPattern interface {
+	prefix() string
+	match(term string) bool
+}
+
+type prefixPattern string
+
+func (p prefixPattern) prefix() string         { return string(p) }
+func (p prefixPattern) match(term string) bool { return true }
+
+type regexpPattern struct {
+	literal string
+	rx      *regexp.Regexp
+}
+
+func (p *regexpPattern) prefix() string         { return p.literal }
+func (p *regexpPattern) match(term string) bool { return p.rx.MatchString(term) }
+
+// termPatternQuery is the CustomQuery behind PrefixQuery, WildcardQuery and
+// RegexpQuery.
+type termPatternQuery struct {
+	field   string
+	pattern termPattern
+	args    TermExpansionArgs
+	desc    string
+}
+
+// NewPrefixQuery returns a Query which matches docs containing any term in
+// field which starts with prefix.  args may be nil.
+func NewPrefixQuery(field, prefix string, args *TermExpansionArgs) Query {
+	return newTermPatternQuery(field, prefixPattern(prefix), args, prefix+"*")
+}
+
+// NewWildcardQuery returns a Query which matches docs containing any term in
+// field which fits pattern, where "*" matches any sequence of characters,
+// "?" matches any single character and a backslash escapes the character
+// which follows it.  args may be nil.
+func NewWildcardQuery(field, pattern string, args *TermExpansionArgs) Query {
+	var literal, expr strings.Builder
+	inPrefix := true
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '*' || r == '?':
+			inPrefix = false
+			if r == '*' {
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString(".")
+			}
+			continue
+		case r == '\\' && i+1 < len(runes):
+			i++
+			r = runes[i]
+		}
+		if inPrefix {
+			literal.WriteRune(r)
+		}
+		expr.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	rx := regexp.MustCompile("^(?s:" + expr.String() + ")$")
+	return newTermPatternQuery(field, &regexpPattern{literal.String(), rx}, args, pattern)
+}
+
+// NewRegexpQuery returns a Query which matches docs containing any term in
+// field which the regular expression matches in its entirety.  The syntax
+// is that of the regexp package.  args may be nil.
+func NewRegexpQuery(field, pattern string, args *TermExpansionArgs) (Query, error) {
+	rx, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	literal, _ := rx.LiteralPrefix()
+	return newTermPatternQuery(field, &regexpPattern{literal, rx}, args, "/"+pattern+"/"), nil
+}
+
+func newTermPatternQuery(field string, pattern termPattern, args *TermExpansionArgs,
+	desc string) Query {
+	query := &termPatternQuery{field: field, pattern: pattern, desc: desc}
+	if args != nil {
+		query.args = *args
+	}
+	if query.args.MaxExpansions <= 0 {
+		query.args.MaxExpansions = DefaultMaxExpansions
+	}
+	return NewCustomQuery(query)
+}
+
+func (q *termPatternQuery) String() string {
+	return fmt.Sprintf("%s:%s", q.field, q.desc)
+}
+
+func (q *termPatternQuery) MakeCompiler(searcher Searcher, boost float32) (CustomCompiler, error) {
+	segReaders, err := searcherSegReaders(searcher)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := q.expand(segReaders)
+	if err != nil {
+		return nil, err
+	}
+	if !q.args.Scored || len(terms) == 0 {
+		return &constantTermsCompiler{field: q.field, terms: terms, weight: boost}, nil
+	}
+	children := make([]Query, len(terms))
+	for i, term := range terms {
+		children[i] = NewTermQuery(q.field, term)
+	}
+	return NewORQuery(children).MakeCompiler(searcher, boost, true)
+}
+
+// Find the terms which fit the pattern in every segment, in sorted order.
+func (q *termPatternQuery) expand(segReaders []SegReader) ([]string, error) {
+	found := make(map[string]bool)
+	prefix := q.pattern.prefix()
+	for _, segReader := range segReaders {
+		lexReader, ok := segReader.Fetch("Lucy::Index::LexiconReader").(LexiconReader)
+		if !ok {
+			continue
+		}
+		var lexicon Lexicon
+		var err error
+		if prefix == "" {
+			lexicon, err = lexReader.Lexicon(q.field, nil)
+		} else {
+			lexicon, err = lexReader.Lexicon(q.field, prefix)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if lexicon == nil {
+			continue
+		}
+		more := prefix != "" || lexicon.Next()
+		for ; more; more = lexicon.Next() {
+			term, ok := lexicon.GetTerm().(string)
+			if !ok || !strings.HasPrefix(term, prefix) {
+				break
+			}
+			if found[term] || !q.pattern.match(term) {
+				continue
+			}
+			found[term] = true
+			if len(found) > q.args.MaxExpansions {
+				mess := fmt.Sprintf("%s matches more than %d terms", q, q.args.MaxExpansions)
+				return nil, clownfish.NewErr(mess)
+			}
+		}
+	}
+	terms := make([]string, 0, len(found))
+	for term := range found {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms, nil
+}
+
+// Find the segments searched by an IndexSearcher, or by the IndexSearchers
+// which make up a PolySearcher.
+func searcherSegReaders(searcher Searcher) ([]SegReader, error) {
+	switch s := searcher.(type) {
+	case PolySearcher:
+		var segReaders []SegReader
+		for _, sub := range s.GetSearchers() {
+			subReaders, err := searcherSegReaders(sub)
+			if err != nil {
+				return nil, err
+			}
+			segReaders = append(segReaders, subReaders...)
+		}
+		return segReaders, nil
+	case IndexSearcher:
+		return s.GetReader().SegReaders(), nil
+	}
+	return nil, clownfish.NewErr(fmt.Sprintf("Term expansion not supported for %T", searcher))
+}
+
+// constantTermsCompiler matches the docs which contain any of its terms,
+// giving each the Compiler's weight as its score.
+type constantTermsCompiler struct {
+	field  string
+	terms  []string
+	weight float32
+}
+
+func (c *constantTermsCompiler) SumOfSquaredWeights() float32   { return c.weight * c.weight }
+func (c *constantTermsCompiler) ApplyNormFactor(factor float32) { c.weight *= factor }
+
+func (c *constantTermsCompiler) MakeMatcher(reader SegReader, needScore bool) (Matcher, error) {
+	pListReader, ok := reader.Fetch("Lucy::Index::PostingListReader").(PostingListReader)
+	if !ok || len(c.terms) == 0 {
+		return nil, nil
+	}
+	var pLists postingListHeap
+	for _, term := range c.terms {
+		pList, err := pListReader.PostingList(c.field, term)
+		if err != nil {
+			return nil, err
+		}
+		if pList != nil {
+			pLists = append(pLists, &postingListEntry{pList: pList})
+		}
+	}
+	if len(pLists) == 0 {
+		return nil, nil
+	}
+	return NewCustomMatcher(&postingListUnion{pLists: pLists, score: c.weight}), nil
+}
+
+// postingListUnion streams the docs found in any of several PostingLists,
+// giving each the same score.
+type postingListUnion struct {
+	pLists postingListHeap
+	docID  int32
+	score  float32
+}
+
+func (m *postingListUnion) Next() int32 {
+	return m.Advance(m.docID + 1)
+}
+
+func (m *postingListUnion) Advance(target int32) int32 {
+	for len(m.pLists) > 0 {
+		top := m.pLists[0]
+		if top.docID >= target {
+			m.docID = top.docID
+			return m.docID
+		}
+		top.docID = top.pList.Advance(target)
+		if top.docID == 0 {
+			heap.Pop(&m.pLists)
+		} else {
+			heap.Fix(&m.pLists, 0)
+		}
+	}
+	m.docID = 0
+	return 0
+}
+
+func (m *postingListUnion) GetDocID() int32 { return m.docID }
+func (m *postingListUnion) Score() float32  { return m.score }
+
+// postingListEntry is a PostingList along with its current doc ID, which is
+// 0 before the first call to Advance.
+type postingListEntry struct {
+	pList PostingList
+	docID int32
+}
+
+// postingListHeap orders PostingLists by their current doc ID.
+type postingListHeap []*postingListEntry
+
+func (h postingListHeap) Len() int            { return len(h) }
+func (h postingListHeap) Less(i, j int) bool  { return h[i].docID < h[j].docID }
+func (h postingListHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *postingListHeap) Push(x interface{}) { *h = append(*h, x.(*postingListEntry)) }
+
+func (h *postingListHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+var goQueryParserClass *C.cfish_Class
+
+// The TermExpansionArgs of QueryParsers which expand wildcard and fuzzy
+// terms, keyed by C pointer.
+var qParserExpansions = newHostObjRegistry()
+
+func initGoQueryParserClass() {
+	goQueryParserClass = C.init_go_qparser_class()
+	clownfish.RegisterWrapFuncs(map[unsafe.Pointer]clownfish.WrapFunc{
+		unsafe.Pointer(goQueryParserClass): WRAPQueryParserASOBJ,
+	})
+}
+
+func makeGoQueryParser(schema *C.lucy_Schema, defaultBoolop *C.cfish_String,
+	fields *C.cfish_Vector) *C.lucy_QueryParser {
+	return C.make_go_qparser(schema, defaultBoolop, fields)
+}
+
+// EnableTermExpansion makes the QueryParser turn unquoted terms which hold
+// wildcards into PrefixQuery or WildcardQuery objects configured by args,
+// and terms ending in "~" or "~N" into FuzzyQuery objects.  args may be nil.
+// Term expansion is off by default, since a stray "*" or "?" in a query
+// string may then match a great many terms.  Only QueryParsers created by
+// NewQueryParser, NewORParser or NewANDParser support it.
+func (qp *QueryParserIMP) EnableTermExpansion(args *TermExpansionArgs) error {
+	self := (*C.lucy_QueryParser)(clownfish.Unwrap(qp, "qp"))
+	if C.cfish_Obj_get_class((*C.cfish_Obj)(unsafe.Pointer(self))) != goQueryParserClass {
+		return clownfish.NewErr("Term expansion not supported by this QueryParser")
+	}
+	var expansion TermExpansionArgs
+	if args != nil {
+		expansion = *args
+	}
+	qParserExpansions.store(unsafe.Pointer(self), &expansion)
+	return nil
+}
+
+//export GOLUCY_GoQueryParser_Expand_Leaf
+func GOLUCY_GoQueryParser_Expand_Leaf(self *C.lucy_QueryParser, query *C.lucy_Query) *C.lucy_Query {
+	if args, ok := qParserExpansions.fetch(unsafe.Pointer(self)).(*TermExpansionArgs); ok {
+		if expanded := expandMultiTermLeaf(self, query, args); expanded != nil {
+			return (*C.lucy_Query)(C.cfish_incref(clownfish.Unwrap(expanded, "expanded")))
+		}
+	}
+	return C.super_expand_leaf(self, query)
+}
+
+//export GOLUCY_GoQueryParser_Destroy
+func GOLUCY_GoQueryParser_Destroy(self *C.lucy_QueryParser) {
+	qParserExpansions.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), goQueryParserClass)
+}
+
+// Turn an unquoted LeafQuery whose text holds wildcards into a PrefixQuery
+// or WildcardQuery for each field it applies to, and one ending in "~" or
+// "~N" into a FuzzyQuery.  The fuzzy term and the literal parts of the
+// pattern are run through each field's analyzer, so that they are
+// normalized like the indexed terms.
+func expandMultiTermLeaf(self *C.lucy_QueryParser, query *C.lucy_Query,
+	args *TermExpansionArgs) Query {
+	if !C.cfish_Obj_is_a((*C.cfish_Obj)(unsafe.Pointer(query)), C.LUCY_LEAFQUERY) {
+		return nil
+	}
+	leaf := (*C.lucy_LeafQuery)(unsafe.Pointer(query))
+	text := strings.TrimSpace(clownfish.CFStringToGo(unsafe.Pointer(C.LUCY_LeafQuery_Get_Text(leaf))))
+	if strings.HasPrefix(text, "\"") {
+		return nil
+	}
+	schema := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+		unsafe.Pointer(C.LUCY_QParser_Get_Schema(self))))).(Schema)
+	var makeQuery func(field string) Query
+	if term, maxEdits, ok := splitFuzzy(text); ok {
+		term = unescapeWildcards(term)
+		makeQuery = func(field string) Query {
+			fuzzy, _ := NewFuzzyQuery(field, normalizeTerm(schema.fetchAnalyzer(field), term),
+				maxEdits, 0)
+			return fuzzy
+		}
+	} else if hasWildcard(text) {
+		makeQuery = func(field string) Query {
+			pattern := normalizePattern(schema.fetchAnalyzer(field), text)
+			prefix := strings.TrimSuffix(pattern, "*")
+			if prefix != pattern && !hasWildcard(prefix) && !strings.HasSuffix(prefix, "\\") {
+				return NewPrefixQuery(field, unescapeWildcards(prefix), args)
+			}
+			return NewWildcardQuery(field, pattern, args)
+		}
+	} else {
+		return nil
+	}
+	var fields []string
+	if fieldC := C.LUCY_LeafQuery_Get_Field(leaf); fieldC != nil {
+		fields = []string{clownfish.CFStringToGo(unsafe.Pointer(fieldC))}
+	} else {
+		fields = vecToStringSlice(C.LUCY_QParser_Get_Fields(self))
+	}
+	queries := make([]Query, len(fields))
+	for i, field := range fields {
+		queries[i] = makeQuery(field)
+	}
+	if len(queries) == 1 {
+		return queries[0]
+	}
+	return NewORQuery(queries)
+}
+
+// Run a term through an analyzer, keeping it as it is if there is no
+// analyzer, as for StringType fields, or if it doesn't yield exactly one
+// term.
+func normalizeTerm(analyzer Analyzer, term string) string {
+	if analyzer == nil {
+		return term
+	}
+	if terms := analyzer.Split(term); len(terms) == 1 {
+		return terms[0]
+	}
+	return term
+}
+
+// Normalize the literal parts of a wildcard pattern with normalizeTerm,
+// leaving the wildcards in place.
+func normalizePattern(analyzer Analyzer, pattern string) string {
+	if analyzer == nil {
+		return pattern
+	}
+	var b, literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			b.WriteString(escapeWildcards(normalizeTerm(analyzer, literal.String())))
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			literal.WriteByte(pattern[i])
+		case c == '*' || c == '?':
+			flush()
+			b.WriteByte(c)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// Split text such as "recieve~1" into the term and the number of edits it
+// allows, which defaults to MaxFuzzyEdits and is capped at it.
+func splitFuzzy(text string) (string, int, bool) {
+	tilde := -1
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '~':
+			tilde = i
+		}
+	}
+	if tilde <= 0 || hasWildcard(text[:tilde]) {
+		return "", 0, false
+	}
+	digits := text[tilde+1:]
+	maxEdits := MaxFuzzyEdits
+	if digits != "" {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 0 {
+			return "", 0, false
+		}
+		if n < maxEdits {
+			maxEdits = n
+		}
+	}
+	return text[:tilde], maxEdits, true
+}
+
+// Report whether text holds a "*" or "?" which isn't escaped.
+func hasWildcard(text string) bool {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '*', '?':
+			return true
+		}
+	}
+	return false
+}
+
+func escapeWildcards(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\', '*', '?':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+func unescapeWildcards(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			i++
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
@@ -18,12 +18,20 @@ package lucy
 
 /*
 #include "Lucy/Plan/Schema.h"
+#include "Lucy/Plan/FieldType.h"
 #include "Lucy/Plan/FullTextType.h"
+#include "Clownfish/String.h"
 #include "Clownfish/Vector.h"
 */
 import "C"
+import "fmt"
+import "reflect"
+import "strconv"
+import "strings"
 import "unsafe"
 
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
 func (s *SchemaIMP) AllFields() []string {
 	self := (*C.lucy_Schema)(unsafe.Pointer(s.TOPTR()))
 	fieldsCF := C.LUCY_Schema_All_Fields(self)
@@ -31,3 +39,229 @@ func (s *SchemaIMP) AllFields() []string {
 	return vecToStringSlice(fieldsCF)
 }
 
+// Return the primitive type ID of the named field, or 0 if the field is not
+// in the Schema.
+func schemaPrimitiveID(schema *C.lucy_Schema, field string) int {
+	fieldC := (*C.cfish_String)(clownfish.GoToClownfish(field, unsafe.Pointer(C.CFISH_STRING), false))
+	defer C.cfish_decref(unsafe.Pointer(fieldC))
+	fieldType := C.LUCY_Schema_Fetch_Type(schema, fieldC)
+	if fieldType == nil {
+		return 0
+	}
+	return int(C.LUCY_FType_Primitive_ID(fieldType) & C.lucy_FType_PRIMITIVE_ID_MASK)
+}
+
+// SchemaFor builds a Schema from the exported fields of the struct type T,
+// which may also be a pointer to a struct.  See SchemaFromStruct.
+func SchemaFor[T any]() (Schema, error) {
+	return schemaFromType(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// SchemaFromStruct builds a Schema from the exported fields of a struct or
+// pointer to struct.  Each field is configured by an optional struct tag:
+//
+//	Title string  `lucy:"title,fulltext,analyzer=en,highlightable"`
+//	SKU   string  `lucy:"sku,string,sortable"`
+//	Price float64 `lucy:"price,float64,sortable"`
+//	Notes string  `lucy:"-"`
+//
+// The first tag element names the index field; the Go field name is used if
+// it is empty.  The field type is one of `fulltext`, `string`, `blob`,
+// `int32`, `int64`, `float32` or `float64`; if absent, it is inferred from
+// the Go type, with strings becoming `fulltext` and unsigned integers wider
+// than 16 bits becoming `int64`.  Other options:
+//
+//	analyzer=NAME   fulltext only: a language code for EasyAnalyzer,
+//	                `standard` for StandardTokenizer, or the name of a
+//	                CustomAnalyzer.  Defaults to `en`.
+//	boost=FLOAT     field boost.
+//	stored, unstored, indexed, unindexed, sortable, highlightable
+//
+// Fields are stored and, except for blobs, indexed unless the tag says
+// otherwise.
+//
+// Fields tagged `boost` or `excerpt` are not index fields and are skipped.
+func SchemaFromStruct(v interface{}) (Schema, error) {
+	if v == nil {
+		return nil, clownfish.NewErr("SchemaFromStruct requires a struct")
+	}
+	return schemaFromType(reflect.TypeOf(v))
+}
+
+func schemaFromType(t reflect.Type) (Schema, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		mess := fmt.Sprintf("Can't derive a Schema from %v, only a struct", t)
+		return nil, clownfish.NewErr(mess)
+	}
+	schema := NewSchema()
+	analyzers := make(map[string]Analyzer)
+	for _, sf := range structFields(t) {
+		if !sf.holdsValue() {
+			continue
+		}
+		fieldType, err := structFieldType(t.Field(sf.index), sf, analyzers)
+		if err != nil {
+			return nil, err
+		}
+		schema.SpecField(sf.name, fieldType)
+	}
+	return schema, nil
+}
+
+var fieldTypeNames = map[string]bool{
+	"fulltext": true,
+	"string":   true,
+	"blob":     true,
+	"int32":    true,
+	"int64":    true,
+	"float32":  true,
+	"float64":  true,
+}
+
+// Infer the name of the field type for a struct field which doesn't name
+// one in its tag.  Unsigned integers which may not fit in an int32 are
+// stored as int64; values too large even for that are rejected when the doc
+// is added.
+func inferFieldTypeName(t reflect.Type) string {
+	switch {
+	case t == timeType:
+		return "int64"
+	case t == bytesType:
+		return "blob"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "fulltext"
+	case reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint8, reflect.Uint16:
+		return "int32"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "int64"
+	case reflect.Float32:
+		return "float32"
+	case reflect.Float64:
+		return "float64"
+	}
+	return ""
+}
+
+// Build the FieldType for a struct field from its tag options.  Analyzers
+// are shared between fields which use the same analyzer name.
+func structFieldType(goField reflect.StructField, sf structField,
+	analyzers map[string]Analyzer) (FieldType, error) {
+	typeName := ""
+	analyzerName := ""
+	flags := make(map[string]bool)
+	var boost float64 = 1.0
+	for _, opt := range sf.opts {
+		key, val, hasVal := strings.Cut(opt, "=")
+		switch {
+		case fieldTypeNames[opt]:
+			if typeName != "" {
+				mess := fmt.Sprintf("Field '%s' has two types: %s and %s",
+					sf.name, typeName, opt)
+				return nil, clownfish.NewErr(mess)
+			}
+			typeName = opt
+		case key == "analyzer" && hasVal:
+			analyzerName = val
+		case key == "boost" && hasVal:
+			var err error
+			boost, err = strconv.ParseFloat(val, 32)
+			if err != nil {
+				mess := fmt.Sprintf("Bad boost for field '%s': %s", sf.name, val)
+				return nil, clownfish.NewErr(mess)
+			}
+		case opt == "stored" || opt == "unstored" || opt == "indexed" ||
+			opt == "unindexed" || opt == "sortable" || opt == "highlightable":
+			flags[opt] = true
+		default:
+			mess := fmt.Sprintf("Unknown option '%s' for field '%s'", opt, sf.name)
+			return nil, clownfish.NewErr(mess)
+		}
+	}
+	if typeName == "" {
+		typeName = inferFieldTypeName(goField.Type)
+		if typeName == "" {
+			mess := fmt.Sprintf("Can't infer a field type for '%s' from %v",
+				sf.name, goField.Type)
+			return nil, clownfish.NewErr(mess)
+		}
+	}
+	if typeName != "fulltext" && (analyzerName != "" || flags["highlightable"]) {
+		mess := fmt.Sprintf("Field '%s' of type %s can't have an analyzer or be highlightable",
+			sf.name, typeName)
+		return nil, clownfish.NewErr(mess)
+	}
+	if flags["stored"] && flags["unstored"] || flags["indexed"] && flags["unindexed"] {
+		mess := fmt.Sprintf("Contradictory options for field '%s'", sf.name)
+		return nil, clownfish.NewErr(mess)
+	}
+
+	var fieldType FieldType
+	switch typeName {
+	case "fulltext":
+		if analyzerName == "" {
+			analyzerName = "en"
+		}
+		analyzer, ok := analyzers[analyzerName]
+		if !ok {
+			var err error
+			analyzer, err = namedAnalyzer(analyzerName)
+			if err != nil {
+				return nil, err
+			}
+			analyzers[analyzerName] = analyzer
+		}
+		fullTextType := NewFullTextType(analyzer)
+		fullTextType.SetHighlightable(flags["highlightable"])
+		fieldType = fullTextType
+	case "string":
+		fieldType = NewStringType()
+	case "blob":
+		if flags["indexed"] || flags["sortable"] {
+			mess := fmt.Sprintf("Blob field '%s' can't be indexed or sortable", sf.name)
+			return nil, clownfish.NewErr(mess)
+		}
+		fieldType = NewBlobType(!flags["unstored"])
+	case "int32":
+		fieldType = NewInt32Type()
+	case "int64":
+		fieldType = NewInt64Type()
+	case "float32":
+		fieldType = NewFloat32Type()
+	case "float64":
+		fieldType = NewFloat64Type()
+	}
+	if flags["stored"] || flags["unstored"] {
+		fieldType.SetStored(flags["stored"])
+	}
+	if flags["indexed"] || flags["unindexed"] {
+		fieldType.SetIndexed(flags["indexed"])
+	}
+	if flags["sortable"] {
+		fieldType.SetSortable(true)
+	}
+	if boost != 1.0 {
+		fieldType.SetBoost(float32(boost))
+	}
+	return fieldType, nil
+}
+
+// Resolve an analyzer name used in a `lucy:"...,analyzer=NAME"` tag.
+func namedAnalyzer(name string) (Analyzer, error) {
+	if impl, ok := lookupCustomAnalyzer(name); ok {
+		return WRAPAnalyzer(unsafe.Pointer(newGoAnalyzer(name, impl))), nil
+	}
+	if name == "standard" {
+		return NewStandardTokenizer(), nil
+	}
+	var analyzer Analyzer
+	err := clownfish.TrapErr(func() {
+		analyzer = NewEasyAnalyzer(name)
+	})
+	return analyzer, err
+}
@@ -18,6 +18,7 @@ package lucy
 
 import "testing"
 import "reflect"
+import "sort"
 
 func TestSchemaSpecField(t *testing.T) {
 	schema := NewSchema()
@@ -170,6 +171,71 @@ func runFieldTypeTests(t *testing.T, ft FieldType) {
 	}
 }
 
+type schemaTestDoc struct {
+	Title   string  `lucy:"title,fulltext,analyzer=en,highlightable"`
+	SKU     string  `lucy:"sku,string,stored,sortable"`
+	Price   float64 `lucy:"price,float64,sortable"`
+	Count   int32
+	Views   uint64  `lucy:"views,unindexed"`
+	Data    []byte  `lucy:"data,unstored"`
+	Body    string  `lucy:"body,analyzer=standard,boost=2.5"`
+	Excerpt string  `lucy:"body,excerpt"`
+	Notes   string  `lucy:"-"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema, err := SchemaFor[schemaTestDoc]()
+	if err != nil {
+		t.Fatalf("SchemaFor: %v", err)
+	}
+	expected := []string{"Count", "body", "data", "price", "sku", "title", "views"}
+	got := schema.AllFields()
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("AllFields: %v", got)
+	}
+	title, ok := schema.FetchType("title").(FullTextType)
+	if !ok || !title.Highlightable() {
+		t.Errorf("title: %v", title)
+	}
+	if _, ok := title.GetAnalyzer().(EasyAnalyzer); !ok {
+		t.Errorf("title analyzer: %T", title.GetAnalyzer())
+	}
+	body := schema.FetchType("body").(FullTextType)
+	if _, ok := body.GetAnalyzer().(StandardTokenizer); !ok || body.GetBoost() != 2.5 {
+		t.Errorf("body: %T %f", body.GetAnalyzer(), body.GetBoost())
+	}
+	if sku, ok := schema.FetchType("sku").(StringType); !ok || !sku.Sortable() || !sku.Stored() {
+		t.Errorf("sku: %v", sku)
+	}
+	if price, ok := schema.FetchType("price").(Float64Type); !ok || !price.Sortable() {
+		t.Errorf("price: %v", price)
+	}
+	if _, ok := schema.FetchType("Count").(Int32Type); !ok {
+		t.Errorf("Count: %T", schema.FetchType("Count"))
+	}
+	if data, ok := schema.FetchType("data").(BlobType); !ok || data.Stored() {
+		t.Errorf("data: %v", data)
+	}
+	if views, ok := schema.FetchType("views").(Int64Type); !ok || views.Indexed() {
+		t.Errorf("views: %v", views)
+	}
+
+	fromValue, err := SchemaFromStruct(&schemaTestDoc{})
+	if err != nil || int(fromValue.NumFields()) != len(expected) {
+		t.Errorf("SchemaFromStruct: %v", err)
+	}
+	if _, err := SchemaFromStruct(42); err == nil {
+		t.Error("SchemaFromStruct should fail for a non-struct")
+	}
+	type badDoc struct {
+		Price float64 `lucy:"price,float64,highlightable"`
+	}
+	if _, err := SchemaFor[badDoc](); err == nil {
+		t.Error("highlightable numeric field should fail")
+	}
+}
+
 func TestFieldTypeBasics(t *testing.T) {
 	runFieldTypeTests(t, NewFullTextType(NewStandardTokenizer()))
 	runFieldTypeTests(t, NewStringType())
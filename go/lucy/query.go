@@ -0,0 +1,337 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "Lucy/Search/Query.h"
+#include "Lucy/Search/Compiler.h"
+#include "Lucy/Search/Matcher.h"
+#include "Lucy/Search/Searcher.h"
+#include "Lucy/Index/SegReader.h"
+#include "Clownfish/Class.h"
+#include "Clownfish/String.h"
+
+extern lucy_Compiler*
+GOLUCY_GoQuery_Make_Compiler(lucy_Query *self, lucy_Searcher *searcher,
+							 float boost, bool subordinate);
+extern cfish_String*
+GOLUCY_GoQuery_To_String(lucy_Query *self);
+extern void
+GOLUCY_GoQuery_Destroy(lucy_Query *self);
+
+extern lucy_Matcher*
+GOLUCY_GoCompiler_Make_Matcher(lucy_Compiler *self, lucy_SegReader *reader,
+							   bool need_score);
+extern float
+GOLUCY_GoCompiler_Sum_Of_Squared_Weights(lucy_Compiler *self);
+extern void
+GOLUCY_GoCompiler_Apply_Norm_Factor(lucy_Compiler *self, float factor);
+extern void
+GOLUCY_GoCompiler_Destroy(lucy_Compiler *self);
+
+extern int32_t
+GOLUCY_GoMatcher_Next(lucy_Matcher *self);
+extern int32_t
+GOLUCY_GoMatcher_Advance(lucy_Matcher *self, int32_t target);
+extern int32_t
+GOLUCY_GoMatcher_Get_Doc_ID(lucy_Matcher *self);
+extern float
+GOLUCY_GoMatcher_Score(lucy_Matcher *self);
+extern void
+GOLUCY_GoMatcher_Destroy(lucy_Matcher *self);
+
+// Create a subclass of Query whose methods are implemented by Go
+// CustomQueries.
+static cfish_Class*
+init_go_query_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Search::GoQuery");
+	cfish_Class *klass = cfish_Class_singleton(name, LUCY_QUERY);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoQuery_Make_Compiler,
+						 LUCY_Query_Make_Compiler_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoQuery_To_String,
+						 CFISH_Obj_To_String_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoQuery_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return klass;
+}
+
+// Create a subclass of Compiler whose methods are implemented by Go
+// CustomCompilers.
+static cfish_Class*
+init_go_compiler_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Search::GoCompiler");
+	cfish_Class *klass = cfish_Class_singleton(name, LUCY_COMPILER);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCompiler_Make_Matcher,
+						 LUCY_Compiler_Make_Matcher_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCompiler_Sum_Of_Squared_Weights,
+						 LUCY_Compiler_Sum_Of_Squared_Weights_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCompiler_Apply_Norm_Factor,
+						 LUCY_Compiler_Apply_Norm_Factor_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCompiler_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return klass;
+}
+
+// Create a subclass of Matcher whose methods are implemented by Go
+// CustomMatchers.
+static cfish_Class*
+init_go_matcher_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Search::GoMatcher");
+	cfish_Class *klass = cfish_Class_singleton(name, LUCY_MATCHER);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoMatcher_Next,
+						 LUCY_Matcher_Next_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoMatcher_Advance,
+						 LUCY_Matcher_Advance_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoMatcher_Get_Doc_ID,
+						 LUCY_Matcher_Get_Doc_ID_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoMatcher_Score,
+						 LUCY_Matcher_Score_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoMatcher_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return klass;
+}
+
+static lucy_Query*
+make_go_query(cfish_Class *klass) {
+	return lucy_Query_init((lucy_Query*)CFISH_Class_Make_Obj(klass), 1.0f);
+}
+
+static lucy_Compiler*
+make_go_compiler(cfish_Class *klass, lucy_Query *parent, lucy_Searcher *searcher,
+				 float boost) {
+	lucy_Compiler *self = (lucy_Compiler*)CFISH_Class_Make_Obj(klass);
+	return lucy_Compiler_init(self, parent, searcher, NULL, boost);
+}
+
+static lucy_Matcher*
+make_go_matcher(cfish_Class *klass) {
+	return lucy_Matcher_init((lucy_Matcher*)CFISH_Class_Make_Obj(klass));
+}
+*/
+import "C"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// CustomQuery is implemented by Go types which select docs by rules of their
+// own.  Wrap one with NewCustomQuery to search with it directly or to use it
+// as a clause of an ANDQuery, ORQuery, NOTQuery or RequiredOptionalQuery.
+//
+// Like Lucy's own queries, a CustomQuery is compiled in two stages: once per
+// search into a CustomCompiler, which may gather statistics from the
+// Searcher, and then once per segment into a Matcher.
+type CustomQuery interface {
+	// MakeCompiler prepares the query for a search.  boost is the boost of
+	// the Query returned by NewCustomQuery.
+	MakeCompiler(searcher Searcher, boost float32) (CustomCompiler, error)
+	String() string
+}
+
+// CustomCompiler produces the Matcher for each segment.  MakeMatcher may
+// return a nil Matcher if no docs in the segment can match.  Any Matcher may
+// be returned: one built with NewCustomMatcher, or one made by the Compiler
+// of another Query.  Lucy's own Compilers satisfy CustomCompiler, so a
+// CustomQuery may also return one of them from MakeCompiler.
+type CustomCompiler interface {
+	MakeMatcher(reader SegReader, needScore bool) (Matcher, error)
+}
+
+// WeightedCompiler may be implemented by a CustomCompiler whose scores take
+// part in query normalization.  Compilers which don't implement it
+// contribute 1 to the sum of squared weights and ignore the norm factor.
+type WeightedCompiler interface {
+	SumOfSquaredWeights() float32
+	ApplyNormFactor(factor float32)
+}
+
+// CustomMatcher is implemented by Go types which iterate over the docs
+// matching a CustomQuery within one segment.  Doc IDs are relative to the
+// segment, start at 1 and must ascend; Next and Advance return 0 once the
+// matcher is exhausted.  Score is only called for the current doc, and only
+// if the Compiler was asked for a Matcher which needs scores.
+type CustomMatcher interface {
+	Next() int32
+	Advance(target int32) int32
+	GetDocID() int32
+	Score() float32
+}
+
+var goQueryClass *C.cfish_Class
+var goCompilerClass *C.cfish_Class
+var goMatcherClass *C.cfish_Class
+
+// The Go values backing live GoQuery, GoCompiler and GoMatcher objects,
+// keyed by C pointer.
+var goQueryObjs = newHostObjRegistry()
+var goCompilerObjs = newHostObjRegistry()
+var goMatcherObjs = newHostObjRegistry()
+
+func initGoQueryClasses() {
+	goQueryClass = C.init_go_query_class()
+	goCompilerClass = C.init_go_compiler_class()
+	goMatcherClass = C.init_go_matcher_class()
+	clownfish.RegisterWrapFuncs(map[unsafe.Pointer]clownfish.WrapFunc{
+		unsafe.Pointer(goQueryClass):    WRAPQueryASOBJ,
+		unsafe.Pointer(goCompilerClass): WRAPCompilerASOBJ,
+		unsafe.Pointer(goMatcherClass):  WRAPMatcherASOBJ,
+	})
+}
+
+// NewCustomQuery wraps a CustomQuery as a Query.  Custom queries can't be
+// serialized, so they can't be sent to a RemoteSearcher.
+func NewCustomQuery(impl CustomQuery) Query {
+	objC := C.make_go_query(goQueryClass)
+	goQueryObjs.store(unsafe.Pointer(objC), impl)
+	return WRAPQuery(unsafe.Pointer(objC))
+}
+
+// NewCustomMatcher wraps a CustomMatcher as a Matcher.
+func NewCustomMatcher(impl CustomMatcher) Matcher {
+	if matcher, ok := impl.(Matcher); ok {
+		return matcher
+	}
+	objC := C.make_go_matcher(goMatcherClass)
+	goMatcherObjs.store(unsafe.Pointer(objC), impl)
+	return WRAPMatcher(unsafe.Pointer(objC))
+}
+
+func fetchGoQuery(self *C.lucy_Query) CustomQuery {
+	impl, ok := goQueryObjs.fetch(unsafe.Pointer(self)).(CustomQuery)
+	if !ok {
+		panic(clownfish.NewErr("No CustomQuery registered for GoQuery"))
+	}
+	return impl
+}
+
+func fetchGoCompiler(self *C.lucy_Compiler) CustomCompiler {
+	impl, ok := goCompilerObjs.fetch(unsafe.Pointer(self)).(CustomCompiler)
+	if !ok {
+		panic(clownfish.NewErr("No CustomCompiler registered for GoCompiler"))
+	}
+	return impl
+}
+
+func fetchGoMatcher(self *C.lucy_Matcher) CustomMatcher {
+	impl, ok := goMatcherObjs.fetch(unsafe.Pointer(self)).(CustomMatcher)
+	if !ok {
+		panic(clownfish.NewErr("No CustomMatcher registered for GoMatcher"))
+	}
+	return impl
+}
+
+//export GOLUCY_GoQuery_Make_Compiler
+func GOLUCY_GoQuery_Make_Compiler(self *C.lucy_Query, searcher *C.lucy_Searcher,
+	boost C.float, subordinate C.bool) *C.lucy_Compiler {
+	impl := fetchGoQuery(self)
+	searcherGo := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+		unsafe.Pointer(searcher)))).(Searcher)
+	compiler, err := impl.MakeCompiler(searcherGo, float32(boost))
+	if err != nil {
+		panic(clownfish.NewErr(err.Error()))
+	}
+	if compiler == nil {
+		panic(clownfish.NewErr("CustomQuery returned a nil CustomCompiler"))
+	}
+	var compilerC *C.lucy_Compiler
+	if lucyCompiler, ok := compiler.(Compiler); ok {
+		compilerC = (*C.lucy_Compiler)(C.cfish_incref(clownfish.Unwrap(lucyCompiler, "compiler")))
+	} else {
+		compilerC = C.make_go_compiler(goCompilerClass, self, searcher, boost)
+		goCompilerObjs.store(unsafe.Pointer(compilerC), compiler)
+	}
+	if !subordinate {
+		C.LUCY_Compiler_Normalize(compilerC)
+	}
+	return compilerC
+}
+
+//export GOLUCY_GoQuery_To_String
+func GOLUCY_GoQuery_To_String(self *C.lucy_Query) *C.cfish_String {
+	str := fetchGoQuery(self).String()
+	return (*C.cfish_String)(clownfish.GoToClownfish(str, unsafe.Pointer(C.CFISH_STRING), false))
+}
+
+//export GOLUCY_GoQuery_Destroy
+func GOLUCY_GoQuery_Destroy(self *C.lucy_Query) {
+	goQueryObjs.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), goQueryClass)
+}
+
+//export GOLUCY_GoCompiler_Make_Matcher
+func GOLUCY_GoCompiler_Make_Matcher(self *C.lucy_Compiler, reader *C.lucy_SegReader,
+	needScore C.bool) *C.lucy_Matcher {
+	readerGo := WRAPSegReader(unsafe.Pointer(C.cfish_incref(unsafe.Pointer(reader))))
+	matcher, err := fetchGoCompiler(self).MakeMatcher(readerGo, bool(needScore))
+	if err != nil {
+		panic(clownfish.NewErr(err.Error()))
+	}
+	matcherC := clownfish.UnwrapNullable(matcher)
+	if matcherC == nil {
+		return nil
+	}
+	return (*C.lucy_Matcher)(C.cfish_incref(matcherC))
+}
+
+//export GOLUCY_GoCompiler_Sum_Of_Squared_Weights
+func GOLUCY_GoCompiler_Sum_Of_Squared_Weights(self *C.lucy_Compiler) C.float {
+	if weighted, ok := fetchGoCompiler(self).(WeightedCompiler); ok {
+		return C.float(weighted.SumOfSquaredWeights())
+	}
+	return 1.0
+}
+
+//export GOLUCY_GoCompiler_Apply_Norm_Factor
+func GOLUCY_GoCompiler_Apply_Norm_Factor(self *C.lucy_Compiler, factor C.float) {
+	if weighted, ok := fetchGoCompiler(self).(WeightedCompiler); ok {
+		weighted.ApplyNormFactor(float32(factor))
+	}
+}
+
+//export GOLUCY_GoCompiler_Destroy
+func GOLUCY_GoCompiler_Destroy(self *C.lucy_Compiler) {
+	goCompilerObjs.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), goCompilerClass)
+}
+
+//export GOLUCY_GoMatcher_Next
+func GOLUCY_GoMatcher_Next(self *C.lucy_Matcher) C.int32_t {
+	return C.int32_t(fetchGoMatcher(self).Next())
+}
+
+//export GOLUCY_GoMatcher_Advance
+func GOLUCY_GoMatcher_Advance(self *C.lucy_Matcher, target C.int32_t) C.int32_t {
+	return C.int32_t(fetchGoMatcher(self).Advance(int32(target)))
+}
+
+//export GOLUCY_GoMatcher_Get_Doc_ID
+func GOLUCY_GoMatcher_Get_Doc_ID(self *C.lucy_Matcher) C.int32_t {
+	return C.int32_t(fetchGoMatcher(self).GetDocID())
+}
+
+//export GOLUCY_GoMatcher_Score
+func GOLUCY_GoMatcher_Score(self *C.lucy_Matcher) C.float {
+	return C.float(fetchGoMatcher(self).Score())
+}
+
+//export GOLUCY_GoMatcher_Destroy
+func GOLUCY_GoMatcher_Destroy(self *C.lucy_Matcher) {
+	goMatcherObjs.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), goMatcherClass)
+}
@@ -16,7 +16,11 @@
 
 package lucy
 
+import "fmt"
 import "sync"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
 
 type indexInt uintptr
 
@@ -133,3 +137,80 @@ func (reg *objRegistry) delete(index uintptr) {
 	reg.mutex.Unlock()
 }
 
+// hostObjRegistry associates Go values with the C objects which
+// dispatch to them, for Clownfish classes whose methods are implemented in
+// Go but which have no ivar to hold an objRegistry index.
+type hostObjRegistry struct {
+	objs  map[uintptr]interface{}
+	mutex sync.RWMutex
+}
+
+func newHostObjRegistry() *hostObjRegistry {
+	return &hostObjRegistry{objs: make(map[uintptr]interface{})}
+}
+
+func (reg *hostObjRegistry) store(cObj unsafe.Pointer, obj interface{}) {
+	reg.mutex.Lock()
+	reg.objs[uintptr(cObj)] = obj
+	reg.mutex.Unlock()
+}
+
+func (reg *hostObjRegistry) fetch(cObj unsafe.Pointer) interface{} {
+	reg.mutex.RLock()
+	obj := reg.objs[uintptr(cObj)]
+	reg.mutex.RUnlock()
+	return obj
+}
+
+func (reg *hostObjRegistry) delete(cObj unsafe.Pointer) {
+	reg.mutex.Lock()
+	delete(reg.objs, uintptr(cObj))
+	reg.mutex.Unlock()
+}
+
+// nameRegistry holds Go implementations by the name under which they are
+// serialized, so that a Schema which refers to them can be loaded.
+type nameRegistry struct {
+	kind   string
+	byName map[string]interface{}
+	mutex  sync.RWMutex
+}
+
+func newNameRegistry(kind string) *nameRegistry {
+	return &nameRegistry{kind: kind, byName: make(map[string]interface{})}
+}
+
+// Register an implementation under a name.  A name may be registered again
+// with the same implementation, but not with a different one, since an index
+// serialized with one would silently be loaded with the other.
+// Implementations are compared with ==, so one whose type isn't comparable,
+// such as a func, can be registered only once.
+func (reg *nameRegistry) register(name string, impl interface{}) error {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	if existing, ok := reg.byName[name]; ok && !sameImpl(existing, impl) {
+		mess := fmt.Sprintf("A different %s named '%s' has already been created",
+			reg.kind, name)
+		return clownfish.NewErr(mess)
+	}
+	reg.byName[name] = impl
+	return nil
+}
+
+// Report whether two implementations are identical, treating values which
+// can't be compared as different.
+func sameImpl(a, b interface{}) (same bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+func (reg *nameRegistry) lookup(name string) (interface{}, bool) {
+	reg.mutex.RLock()
+	impl, ok := reg.byName[name]
+	reg.mutex.RUnlock()
+	return impl, ok
+}
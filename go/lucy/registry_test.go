@@ -82,3 +82,29 @@ func TestRegistryRange(t *testing.T) {
 		t.Error("Out of range index should return nil")
 	}
 }
+
+func TestNameRegistry(t *testing.T) {
+	reg := newNameRegistry("Thing")
+	if err := reg.register("foo", 42); err != nil {
+		t.Errorf("register: %v", err)
+	}
+	if err := reg.register("foo", 42); err != nil {
+		t.Errorf("Registering the same value again: %v", err)
+	}
+	if err := reg.register("foo", 43); err == nil {
+		t.Error("Registering a different value under a taken name should fail")
+	}
+	if got, ok := reg.lookup("foo"); !ok || got != 42 {
+		t.Errorf("lookup: %v, %v", got, ok)
+	}
+	if _, ok := reg.lookup("bar"); ok {
+		t.Error("lookup of unregistered name")
+	}
+	impl := func() {}
+	if err := reg.register("func", impl); err != nil {
+		t.Errorf("register func: %v", err)
+	}
+	if err := reg.register("func", impl); err == nil {
+		t.Error("A func can only be registered once")
+	}
+}
@@ -0,0 +1,430 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include <stdlib.h>
+#include <string.h>
+
+#include "Lucy/Search/Searcher.h"
+#include "Lucy/Search/Collector.h"
+#include "Lucy/Search/Query.h"
+#include "Lucy/Search/SortSpec.h"
+#include "Lucy/Search/TopDocs.h"
+#include "Lucy/Document/HitDoc.h"
+#include "Lucy/Index/DocVector.h"
+#include "Lucy/Plan/Schema.h"
+#include "Lucy/Store/InStream.h"
+#include "Lucy/Store/OutStream.h"
+#include "Lucy/Store/RAMFile.h"
+#include "Lucy/Util/Freezer.h"
+#include "Clownfish/ByteBuf.h"
+#include "Clownfish/Class.h"
+#include "Clownfish/Hash.h"
+#include "Clownfish/Num.h"
+#include "Clownfish/String.h"
+
+extern int32_t
+GOLUCY_RemoteSearcher_Doc_Max(lucy_Searcher *self);
+extern uint32_t
+GOLUCY_RemoteSearcher_Doc_Freq(lucy_Searcher *self, cfish_String *field,
+                               cfish_Obj *term);
+extern void
+GOLUCY_RemoteSearcher_Collect(lucy_Searcher *self, lucy_Query *query,
+                              lucy_Collector *collector);
+extern lucy_TopDocs*
+GOLUCY_RemoteSearcher_Top_Docs(lucy_Searcher *self, lucy_Query *query,
+                               uint32_t num_wanted, lucy_SortSpec *sort_spec);
+extern lucy_HitDoc*
+GOLUCY_RemoteSearcher_Fetch_Doc(lucy_Searcher *self, int32_t doc_id);
+extern lucy_DocVector*
+GOLUCY_RemoteSearcher_Fetch_Doc_Vec(lucy_Searcher *self, int32_t doc_id);
+extern void
+GOLUCY_RemoteSearcher_Close(lucy_Searcher *self);
+extern void
+GOLUCY_RemoteSearcher_Destroy(lucy_Searcher *self);
+
+// Create a subclass of Searcher which forwards requests to a SearchServer.
+static cfish_Class*
+init_remote_searcher_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Search::RemoteSearcher");
+	cfish_Class *klass = cfish_Class_singleton(name, LUCY_SEARCHER);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Doc_Max,
+						 LUCY_Searcher_Doc_Max_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Doc_Freq,
+						 LUCY_Searcher_Doc_Freq_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Collect,
+						 LUCY_Searcher_Collect_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Top_Docs,
+						 LUCY_Searcher_Top_Docs_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Fetch_Doc,
+						 LUCY_Searcher_Fetch_Doc_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Fetch_Doc_Vec,
+						 LUCY_Searcher_Fetch_Doc_Vec_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Close,
+						 LUCY_Searcher_Close_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_RemoteSearcher_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return klass;
+}
+
+static lucy_Searcher*
+make_remote_searcher(cfish_Class *klass, lucy_Schema *schema) {
+	return lucy_Searcher_init((lucy_Searcher*)CFISH_Class_Make_Obj(klass),
+							  schema);
+}
+
+// Freeze an object into a new ByteBuf.
+static cfish_ByteBuf*
+freeze_obj(cfish_Obj *obj) {
+	lucy_RAMFile *file = lucy_RAMFile_new(NULL, false);
+	lucy_OutStream *outstream = lucy_OutStream_open((cfish_Obj*)file);
+	lucy_Freezer_freeze(obj, outstream);
+	LUCY_OutStream_Close(outstream);
+	cfish_ByteBuf *contents
+		= (cfish_ByteBuf*)CFISH_INCREF(LUCY_RAMFile_Get_Contents(file));
+	CFISH_DECREF(outstream);
+	CFISH_DECREF(file);
+	return contents;
+}
+
+// Thaw an object frozen by freeze_obj.
+static cfish_Obj*
+thaw_obj(const char *buf, size_t size) {
+	cfish_ByteBuf *contents = cfish_BB_new_bytes(buf, size);
+	lucy_RAMFile *file = lucy_RAMFile_new(contents, true);
+	lucy_InStream *instream = lucy_InStream_open((cfish_Obj*)file);
+	cfish_Obj *retval = lucy_Freezer_thaw(instream);
+	CFISH_DECREF(instream);
+	CFISH_DECREF(file);
+	CFISH_DECREF(contents);
+	return retval;
+}
+
+static cfish_Hash*
+pack_doc_freq_args(cfish_String *field, cfish_Obj *term) {
+	cfish_Hash *args = cfish_Hash_new(2);
+	CFISH_Hash_Store_Utf8(args, "field", 5, CFISH_INCREF(field));
+	CFISH_Hash_Store_Utf8(args, "term", 4, CFISH_INCREF(term));
+	return args;
+}
+
+static cfish_Hash*
+pack_top_docs_args(lucy_Query *query, uint32_t num_wanted,
+				   lucy_SortSpec *sort_spec) {
+	cfish_Hash *args = cfish_Hash_new(3);
+	CFISH_Hash_Store_Utf8(args, "query", 5, CFISH_INCREF(query));
+	CFISH_Hash_Store_Utf8(args, "num_wanted", 10,
+						  (cfish_Obj*)cfish_Int_new(num_wanted));
+	if (sort_spec) {
+		CFISH_Hash_Store_Utf8(args, "sort_spec", 9, CFISH_INCREF(sort_spec));
+	}
+	return args;
+}
+
+static cfish_Obj*
+fetch_arg(cfish_Hash *args, const char *key, cfish_Class *klass) {
+	cfish_Obj *value = CFISH_Hash_Fetch_Utf8(args, key, strlen(key));
+	if (value && !cfish_Obj_is_a(value, klass)) {
+		CFISH_THROW(CFISH_ERR, "Bad type for RPC argument '%s'", key);
+	}
+	return value;
+}
+*/
+import "C"
+import "fmt"
+import "net"
+import "net/rpc"
+import "sync"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// The name under which a SearchServer registers its RPC methods.
+const remoteServiceName = "LucySearcher"
+
+// Serialize an object using Freezer.
+func freezeObj(obj unsafe.Pointer) []byte {
+	contents := C.freeze_obj((*C.cfish_Obj)(obj))
+	defer C.cfish_decref(unsafe.Pointer(contents))
+	buf := unsafe.Pointer(C.CFISH_BB_Get_Buf(contents))
+	return C.GoBytes(buf, C.int(C.CFISH_BB_Get_Size(contents)))
+}
+
+// Deserialize an object frozen by freezeObj, verifying its class.  Returns
+// an incremented object.
+func thawObj(data []byte, class *C.cfish_Class) unsafe.Pointer {
+	if len(data) == 0 {
+		panic(clownfish.NewErr("No data to thaw"))
+	}
+	buf := C.CBytes(data)
+	defer C.free(buf)
+	obj := C.thaw_obj((*C.char)(buf), C.size_t(len(data)))
+	if !C.cfish_Obj_is_a(obj, class) {
+		C.cfish_decref(unsafe.Pointer(obj))
+		panic(clownfish.NewErr("Thawed object has unexpected class"))
+	}
+	return unsafe.Pointer(obj)
+}
+
+// SearchServer makes a Searcher, typically an IndexSearcher, available to
+// clients created by OpenRemoteSearcher, using Go's net/rpc protocol.
+type SearchServer struct {
+	rpcServer *rpc.Server
+}
+
+// The RPC methods of a SearchServer.  Arguments and return values which are
+// Lucy objects travel as Freezer output.  Lucy objects are not safe for
+// concurrent use, so requests are handled one at a time.
+type searchService struct {
+	searcher Searcher
+	mutex    sync.Mutex
+}
+
+// NewSearchServer creates a SearchServer for the supplied Searcher.
+func NewSearchServer(searcher Searcher) *SearchServer {
+	rpcServer := rpc.NewServer()
+	err := rpcServer.RegisterName(remoteServiceName, &searchService{searcher: searcher})
+	if err != nil {
+		panic(err)
+	}
+	return &SearchServer{rpcServer}
+}
+
+// Serve accepts connections on the listener and serves each of them in its
+// own goroutine.  It returns when the listener fails, e.g. when it is closed.
+func (s *SearchServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.rpcServer.ServeConn(conn)
+	}
+}
+
+// Fetch an argument from a thawed Hash, verifying its class if present.
+func fetchArg(args *C.cfish_Hash, key string, class *C.cfish_Class) *C.cfish_Obj {
+	keyC := C.CString(key)
+	defer C.free(unsafe.Pointer(keyC))
+	return C.fetch_arg(args, keyC, class)
+}
+
+func (s *searchService) call(f func(searcher *C.lucy_Searcher)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return clownfish.TrapErr(func() {
+		f((*C.lucy_Searcher)(clownfish.Unwrap(s.searcher, "searcher")))
+	})
+}
+
+func (s *searchService) Schema(_ int, reply *[]byte) error {
+	return s.call(func(searcher *C.lucy_Searcher) {
+		dump := C.LUCY_Schema_Dump(C.LUCY_Searcher_Get_Schema(searcher))
+		defer C.cfish_decref(unsafe.Pointer(dump))
+		*reply = freezeObj(unsafe.Pointer(dump))
+	})
+}
+
+func (s *searchService) DocMax(_ int, reply *int32) error {
+	return s.call(func(searcher *C.lucy_Searcher) {
+		*reply = int32(C.LUCY_Searcher_Doc_Max(searcher))
+	})
+}
+
+func (s *searchService) DocFreq(args []byte, reply *uint32) error {
+	return s.call(func(searcher *C.lucy_Searcher) {
+		argsC := (*C.cfish_Hash)(thawObj(args, C.CFISH_HASH))
+		defer C.cfish_decref(unsafe.Pointer(argsC))
+		field := (*C.cfish_String)(unsafe.Pointer(fetchArg(argsC, "field", C.CFISH_STRING)))
+		term := fetchArg(argsC, "term", C.CFISH_OBJ)
+		if field == nil || term == nil {
+			panic(clownfish.NewErr("Missing DocFreq arguments"))
+		}
+		*reply = uint32(C.LUCY_Searcher_Doc_Freq(searcher, field, term))
+	})
+}
+
+func (s *searchService) TopDocs(args []byte, reply *[]byte) error {
+	return s.call(func(searcher *C.lucy_Searcher) {
+		argsC := (*C.cfish_Hash)(thawObj(args, C.CFISH_HASH))
+		defer C.cfish_decref(unsafe.Pointer(argsC))
+		query := (*C.lucy_Query)(unsafe.Pointer(fetchArg(argsC, "query", C.LUCY_QUERY)))
+		numWanted := (*C.cfish_Integer)(unsafe.Pointer(fetchArg(argsC, "num_wanted", C.CFISH_INTEGER)))
+		sortSpec := (*C.lucy_SortSpec)(unsafe.Pointer(fetchArg(argsC, "sort_spec", C.LUCY_SORTSPEC)))
+		if query == nil || numWanted == nil {
+			panic(clownfish.NewErr("Missing TopDocs arguments"))
+		}
+		topDocs := C.LUCY_Searcher_Top_Docs(searcher, query,
+			C.uint32_t(C.CFISH_Int_Get_Value(numWanted)), sortSpec)
+		defer C.cfish_decref(unsafe.Pointer(topDocs))
+		*reply = freezeObj(unsafe.Pointer(topDocs))
+	})
+}
+
+func (s *searchService) FetchDoc(docID int32, reply *[]byte) error {
+	return s.call(func(searcher *C.lucy_Searcher) {
+		doc := C.LUCY_Searcher_Fetch_Doc(searcher, C.int32_t(docID))
+		defer C.cfish_decref(unsafe.Pointer(doc))
+		*reply = freezeObj(unsafe.Pointer(doc))
+	})
+}
+
+func (s *searchService) FetchDocVec(docID int32, reply *[]byte) error {
+	return s.call(func(searcher *C.lucy_Searcher) {
+		docVec := C.LUCY_Searcher_Fetch_Doc_Vec(searcher, C.int32_t(docID))
+		defer C.cfish_decref(unsafe.Pointer(docVec))
+		*reply = freezeObj(unsafe.Pointer(docVec))
+	})
+}
+
+type remoteSearcher struct {
+	address string
+	client  *rpc.Client
+}
+
+var remoteSearcherClass *C.cfish_Class
+
+// The connections backing live RemoteSearcher objects, keyed by C pointer.
+var remoteSearchers = newHostObjRegistry()
+
+func initRemoteSearcherClass() {
+	remoteSearcherClass = C.init_remote_searcher_class()
+	clownfish.RegisterWrapFuncs(map[unsafe.Pointer]clownfish.WrapFunc{
+		unsafe.Pointer(remoteSearcherClass): WRAPSearcherASOBJ,
+	})
+}
+
+// OpenRemoteSearcher connects to a SearchServer and returns a Searcher which
+// forwards requests to it.  The Schema is fetched from the server.  The
+// Searcher may be combined with others in a PolySearcher.
+//
+// Matches can't be collected over the network, so Collect fails for a
+// RemoteSearcher, and so do Facets, Aggregate, GroupedHits and HitsAfter,
+// which are built on it.  The same goes for a PolySearcher with a
+// RemoteSearcher among its children.  Hits works with both.
+func OpenRemoteSearcher(network, address string) (obj Searcher, err error) {
+	client, err := rpc.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	var schemaData []byte
+	err = client.Call(remoteServiceName+".Schema", 0, &schemaData)
+	if err == nil {
+		err = clownfish.TrapErr(func() {
+			dump := thawObj(schemaData, C.CFISH_HASH)
+			defer C.cfish_decref(dump)
+			schema := C.lucy_Freezer_load((*C.cfish_Obj)(dump))
+			defer C.cfish_decref(unsafe.Pointer(schema))
+			if !C.cfish_Obj_is_a(schema, C.LUCY_SCHEMA) {
+				panic(clownfish.NewErr("Server didn't supply a Schema"))
+			}
+			searcherC := C.make_remote_searcher(remoteSearcherClass,
+				(*C.lucy_Schema)(unsafe.Pointer(schema)))
+			remoteSearchers.store(unsafe.Pointer(searcherC),
+				&remoteSearcher{address: address, client: client})
+			obj = WRAPSearcher(unsafe.Pointer(searcherC))
+		})
+	}
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func fetchRemoteSearcher(self *C.lucy_Searcher) *remoteSearcher {
+	rs, ok := remoteSearchers.fetch(unsafe.Pointer(self)).(*remoteSearcher)
+	if !ok || rs.client == nil {
+		panic(clownfish.NewErr("RemoteSearcher is closed"))
+	}
+	return rs
+}
+
+func (rs *remoteSearcher) call(method string, args interface{}, reply interface{}) {
+	err := rs.client.Call(remoteServiceName+"."+method, args, reply)
+	if err != nil {
+		mess := fmt.Sprintf("%s failed for %s: %v", method, rs.address, err)
+		panic(clownfish.NewErr(mess))
+	}
+}
+
+//export GOLUCY_RemoteSearcher_Doc_Max
+func GOLUCY_RemoteSearcher_Doc_Max(self *C.lucy_Searcher) C.int32_t {
+	var docMax int32
+	fetchRemoteSearcher(self).call("DocMax", 0, &docMax)
+	return C.int32_t(docMax)
+}
+
+//export GOLUCY_RemoteSearcher_Doc_Freq
+func GOLUCY_RemoteSearcher_Doc_Freq(self *C.lucy_Searcher, field *C.cfish_String,
+	term *C.cfish_Obj) C.uint32_t {
+	rs := fetchRemoteSearcher(self)
+	args := C.pack_doc_freq_args(field, term)
+	defer C.cfish_decref(unsafe.Pointer(args))
+	var docFreq uint32
+	rs.call("DocFreq", freezeObj(unsafe.Pointer(args)), &docFreq)
+	return C.uint32_t(docFreq)
+}
+
+//export GOLUCY_RemoteSearcher_Collect
+func GOLUCY_RemoteSearcher_Collect(self *C.lucy_Searcher, query *C.lucy_Query,
+	collector *C.lucy_Collector) {
+	panic(clownfish.NewErr("Collect is not supported by RemoteSearcher"))
+}
+
+//export GOLUCY_RemoteSearcher_Top_Docs
+func GOLUCY_RemoteSearcher_Top_Docs(self *C.lucy_Searcher, query *C.lucy_Query,
+	numWanted C.uint32_t, sortSpec *C.lucy_SortSpec) *C.lucy_TopDocs {
+	rs := fetchRemoteSearcher(self)
+	args := C.pack_top_docs_args(query, numWanted, sortSpec)
+	defer C.cfish_decref(unsafe.Pointer(args))
+	var reply []byte
+	rs.call("TopDocs", freezeObj(unsafe.Pointer(args)), &reply)
+	return (*C.lucy_TopDocs)(thawObj(reply, C.LUCY_TOPDOCS))
+}
+
+//export GOLUCY_RemoteSearcher_Fetch_Doc
+func GOLUCY_RemoteSearcher_Fetch_Doc(self *C.lucy_Searcher, docID C.int32_t) *C.lucy_HitDoc {
+	var reply []byte
+	fetchRemoteSearcher(self).call("FetchDoc", int32(docID), &reply)
+	return (*C.lucy_HitDoc)(thawObj(reply, C.LUCY_HITDOC))
+}
+
+//export GOLUCY_RemoteSearcher_Fetch_Doc_Vec
+func GOLUCY_RemoteSearcher_Fetch_Doc_Vec(self *C.lucy_Searcher, docID C.int32_t) *C.lucy_DocVector {
+	var reply []byte
+	fetchRemoteSearcher(self).call("FetchDocVec", int32(docID), &reply)
+	return (*C.lucy_DocVector)(thawObj(reply, C.LUCY_DOCVECTOR))
+}
+
+//export GOLUCY_RemoteSearcher_Close
+func GOLUCY_RemoteSearcher_Close(self *C.lucy_Searcher) {
+	rs, ok := remoteSearchers.fetch(unsafe.Pointer(self)).(*remoteSearcher)
+	if ok && rs.client != nil {
+		rs.client.Close()
+		rs.client = nil
+	}
+}
+
+//export GOLUCY_RemoteSearcher_Destroy
+func GOLUCY_RemoteSearcher_Destroy(self *C.lucy_Searcher) {
+	GOLUCY_RemoteSearcher_Close(self)
+	remoteSearchers.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), remoteSearcherClass)
+}
@@ -0,0 +1,122 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import "net"
+import "net/rpc"
+import "testing"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+func startTestSearchServer(t *testing.T, values ...string) net.Listener {
+	searcher, _ := OpenIndexSearcher(createTestIndex(values...))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go NewSearchServer(searcher).Serve(listener)
+	return listener
+}
+
+func TestRemoteSearcher(t *testing.T) {
+	listener := startTestSearchServer(t, "a x", "b", "a y")
+	defer listener.Close()
+	searcher, err := OpenRemoteSearcher("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("OpenRemoteSearcher: %v", err)
+	}
+	defer searcher.Close()
+
+	if got := searcher.DocMax(); got != 3 {
+		t.Errorf("DocMax: %d", got)
+	}
+	if got := searcher.DocFreq("content", "a"); got != 2 {
+		t.Errorf("DocFreq: %d", got)
+	}
+	if searcher.GetSchema().FetchType("content") == nil {
+		t.Error("Schema not fetched from server")
+	}
+	hits, err := searcher.Hits("b", 0, 10, nil)
+	if err != nil || hits.TotalHits() != 1 {
+		t.Fatalf("Hits: %v", err)
+	}
+	doc := &simpleTestDoc{}
+	if !hits.Next(doc) || doc.Content != "b" {
+		t.Errorf("Hits.Next: %v, %v", doc, hits.Error())
+	}
+	if hitDoc, err := searcher.FetchDoc(3); err != nil || hitDoc.Extract("content") != "a y" {
+		t.Errorf("FetchDoc: %v", err)
+	}
+	if err := searcher.Collect("a", NewBitCollector(NewBitVector(4))); err == nil {
+		t.Error("Collect should fail for RemoteSearcher")
+	}
+	if _, err := searcher.HitsAfter("a", "", 10, nil); err == nil {
+		t.Error("HitsAfter should fail for RemoteSearcher")
+	}
+}
+
+func TestSearchServerMalformedRequest(t *testing.T) {
+	listener := startTestSearchServer(t, "a")
+	defer listener.Close()
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	args := clownfish.NewHash(1)
+	args.Store("field", "content")
+	var docFreq uint32
+	err = client.Call(remoteServiceName+".DocFreq", freezeObj(clownfish.Unwrap(args, "args")), &docFreq)
+	if err == nil {
+		t.Error("DocFreq without a term should fail")
+	}
+	var docMax int32
+	if err := client.Call(remoteServiceName+".DocMax", 0, &docMax); err != nil || docMax != 1 {
+		t.Errorf("Server unusable after malformed request: %d, %v", docMax, err)
+	}
+}
+
+func TestRemoteSearcherInPolySearcher(t *testing.T) {
+	listener := startTestSearchServer(t, "a x", "b")
+	defer listener.Close()
+	remote, err := OpenRemoteSearcher("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("OpenRemoteSearcher: %v", err)
+	}
+	defer remote.Close()
+	local, _ := OpenIndexSearcher(createTestIndex("a y"))
+	searcher, err := NewPolySearcher(local.GetSchema(), []Searcher{local, remote})
+	if err != nil {
+		t.Fatalf("NewPolySearcher: %v", err)
+	}
+	hits, _ := searcher.Hits("a", 0, 10, nil)
+	if got := hits.TotalHits(); got != 2 {
+		t.Errorf("TotalHits: %d", got)
+	}
+	fields := make(map[string]interface{})
+	count := 0
+	for hits.Next(fields) {
+		count++
+	}
+	if count != 2 || hits.Error() != nil {
+		t.Errorf("Hits.Next across local and remote: %d, %v", count, hits.Error())
+	}
+	if err := searcher.Collect("a", NewBitCollector(NewBitVector(4))); err == nil {
+		t.Error("Collect should fail for PolySearcher with a RemoteSearcher")
+	}
+}
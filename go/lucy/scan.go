@@ -0,0 +1,197 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "Lucy/Search/Searcher.h"
+#include "Lucy/Search/Query.h"
+#include "Lucy/Search/Compiler.h"
+#include "Lucy/Search/Matcher.h"
+#include "Lucy/Index/SegReader.h"
+#include "Lucy/Index/DeletionsReader.h"
+#include "Clownfish/String.h"
+
+#include "live_docs.h"
+
+// Fill `doc_ids` with up to `size` matching docs which have not been
+// deleted.  Returns the number of docs found, which is less than `size`
+// only once the matcher is exhausted.
+static int32_t
+scan_batch(lucy_Matcher *matcher, lucy_Matcher *deletions,
+           int32_t *next_deletion, int32_t *doc_ids, int32_t size) {
+	int32_t count = 0;
+	while (count < size) {
+		int32_t doc_id = next_live_doc(matcher, deletions, next_deletion);
+		if (!doc_id) {
+			break;
+		}
+		doc_ids[count++] = doc_id;
+	}
+	return count;
+}
+*/
+import "C"
+import "fmt"
+import "iter"
+import "math"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// ScanHit is a doc streamed by Searcher.Scan.  Fields holds the requested
+// stored fields which the doc has.
+type ScanHit struct {
+	DocID  int32
+	Fields map[string]interface{}
+}
+
+// ScanHits is the iterator returned by Searcher.Scan.
+type ScanHits = iter.Seq2[ScanHit, error]
+
+// Number of doc IDs fetched from a Matcher at a time during a Scan.
+const scanBatchSize = 1024
+
+// Scan streams every doc matching the query, in index order and without
+// scoring.  Stored fields are read one doc at a time from each segment's
+// DocReader, and only the supplied fields are kept; if fields is nil, all
+// stored fields are kept.  Memory use doesn't depend on the number of
+// matches.  Iteration stops after the first error.
+//
+// Scan is supported for IndexSearchers and for PolySearchers made of them.
+func (s *SearcherIMP) Scan(query interface{}, fields []string) ScanHits {
+	return func(yield func(ScanHit, error) bool) {
+		searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+			clownfish.Unwrap(s, "s")))).(Searcher)
+		_, err := scanSearcher(searcher, query, fields, 0, yield)
+		if err != nil {
+			yield(ScanHit{}, err)
+		}
+	}
+}
+
+// Stream the matches from one Searcher, adding offset to each doc ID.
+// Returns false if the consumer stopped iterating.
+func scanSearcher(searcher Searcher, query interface{}, fields []string,
+	offset int32, yield func(ScanHit, error) bool) (bool, error) {
+	switch s := searcher.(type) {
+	case PolySearcher:
+		for _, sub := range s.GetSearchers() {
+			more, err := scanSearcher(sub, query, fields, offset, yield)
+			if !more || err != nil {
+				return more, err
+			}
+			offset += sub.DocMax()
+		}
+		return true, nil
+	case IndexSearcher:
+		return scanIndexSearcher(s, query, fields, offset, yield)
+	}
+	return false, clownfish.NewErr(fmt.Sprintf("Scan not supported for %T", searcher))
+}
+
+func scanIndexSearcher(searcher IndexSearcher, query interface{}, fields []string,
+	offset int32, yield func(ScanHit, error) bool) (bool, error) {
+	self := (*C.lucy_Searcher)(clownfish.Unwrap(searcher, "searcher"))
+	queryC := (*C.cfish_Obj)(clownfish.GoToClownfish(query, unsafe.Pointer(C.CFISH_OBJ), false))
+	defer C.cfish_decref(unsafe.Pointer(queryC))
+	var compilerC *C.lucy_Compiler
+	err := clownfish.TrapErr(func() {
+		realQueryC := C.LUCY_Searcher_Glean_Query(self, queryC)
+		defer C.cfish_decref(unsafe.Pointer(realQueryC))
+		if C.cfish_Obj_is_a((*C.cfish_Obj)(unsafe.Pointer(realQueryC)), C.LUCY_COMPILER) {
+			compilerC = (*C.lucy_Compiler)(C.cfish_incref(unsafe.Pointer(realQueryC)))
+		} else {
+			compilerC = C.LUCY_Query_Make_Compiler(realQueryC, self,
+				C.LUCY_Query_Get_Boost(realQueryC), false)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	compiler := clownfish.WRAPAny(unsafe.Pointer(compilerC)).(Compiler)
+
+	reader := searcher.GetReader()
+	segStarts := reader.Offsets()
+	docIDs := make([]int32, scanBatchSize)
+	for i, segReader := range reader.SegReaders() {
+		more, err := scanSegment(segReader, compiler, fields, offset+segStarts[i],
+			docIDs, yield)
+		if !more || err != nil {
+			return more, err
+		}
+	}
+	return true, nil
+}
+
+func scanSegment(segReader SegReader, compiler Compiler, fields []string, base int32,
+	docIDs []int32, yield func(ScanHit, error) bool) (bool, error) {
+	matcher, err := compiler.MakeMatcher(segReader, false)
+	if err != nil || matcher == nil {
+		return err == nil, err
+	}
+	docReader, ok := segReader.Fetch("Lucy::Index::DocReader").(DocReader)
+	if !ok {
+		return false, clownfish.NewErr("No DocReader available")
+	}
+	matcherC := (*C.lucy_Matcher)(clownfish.Unwrap(matcher, "matcher"))
+	var deletionsC *C.lucy_Matcher
+	nextDeletion := C.int32_t(math.MaxInt32)
+	if delReader, ok := segReader.Fetch("Lucy::Index::DeletionsReader").(DeletionsReader); ok {
+		delReaderC := (*C.lucy_DeletionsReader)(clownfish.Unwrap(delReader, "delReader"))
+		deletionsC = C.LUCY_DelReader_Iterator(delReaderC)
+		if deletionsC != nil {
+			defer C.cfish_decref(unsafe.Pointer(deletionsC))
+			nextDeletion = 0
+		}
+	}
+
+	for {
+		var count C.int32_t
+		err := clownfish.TrapErr(func() {
+			count = C.scan_batch(matcherC, deletionsC, &nextDeletion,
+				(*C.int32_t)(unsafe.Pointer(&docIDs[0])), C.int32_t(len(docIDs)))
+		})
+		if err != nil {
+			return false, err
+		}
+		for _, docID := range docIDs[:count] {
+			hit := ScanHit{DocID: base + docID, Fields: make(map[string]interface{})}
+			if err := docReader.ReadDoc(docID, hit.Fields); err != nil {
+				return false, err
+			}
+			if fields != nil {
+				hit.Fields = pickFields(hit.Fields, fields)
+			}
+			if !yield(hit, nil) {
+				return false, nil
+			}
+		}
+		if int(count) < len(docIDs) {
+			return true, nil
+		}
+	}
+}
+
+func pickFields(all map[string]interface{}, fields []string) map[string]interface{} {
+	picked := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if val, ok := all[field]; ok {
+			picked[field] = val
+		}
+	}
+	return picked
+}
@@ -19,11 +19,14 @@ package lucy
 /*
 
 #define C_LUCY_HITS
+#define C_LUCY_POLYSEARCHER
+#define C_LUCY_COLLECTOR
 
 #include "Lucy/Search/Collector.h"
 #include "Lucy/Search/Collector/SortCollector.h"
 #include "Lucy/Search/Hits.h"
 #include "Lucy/Search/IndexSearcher.h"
+#include "Lucy/Search/PolySearcher.h"
 #include "Lucy/Search/Query.h"
 #include "Lucy/Search/Compiler.h"
 #include "Lucy/Search/Searcher.h"
@@ -38,27 +41,102 @@ package lucy
 #include "Lucy/Search/SortSpec.h"
 #include "Lucy/Search/TopDocs.h"
 #include "Lucy/Document/HitDoc.h"
+#include "Lucy/Index/DeletionsReader.h"
 #include "Lucy/Index/IndexReader.h"
+#include "Lucy/Index/PolyReader.h"
+#include "Lucy/Index/SegReader.h"
+#include "Lucy/Object/I32Array.h"
 #include "LucyX/Search/MockMatcher.h"
 #include "Clownfish/Blob.h"
 #include "Clownfish/Hash.h"
 #include "Clownfish/HashIterator.h"
+#include "Clownfish/Class.h"
+#include "Clownfish/String.h"
 #include "Clownfish/Vector.h"
 
+#include "live_docs.h"
+
+extern void
+GOLUCY_GoCollector_Collect(lucy_Collector *self, int32_t doc_id);
+extern bool
+GOLUCY_GoCollector_Need_Score(lucy_Collector *self);
+extern void
+GOLUCY_GoCollector_Set_Reader(lucy_Collector *self, lucy_SegReader *reader);
+extern void
+GOLUCY_GoCollector_Set_Base(lucy_Collector *self, int32_t base);
+extern void
+GOLUCY_GoCollector_Set_Matcher(lucy_Collector *self, lucy_Matcher *matcher);
+extern void
+GOLUCY_GoCollector_Destroy(lucy_Collector *self);
+
+// Create a subclass of Collector whose methods are implemented by Go
+// CustomCollectors.
+static cfish_Class*
+init_go_collector_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Search::Collector::GoCollector");
+	cfish_Class *klass = cfish_Class_singleton(name, LUCY_COLLECTOR);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCollector_Collect,
+						 LUCY_Coll_Collect_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCollector_Need_Score,
+						 LUCY_Coll_Need_Score_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCollector_Set_Reader,
+						 LUCY_Coll_Set_Reader_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCollector_Set_Base,
+						 LUCY_Coll_Set_Base_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCollector_Set_Matcher,
+						 LUCY_Coll_Set_Matcher_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoCollector_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return klass;
+}
+
+static lucy_Collector*
+make_go_collector(cfish_Class *klass) {
+	return lucy_Coll_init((lucy_Collector*)CFISH_Class_Make_Obj(klass));
+}
+
 static inline void
 float32_set(float *floats, size_t i, float value) {
 	floats[i] = value;
 }
 
+// Feed up to `batch_size` matching docs which have not been deleted to the
+// collector.  Returns false once the matcher is exhausted, or once the
+// collector sets `*stop` (which may be NULL).
+static bool
+collect_batch(lucy_Matcher *matcher, lucy_Collector *collector,
+              lucy_Matcher *deletions, int32_t *next_deletion,
+              int32_t batch_size, bool *stop) {
+	for (int32_t i = 0; i < batch_size; i++) {
+		int32_t doc_id = next_live_doc(matcher, deletions, next_deletion);
+		if (!doc_id) {
+			return false;
+		}
+		LUCY_Coll_Collect(collector, doc_id);
+		if (stop && *stop) {
+			return false;
+		}
+	}
+	return true;
+}
+
 */
 import "C"
+import "context"
+import "errors"
+import "fmt"
+import "iter"
+import "math"
+import "reflect"
 import "unsafe"
 
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
 
 type HitsIMP struct {
 	clownfish.ObjIMP
-	err error
+	err          error
+	highlighters []Highlighter
 }
 
 type MatcherIMP struct {
@@ -90,11 +168,104 @@ func (s *SearcherIMP) ReadDoc(docID int32, doc interface{}) error {
 		}
 		docReaderGo := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(unsafe.Pointer(docReader)))).(DocReader)
 		return docReaderGo.ReadDoc(docID, doc)
+	} else if C.cfish_Obj_is_a((*C.cfish_Obj)(unsafe.Pointer(self)), C.LUCY_POLYSEARCHER) {
+		return readDocPolySearcher((*C.lucy_PolySearcher)(unsafe.Pointer(self)), docID, doc)
 	} else {
-		return clownfish.NewErr("Support for ReadDoc not implemented")
+		hitDoc, err := s.FetchDoc(docID)
+		if err != nil {
+			return err
+		}
+		return copyHitDoc(hitDoc, doc)
 	}
 }
 
+// Copy the fields of a HitDoc into the supplied doc, for Searchers which
+// can't read directly from a DocReader.
+func copyHitDoc(hitDoc HitDoc, doc interface{}) error {
+	fields := hitDoc.GetFields()
+	switch v := doc.(type) {
+	case Doc:
+		copied := make(map[string]interface{}, len(fields))
+		for field, val := range fields {
+			copied[field] = val
+		}
+		v.SetFields(copied)
+		v.SetDocID(hitDoc.GetDocID())
+		return nil
+	case map[string]interface{}:
+		for field, _ := range v {
+			delete(v, field)
+		}
+		for field, val := range fields {
+			v[field] = val
+		}
+		return nil
+	}
+	docValue := reflect.ValueOf(doc)
+	if docValue.Kind() != reflect.Ptr || docValue.Elem().Kind() != reflect.Struct {
+		mess := fmt.Sprintf("Arg not writeable struct pointer: %v",
+			reflect.TypeOf(doc))
+		return clownfish.NewErr(mess)
+	}
+	for field, val := range fields {
+		err := setStructField(docValue.Elem(), field, val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read a doc from the sub-searcher which holds it, mapping the doc ID
+// through the sub-searcher's start offset.
+func readDocPolySearcher(ps *C.lucy_PolySearcher, docID int32, doc interface{}) error {
+	docMax := int32(C.LUCY_PolySearcher_Doc_Max(ps))
+	if docID <= 0 || docID > docMax {
+		return clownfish.NewErr(fmt.Sprintf("Invalid docID: %d", docID))
+	}
+	ivars := C.lucy_PolySearcher_IVARS(ps)
+	tick := C.lucy_PolyReader_sub_tick(ivars.starts, C.int32_t(docID))
+	subSearcherC := C.CFISH_Vec_Fetch(ivars.searchers, C.size_t(tick))
+	offset := C.LUCY_I32Arr_Get(ivars.starts, C.size_t(tick))
+	subSearcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+		unsafe.Pointer(subSearcherC)))).(Searcher)
+	err := subSearcher.ReadDoc(docID-int32(offset), doc)
+	if docDoc, ok := doc.(Doc); ok {
+		docDoc.SetDocID(docID)
+	}
+	return err
+}
+
+// NewPolySearcher creates a Searcher which aggregates results from several
+// Searchers, such as one IndexSearcher per shard.  Doc IDs are mapped onto a
+// single range, so Hits and ReadDoc work as they do with an IndexSearcher.
+// All of the searchers must share the supplied Schema's class.
+func NewPolySearcher(schema Schema, searchers []Searcher) (obj PolySearcher, err error) {
+	schemaC := (*C.lucy_Schema)(clownfish.Unwrap(schema, "schema"))
+	vec := clownfish.NewVector(len(searchers))
+	for _, searcher := range searchers {
+		vec.Push(searcher)
+	}
+	vecC := (*C.cfish_Vector)(clownfish.Unwrap(vec, "vec"))
+	err = clownfish.TrapErr(func() {
+		cfObj := C.lucy_PolySearcher_new(schemaC, vecC)
+		obj = WRAPPolySearcher(unsafe.Pointer(cfObj))
+	})
+	return obj, err
+}
+
+func (p *PolySearcherIMP) GetSearchers() []Searcher {
+	self := (*C.lucy_PolySearcher)(clownfish.Unwrap(p, "p"))
+	searchersC := C.LUCY_PolySearcher_Get_Searchers(self)
+	size := int(C.CFISH_Vec_Get_Size(searchersC))
+	retval := make([]Searcher, size)
+	for i := 0; i < size; i++ {
+		child := unsafe.Pointer(C.CFISH_Vec_Fetch(searchersC, C.size_t(i)))
+		retval[i] = clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(child))).(Searcher)
+	}
+	return retval
+}
+
 func (s *SearcherIMP) FetchDoc(docID int32) (doc HitDoc, err error) {
 	err = clownfish.TrapErr(func() {
 		self := (*C.lucy_Searcher)(clownfish.Unwrap(s, "s"))
@@ -134,6 +305,88 @@ func (s *SearcherIMP) Hits(query interface{}, offset uint32, numWanted uint32,
 	return hits, err
 }
 
+// Collect feeds every doc matching the query to the collector.  The query
+// may be a Query or a string to be parsed, and the collector may be either a
+// Collector or a CustomCollector.  If a CustomCollector returns
+// ErrStopCollecting, collection ends early and Collect returns nil.
+func (s *SearcherIMP) Collect(query interface{}, collector interface{}) error {
+	self := (*C.lucy_Searcher)(clownfish.Unwrap(s, "s"))
+	if err := checkCollectable(self); err != nil {
+		return err
+	}
+	queryC := (*C.cfish_Obj)(clownfish.GoToClownfish(query, unsafe.Pointer(C.CFISH_OBJ), false))
+	defer C.cfish_decref(unsafe.Pointer(queryC))
+
+	var collectorC *C.lucy_Collector
+	var entry *goCollectorEntry
+	switch c := collector.(type) {
+	case Collector:
+		collectorC = (*C.lucy_Collector)(clownfish.Unwrap(c, "collector"))
+	case CustomCollector:
+		entry = &goCollectorEntry{impl: c}
+		collectorC = newGoCollector(entry)
+		defer C.cfish_decref(unsafe.Pointer(collectorC))
+	default:
+		mess := fmt.Sprintf("Not a Collector or CustomCollector: %T", collector)
+		return clownfish.NewErr(mess)
+	}
+
+	var realQuery Query
+	err := clownfish.TrapErr(func() {
+		realQueryC := C.LUCY_Searcher_Glean_Query(self, queryC)
+		realQuery = clownfish.WRAPAny(unsafe.Pointer(realQueryC)).(Query)
+	})
+	if err != nil {
+		return err
+	}
+	selfObj := (*C.cfish_Obj)(unsafe.Pointer(self))
+	if entry != nil && C.cfish_Obj_is_a(selfObj, C.LUCY_INDEXSEARCHER) {
+		// Drive the segment loop from Go, so that a CustomCollector can stop
+		// it without unwinding through C and leaking the matchers.
+		searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+			unsafe.Pointer(self)))).(IndexSearcher)
+		entry.driven = true
+		err = collectSegments(context.Background(), searcher, realQuery,
+			collectorC, &entry.stop)
+	} else {
+		err = clownfish.TrapErr(func() {
+			C.LUCY_Searcher_Collect(self,
+				(*C.lucy_Query)(clownfish.Unwrap(realQuery, "realQuery")), collectorC)
+		})
+		if entry != nil && entry.err != nil {
+			// Collection was abandoned mid-segment.
+			C.LUCY_Coll_Set_Matcher(collectorC, nil)
+		}
+	}
+	if entry != nil && entry.err != nil {
+		if entry.err == ErrStopCollecting {
+			return nil
+		}
+		return entry.err
+	}
+	return err
+}
+
+// Fail if the searcher is a RemoteSearcher or a PolySearcher with one among
+// its children, since matches can't be collected over the network.
+func checkCollectable(searcher *C.lucy_Searcher) error {
+	obj := (*C.cfish_Obj)(unsafe.Pointer(searcher))
+	if C.cfish_Obj_get_class(obj) == remoteSearcherClass {
+		return clownfish.NewErr("Collect is not supported by RemoteSearcher")
+	}
+	if C.cfish_Obj_is_a(obj, C.LUCY_POLYSEARCHER) {
+		ivars := C.lucy_PolySearcher_IVARS((*C.lucy_PolySearcher)(unsafe.Pointer(searcher)))
+		for i := 0; i < int(C.CFISH_Vec_Get_Size(ivars.searchers)); i++ {
+			child := (*C.lucy_Searcher)(unsafe.Pointer(
+				C.CFISH_Vec_Fetch(ivars.searchers, C.size_t(i))))
+			if err := checkCollectable(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (s *SearcherIMP) topDocs(query Query, numWanted uint32,
 	sortSpec SortSpec) (topDocs TopDocs, err error) {
 	err = clownfish.TrapErr(func() {
@@ -147,6 +400,153 @@ func (s *SearcherIMP) topDocs(query Query, numWanted uint32,
 	return topDocs, err
 }
 
+// Number of docs collected between checks for cancellation.
+const collectBatchSize = 1024
+
+// HitsContext is like Searcher.Hits, but gives up and returns ctx.Err() once
+// the context is done.  For IndexSearchers, cancellation is checked between
+// segments and between batches of matching documents; other Searchers only
+// check before the search starts.
+func HitsContext(ctx context.Context, searcher Searcher, query interface{},
+	offset uint32, numWanted uint32, sortSpec SortSpec) (hits Hits, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := searcher.(IndexSearcher); !ok {
+		return searcher.Hits(query, offset, numWanted, sortSpec)
+	}
+	self := (*C.lucy_Searcher)(clownfish.Unwrap(searcher, "searcher"))
+	queryC := (*C.cfish_Obj)(clownfish.GoToClownfish(query, unsafe.Pointer(C.CFISH_OBJ), false))
+	defer C.cfish_decref(unsafe.Pointer(queryC))
+	var realQuery Query
+	err = clownfish.TrapErr(func() {
+		realQueryC := C.LUCY_Searcher_Glean_Query(self, queryC)
+		realQuery = clownfish.WRAPAny(unsafe.Pointer(realQueryC)).(Query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	wanted := offset + numWanted
+	if docMax := uint32(searcher.DocMax()); wanted > docMax {
+		wanted = docMax
+	}
+	topDocs, err := topDocsContext(ctx, searcher.(IndexSearcher), realQuery, wanted, sortSpec)
+	if err != nil {
+		return nil, err
+	}
+	topDocsC := (*C.lucy_TopDocs)(clownfish.Unwrap(topDocs, "topDocs"))
+	hitsC := C.lucy_Hits_new(self, topDocsC, C.uint32_t(offset))
+	return WRAPHits(unsafe.Pointer(hitsC)), nil
+}
+
+func topDocsContext(ctx context.Context, searcher IndexSearcher, query Query,
+	numWanted uint32, sortSpec SortSpec) (topDocs TopDocs, err error) {
+	self := (*C.lucy_Searcher)(clownfish.Unwrap(searcher, "searcher"))
+	sortSpecC := (*C.lucy_SortSpec)(clownfish.UnwrapNullable(sortSpec))
+	if docMax := uint32(searcher.DocMax()); numWanted > docMax {
+		numWanted = docMax
+	}
+	var collector SortCollector
+	err = clownfish.TrapErr(func() {
+		schemaC := C.LUCY_Searcher_Get_Schema(self)
+		collectorC := C.lucy_SortColl_new(schemaC, sortSpecC, C.uint32_t(numWanted))
+		collector = WRAPSortCollector(unsafe.Pointer(collectorC))
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = collectContext(ctx, searcher, query, collector)
+	if err != nil {
+		return nil, err
+	}
+	collectorC := (*C.lucy_SortCollector)(clownfish.Unwrap(collector, "collector"))
+	matchDocsC := C.LUCY_SortColl_Pop_Match_Docs(collectorC)
+	defer C.cfish_decref(unsafe.Pointer(matchDocsC))
+	totalHits := C.LUCY_SortColl_Get_Total_Hits(collectorC)
+	topDocsC := C.lucy_TopDocs_new(matchDocsC, totalHits)
+	return WRAPTopDocs(unsafe.Pointer(topDocsC)), nil
+}
+
+// Feed the docs matching the query to the collector, one segment at a time,
+// bailing out with ctx.Err() if the context is done.
+func collectContext(ctx context.Context, searcher IndexSearcher, query Query,
+	collector Collector) error {
+	collectorC := (*C.lucy_Collector)(clownfish.Unwrap(collector, "collector"))
+	return collectSegments(ctx, searcher, query, collectorC, nil)
+}
+
+// Run the segment loop for collectContext.  If `stop` is non-nil, collection
+// ends without error as soon as the collector sets it.
+func collectSegments(ctx context.Context, searcher IndexSearcher, query Query,
+	collectorC *C.lucy_Collector, stop *C.bool) error {
+	self := (*C.lucy_Searcher)(clownfish.Unwrap(searcher, "searcher"))
+	queryC := (*C.lucy_Query)(clownfish.Unwrap(query, "query"))
+	stopped := func() bool { return stop != nil && bool(*stop) }
+	reader := searcher.GetReader()
+	segReaders := reader.SegReaders()
+	segStarts := reader.Offsets()
+	needScore := C.LUCY_Coll_Need_Score(collectorC)
+	delReaderAPI := (*C.cfish_String)(clownfish.GoToClownfish("Lucy::Index::DeletionsReader",
+		unsafe.Pointer(C.CFISH_STRING), false))
+	defer C.cfish_decref(unsafe.Pointer(delReaderAPI))
+
+	var compilerC *C.lucy_Compiler
+	err := clownfish.TrapErr(func() {
+		if C.cfish_Obj_is_a((*C.cfish_Obj)(unsafe.Pointer(queryC)), C.LUCY_COMPILER) {
+			compilerC = (*C.lucy_Compiler)(C.cfish_incref(unsafe.Pointer(queryC)))
+		} else {
+			compilerC = C.LUCY_Query_Make_Compiler(queryC, self,
+				C.LUCY_Query_Get_Boost(queryC), false)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer C.cfish_decref(unsafe.Pointer(compilerC))
+
+	for i, segReader := range segReaders {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		segReaderC := (*C.lucy_SegReader)(clownfish.Unwrap(segReader, "segReader"))
+		err := clownfish.TrapErr(func() {
+			matcherC := C.LUCY_Compiler_Make_Matcher(compilerC, segReaderC, needScore)
+			if matcherC == nil {
+				return
+			}
+			defer C.cfish_decref(unsafe.Pointer(matcherC))
+			delReader := (*C.lucy_DeletionsReader)(unsafe.Pointer(
+				C.LUCY_SegReader_Fetch(segReaderC, delReaderAPI)))
+			var deletionsC *C.lucy_Matcher
+			nextDeletion := C.int32_t(math.MaxInt32)
+			if delReader != nil {
+				deletionsC = C.LUCY_DelReader_Iterator(delReader)
+				defer C.cfish_decref(unsafe.Pointer(deletionsC))
+				if deletionsC != nil {
+					nextDeletion = 0
+				}
+			}
+			C.LUCY_Coll_Set_Reader(collectorC, segReaderC)
+			C.LUCY_Coll_Set_Base(collectorC, C.int32_t(segStarts[i]))
+			C.LUCY_Coll_Set_Matcher(collectorC, matcherC)
+			defer C.LUCY_Coll_Set_Matcher(collectorC, nil)
+			for C.collect_batch(matcherC, collectorC, deletionsC, &nextDeletion,
+				collectBatchSize, stop) {
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if stopped() {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
 func NewQueryParser(schema Schema, fields []string) QueryParser {
 	return NewORParser(schema, fields)
 }
@@ -165,7 +565,7 @@ func doNewQueryParser(schema Schema, defaultBoolop string, fields []string) Quer
 	defer C.cfish_decref(unsafe.Pointer(defaultBoolopCF))
 	fieldsCF := stringSliceToVec(fields)
 	defer C.cfish_decref(unsafe.Pointer(fieldsCF))
-	retvalCF := C.lucy_QParser_new(schemaCF, nil, defaultBoolopCF, fieldsCF)
+	retvalCF := makeGoQueryParser(schemaCF, defaultBoolopCF, fieldsCF)
 	return clownfish.WRAPAny(unsafe.Pointer(retvalCF)).(QueryParser)
 }
 
@@ -191,14 +591,27 @@ type setScorer interface {
 	SetScore(float32)
 }
 
-func (h *HitsIMP) Next(hit interface{}) bool {
+// Advance to the next captured hit, returning its doc ID and score.
+func (h *HitsIMP) nextMatch() (docID int32, score float32, ok bool) {
 	self := (*C.lucy_Hits)(clownfish.Unwrap(h, "h"))
 	ivars := C.lucy_Hits_IVARS(self)
 	matchDoc := (*C.lucy_MatchDoc)(unsafe.Pointer(
 		C.CFISH_Vec_Fetch(ivars.match_docs, C.size_t(ivars.offset))))
 	ivars.offset += 1
-
 	if matchDoc == nil {
+		return 0, 0.0, false
+	}
+	docID = int32(C.LUCY_MatchDoc_Get_Doc_ID(matchDoc))
+	score = float32(C.LUCY_MatchDoc_Get_Score(matchDoc))
+	return docID, score, true
+}
+
+func (h *HitsIMP) Next(hit interface{}) bool {
+	self := (*C.lucy_Hits)(clownfish.Unwrap(h, "h"))
+	ivars := C.lucy_Hits_IVARS(self)
+	docID, score, ok := h.nextMatch()
+
+	if !ok {
 		// Bail if there aren't any more *captured* hits.  (There may be
 		// more total hits.)
 		return false
@@ -206,23 +619,145 @@ func (h *HitsIMP) Next(hit interface{}) bool {
 		// Lazily fetch HitDoc, set score.
 		searcher := clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
 			unsafe.Pointer(ivars.searcher)))).(Searcher)
-		docID := int32(C.LUCY_MatchDoc_Get_Doc_ID(matchDoc))
 		err := searcher.ReadDoc(docID, hit)
 		if err != nil {
 			h.err = err
 			return false
 		}
 		if ss, ok := hit.(setScorer); ok {
-			ss.SetScore(float32(C.LUCY_MatchDoc_Get_Score(matchDoc)))
+			ss.SetScore(score)
+		}
+		err = fillExcerpts(searcher, docID, hit, h.highlighters)
+		if err != nil {
+			h.err = err
+			return false
 		}
 		return true
 	}
 }
 
+// Add a Highlighter which Next will use to fill struct fields tagged
+// `lucy:"field,excerpt"`, where "field" is the Highlighter's field.
+func (h *HitsIMP) AddHighlighter(highlighter Highlighter) {
+	h.highlighters = append(h.highlighters, highlighter)
+}
+
 func (obj *HitsIMP) Error() error {
 	return obj.err
 }
 
+// SearchOptions controls which hits are returned by Search.  A nil
+// *SearchOptions asks for the top 10 hits sorted by score.  Highlighters are
+// used to fill struct fields tagged `lucy:"field,excerpt"`.
+type SearchOptions struct {
+	Offset       uint32
+	NumWanted    uint32
+	SortSpec     SortSpec
+	Highlighters []Highlighter
+}
+
+// Hit is a single search result decoded into a T.
+type Hit[T any] struct {
+	Doc   T
+	DocID int32
+	Score float32
+}
+
+type matchRef struct {
+	docID int32
+	score float32
+}
+
+// Results holds the captured hits of a Search.  Documents are read lazily
+// as the hits are iterated.
+type Results[T any] struct {
+	searcher     Searcher
+	matches      []matchRef
+	totalHits    uint32
+	highlighters []Highlighter
+}
+
+// Search runs a query and returns its hits typed as T.  T may be a struct, a
+// pointer to a struct or a map[string]interface{}.  The query may be either a
+// Query or a query string.
+func Search[T any](searcher Searcher, query interface{}, opts *SearchOptions) (Results[T], error) {
+	if opts == nil {
+		opts = &SearchOptions{NumWanted: 10}
+	}
+	hits, err := searcher.Hits(query, opts.Offset, opts.NumWanted, opts.SortSpec)
+	if err != nil {
+		return Results[T]{}, err
+	}
+	results := Results[T]{
+		searcher:     searcher,
+		totalHits:    uint32(hits.TotalHits()),
+		highlighters: opts.Highlighters,
+	}
+	for {
+		docID, score, ok := hits.nextMatch()
+		if !ok {
+			break
+		}
+		results.matches = append(results.matches, matchRef{docID, score})
+	}
+	return results, nil
+}
+
+// TotalHits returns the number of documents which matched the query, which
+// may be more than the number of captured hits.
+func (r Results[T]) TotalHits() uint32 {
+	return r.totalHits
+}
+
+// Len returns the number of captured hits.
+func (r Results[T]) Len() int {
+	return len(r.matches)
+}
+
+// All iterates over the captured hits.  Iteration stops after the first
+// error.
+func (r Results[T]) All() iter.Seq2[Hit[T], error] {
+	return func(yield func(Hit[T], error) bool) {
+		for _, match := range r.matches {
+			hit := Hit[T]{DocID: match.docID, Score: match.score}
+			err := readTypedDoc(r.searcher, match.docID, &hit.Doc)
+			if err == nil {
+				err = fillExcerpts(r.searcher, match.docID, typedDocPtr(&hit.Doc),
+					r.highlighters)
+			}
+			if err != nil {
+				yield(hit, err)
+				return
+			}
+			if !yield(hit, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Return a pointer to the struct held by a typed doc, which may itself be a
+// pointer.
+func typedDocPtr[T any](doc *T) interface{} {
+	if docValue := reflect.ValueOf(doc).Elem(); docValue.Kind() == reflect.Ptr {
+		return docValue.Interface()
+	}
+	return doc
+}
+
+func readTypedDoc[T any](searcher Searcher, docID int32, doc *T) error {
+	if m, ok := any(doc).(*map[string]interface{}); ok {
+		*m = make(map[string]interface{})
+		return searcher.ReadDoc(docID, *m)
+	}
+	if docType := reflect.TypeOf(doc).Elem(); docType.Kind() == reflect.Ptr {
+		elem := reflect.New(docType.Elem())
+		*doc = elem.Interface().(T)
+		return searcher.ReadDoc(docID, elem.Interface())
+	}
+	return searcher.ReadDoc(docID, doc)
+}
+
 func NewFieldSortRule(field string, reverse bool) SortRule {
 	fieldC := clownfish.GoToClownfish(field, unsafe.Pointer(C.CFISH_STRING), false)
 	cfObj := C.lucy_SortRule_new(C.lucy_SortRule_FIELD, (*C.cfish_String)(fieldC), C.bool(reverse))
@@ -415,6 +950,127 @@ func newMockMatcher(docIDs []int32, scores []float32) MockMatcher {
 	return WRAPMockMatcher(unsafe.Pointer(matcher))
 }
 
+// ErrStopCollecting may be returned by CustomCollector.Collect to end
+// collection early without reporting an error.
+var ErrStopCollecting = errors.New("stop collecting")
+
+// CustomCollector is implemented by Go types which process the docs matching
+// a query.  Pass one to Searcher.Collect.
+//
+// Collectors see one segment at a time.  Before each segment, SetReader and
+// SetBase are called; the doc IDs passed to Collect are relative to the
+// segment, and adding the base yields the doc ID within the index.
+// Returning an error from Collect stops collection.
+type CustomCollector interface {
+	Collect(docID int32) error
+	SetReader(reader SegReader)
+	SetBase(base int32)
+	// NeedScore reports whether scores should be calculated.  Collectors
+	// which need scores should also implement MatcherSetter.
+	NeedScore() bool
+}
+
+// MatcherSetter may be implemented by a CustomCollector to receive the
+// Matcher for each segment, whose Score method supplies the score of the doc
+// being collected.
+type MatcherSetter interface {
+	SetMatcher(matcher Matcher)
+}
+
+type goCollectorEntry struct {
+	impl CustomCollector
+	err  error
+	// When the segment loop is driven from Go, Collect errors set `stop`
+	// rather than panicking.
+	driven bool
+	stop   C.bool
+}
+
+var goCollectorClass *C.cfish_Class
+
+// The CustomCollectors backing live GoCollector objects, keyed by C pointer.
+var goCollectorObjs = newHostObjRegistry()
+
+func initGoCollectorClass() {
+	goCollectorClass = C.init_go_collector_class()
+	clownfish.RegisterWrapFuncs(map[unsafe.Pointer]clownfish.WrapFunc{
+		unsafe.Pointer(goCollectorClass): WRAPCollectorASOBJ,
+	})
+}
+
+func newGoCollector(entry *goCollectorEntry) *C.lucy_Collector {
+	objC := C.make_go_collector(goCollectorClass)
+	goCollectorObjs.store(unsafe.Pointer(objC), entry)
+	return objC
+}
+
+func fetchGoCollector(self *C.lucy_Collector) *goCollectorEntry {
+	entry, ok := goCollectorObjs.fetch(unsafe.Pointer(self)).(*goCollectorEntry)
+	if !ok {
+		panic(clownfish.NewErr("No CustomCollector registered for GoCollector"))
+	}
+	return entry
+}
+
+//export GOLUCY_GoCollector_Collect
+func GOLUCY_GoCollector_Collect(self *C.lucy_Collector, docID C.int32_t) {
+	entry := fetchGoCollector(self)
+	if err := entry.impl.Collect(int32(docID)); err != nil {
+		// Searcher.Collect reports entry.err.  Unless collect_batch is
+		// watching the stop flag, unwind through the C matcher loop.
+		entry.err = err
+		if entry.driven {
+			entry.stop = true
+			return
+		}
+		panic(clownfish.NewErr(err.Error()))
+	}
+}
+
+//export GOLUCY_GoCollector_Need_Score
+func GOLUCY_GoCollector_Need_Score(self *C.lucy_Collector) C.bool {
+	return C.bool(fetchGoCollector(self).impl.NeedScore())
+}
+
+//export GOLUCY_GoCollector_Set_Reader
+func GOLUCY_GoCollector_Set_Reader(self *C.lucy_Collector, reader *C.lucy_SegReader) {
+	ivars := C.lucy_Coll_IVARS(self)
+	temp := ivars.reader
+	ivars.reader = (*C.lucy_SegReader)(C.cfish_incref(unsafe.Pointer(reader)))
+	C.cfish_decref(unsafe.Pointer(temp))
+	entry := fetchGoCollector(self)
+	readerGo := WRAPSegReader(unsafe.Pointer(C.cfish_incref(unsafe.Pointer(reader))))
+	entry.impl.SetReader(readerGo)
+}
+
+//export GOLUCY_GoCollector_Set_Base
+func GOLUCY_GoCollector_Set_Base(self *C.lucy_Collector, base C.int32_t) {
+	C.lucy_Coll_IVARS(self).base = base
+	fetchGoCollector(self).impl.SetBase(int32(base))
+}
+
+//export GOLUCY_GoCollector_Set_Matcher
+func GOLUCY_GoCollector_Set_Matcher(self *C.lucy_Collector, matcher *C.lucy_Matcher) {
+	ivars := C.lucy_Coll_IVARS(self)
+	temp := ivars.matcher
+	ivars.matcher = (*C.lucy_Matcher)(C.cfish_incref(unsafe.Pointer(matcher)))
+	C.cfish_decref(unsafe.Pointer(temp))
+	if ms, ok := fetchGoCollector(self).impl.(MatcherSetter); ok {
+		var matcherGo Matcher
+		if matcher != nil {
+			matcherGo = clownfish.WRAPAny(unsafe.Pointer(C.cfish_incref(
+				unsafe.Pointer(matcher)))).(Matcher)
+		}
+		ms.SetMatcher(matcherGo)
+	}
+}
+
+//export GOLUCY_GoCollector_Destroy
+func GOLUCY_GoCollector_Destroy(self *C.lucy_Collector) {
+	goCollectorObjs.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), goCollectorClass)
+}
+
 func (sc *SortCollectorIMP) PopMatchDocs() []MatchDoc {
 	self := (*C.lucy_SortCollector)(clownfish.Unwrap(sc, "sc"))
 	matchDocsC := C.LUCY_SortColl_Pop_Match_Docs(self)
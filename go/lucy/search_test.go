@@ -16,9 +16,14 @@
 
 package lucy
 
+import "context"
+import "encoding/json"
+import "fmt"
+import "math"
 import "testing"
 import "strings"
 import "reflect"
+import "sort"
 import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
 
 func checkQuerySerialize(t *testing.T, query Query) {
@@ -469,6 +474,77 @@ func TestHitsNext(t *testing.T) {
 	}
 }
 
+type excerptTestDoc struct {
+	Content string
+	Excerpt string `lucy:"content,excerpt"`
+}
+
+func TestHitsExcerpts(t *testing.T) {
+	index := createTestIndex("a x", "b")
+	searcher, _ := OpenIndexSearcher(index)
+	hits, _ := searcher.Hits("x", 0, 10, nil)
+	hits.AddHighlighter(NewHighlighter(searcher, "x", "content", 200))
+	doc := &excerptTestDoc{}
+	if !hits.Next(doc) {
+		t.Fatalf("Hits.Next: %v", hits.Error())
+	}
+	if doc.Content != "a x" || !strings.Contains(doc.Excerpt, "<strong>x</strong>") {
+		t.Errorf("Next with excerpt field: %v", doc)
+	}
+
+	opts := &SearchOptions{
+		NumWanted:    10,
+		Highlighters: []Highlighter{NewHighlighter(searcher, "x", "content", 200)},
+	}
+	results, _ := Search[excerptTestDoc](searcher, "x", opts)
+	for hit, err := range results.All() {
+		if err != nil || !strings.Contains(hit.Doc.Excerpt, "<strong>x</strong>") {
+			t.Errorf("Search with excerpt field: %v, %v", hit.Doc, err)
+		}
+	}
+}
+
+func TestSearchTyped(t *testing.T) {
+	index := createTestIndex("a x", "a y", "b")
+	searcher, _ := OpenIndexSearcher(index)
+	results, err := Search[simpleTestDoc](searcher, "a", nil)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := results.TotalHits(); got != 2 {
+		t.Errorf("TotalHits: %d", got)
+	}
+	var contents []string
+	for hit, err := range results.All() {
+		if err != nil {
+			t.Fatalf("All: %v", err)
+		}
+		if hit.DocID < 1 || hit.Score <= 0.0 {
+			t.Errorf("Bad hit metadata: %d %f", hit.DocID, hit.Score)
+		}
+		contents = append(contents, hit.Doc.Content)
+	}
+	if len(contents) != 2 || contents[0][0] != 'a' || contents[1][0] != 'a' {
+		t.Errorf("Unexpected contents: %v", contents)
+	}
+
+	maps, _ := Search[map[string]interface{}](searcher, "b", nil)
+	for hit, err := range maps.All() {
+		if err != nil || hit.Doc["content"] != "b" {
+			t.Errorf("Search with map: %v, %v", hit.Doc, err)
+		}
+	}
+	ptrs, _ := Search[*simpleTestDoc](searcher, "b", &SearchOptions{NumWanted: 1})
+	for hit, err := range ptrs.All() {
+		if err != nil || hit.Doc.Content != "b" {
+			t.Errorf("Search with pointer: %v, %v", hit.Doc, err)
+		}
+	}
+	if _, err := Search[simpleTestDoc](searcher, 42, nil); err == nil {
+		t.Error("Garbage 'query' argument")
+	}
+}
+
 func TestSortSpecBasics(t *testing.T) {
 	folder := NewRAMFolder("")
 	schema := NewSchema()
@@ -599,6 +675,134 @@ func TestSortCollectorBasics(t *testing.T) {
 	}
 }
 
+type limitTestCollector struct {
+	base    int32
+	limit   int
+	docIDs  []int32
+	scored  int
+	reader  SegReader
+	matcher Matcher
+}
+
+func (c *limitTestCollector) Collect(docID int32) error {
+	if len(c.docIDs) == c.limit {
+		return ErrStopCollecting
+	}
+	if c.matcher != nil && c.matcher.Score() > 0 {
+		c.scored++
+	}
+	c.docIDs = append(c.docIDs, c.base+docID)
+	return nil
+}
+
+func (c *limitTestCollector) SetReader(reader SegReader) { c.reader = reader }
+func (c *limitTestCollector) SetBase(base int32)         { c.base = base }
+func (c *limitTestCollector) NeedScore() bool            { return true }
+func (c *limitTestCollector) SetMatcher(matcher Matcher) { c.matcher = matcher }
+
+func TestCustomCollector(t *testing.T) {
+	index := createTestIndex("a", "b", "a", "a")
+	searcher, _ := OpenIndexSearcher(index)
+
+	collector := &limitTestCollector{limit: 10}
+	if err := searcher.Collect("a", collector); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if !reflect.DeepEqual(collector.docIDs, []int32{1, 3, 4}) {
+		t.Errorf("Collected: %v", collector.docIDs)
+	}
+	if collector.reader == nil || collector.scored != 3 {
+		t.Errorf("SetReader/SetMatcher: %v, %d", collector.reader, collector.scored)
+	}
+
+	limited := &limitTestCollector{limit: 2}
+	if err := searcher.Collect(NewTermQuery("content", "a"), limited); err != nil {
+		t.Errorf("Collect with early termination: %v", err)
+	}
+	if len(limited.docIDs) != 2 {
+		t.Errorf("Early termination collected: %v", limited.docIDs)
+	}
+
+	if err := searcher.Collect("a", 42); err == nil {
+		t.Error("Collect with garbage collector should fail")
+	}
+}
+
+func TestCustomCollectorStopCleanup(t *testing.T) {
+	index := createTestIndex("a", "a", "b", "a", "a")
+	searcher, _ := OpenIndexSearcher(index)
+	before := len(goCollectorObjs.objs)
+	for i := 0; i < 20; i++ {
+		limited := &limitTestCollector{limit: 2}
+		if err := searcher.Collect("a", limited); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if !reflect.DeepEqual(limited.docIDs, []int32{1, 2}) {
+			t.Fatalf("Collected: %v", limited.docIDs)
+		}
+		if limited.matcher != nil {
+			t.Error("Matcher not cleared after stopping")
+		}
+	}
+	if after := len(goCollectorObjs.objs); after != before {
+		t.Errorf("GoCollectors leaked: %d before, %d after", before, after)
+	}
+}
+
+// Create an index with two segments, each of which has a deleted doc
+// matching "a".  The live docs matching "a" all have the content "a".  The
+// segments are padded with filler docs so that they are large enough, and
+// sparsely enough deleted from, not to be merged away.
+func createDeletionTestIndex(t *testing.T) Folder {
+	values := []string{"a", "a x"}
+	for i := 0; i < 19; i++ {
+		values = append(values, "c")
+	}
+	index := createTestIndex(values...)
+	for _, deleting := range []bool{false, true} {
+		indexer, err := OpenIndexer(&OpenIndexerArgs{Index: index})
+		if err != nil {
+			t.Fatalf("OpenIndexer: %v", err)
+		}
+		if deleting {
+			indexer.DeleteByTerm("content", "x")
+		} else {
+			for _, val := range values {
+				indexer.AddDoc(&testDoc{val})
+			}
+		}
+		if err := indexer.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		indexer.Close()
+	}
+	searcher, _ := OpenIndexSearcher(index)
+	if got := len(searcher.GetReader().SegReaders()); got < 2 {
+		t.Fatalf("Expected several segments, got %d", got)
+	}
+	if got := searcher.DocFreq("content", "x"); got != 2 {
+		t.Fatalf("Expected deletions to be pending, DocFreq: %d", got)
+	}
+	return index
+}
+
+func TestCustomCollectorDeletions(t *testing.T) {
+	searcher, _ := OpenIndexSearcher(createDeletionTestIndex(t))
+	collector := &limitTestCollector{limit: 10}
+	if err := searcher.Collect("a", collector); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(collector.docIDs) != 2 {
+		t.Fatalf("Collected: %v", collector.docIDs)
+	}
+	for _, docID := range collector.docIDs {
+		doc := &simpleTestDoc{}
+		if err := searcher.ReadDoc(docID, doc); err != nil || doc.Content != "a" {
+			t.Errorf("Collected deleted doc %d: %q, %v", docID, doc.Content, err)
+		}
+	}
+}
+
 func TestIndexSearcherMisc(t *testing.T) {
 	index := createTestIndex("a", "b", "c", "a a")
 	searcher, _ := OpenIndexSearcher(index)
@@ -649,6 +853,26 @@ func TestIndexSearcherHits(t *testing.T) {
 	}
 }
 
+func TestHitsContext(t *testing.T) {
+	index := createTestIndex("a", "b", "c", "a a")
+	searcher, _ := OpenIndexSearcher(index)
+	hits, err := HitsContext(context.Background(), searcher, "a", 0, 10, nil)
+	if err != nil {
+		t.Errorf("HitsContext: %v", err)
+	} else if got := hits.TotalHits(); got != 2 {
+		t.Errorf("HitsContext TotalHits: %d", got)
+	}
+	var doc simpleTestDoc
+	if !hits.Next(&doc) || doc.Content[0] != 'a' {
+		t.Errorf("HitsContext Next: %v", hits.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := HitsContext(ctx, searcher, "a", 0, 10, nil); err != context.Canceled {
+		t.Errorf("HitsContext with canceled context: %v", err)
+	}
+}
+
 func TestIndexSearcherTopDocs(t *testing.T) {
 	index := createTestIndex("a", "b")
 	searcher, _ := OpenIndexSearcher(index)
@@ -692,6 +916,65 @@ func TestIndexSearcherReadDoc(t *testing.T) {
 	}
 }
 
+func TestIndexSearcherReadDocStructTags(t *testing.T) {
+	index := createTestIndex("a", "b")
+	searcher, _ := OpenIndexSearcher(index)
+	doc := &taggedTestDoc{Internal: "keep"}
+	err := searcher.ReadDoc(2, doc)
+	if err != nil {
+		t.Errorf("ReadDoc failed with tagged struct: %v", err)
+	}
+	if doc.Body != "b" {
+		t.Errorf("Tagged field not filled: %q", doc.Body)
+	}
+	if doc.Internal != "keep" {
+		t.Errorf("Skipped field was overwritten: %q", doc.Internal)
+	}
+}
+
+func TestPolySearcher(t *testing.T) {
+	first, _ := OpenIndexSearcher(createTestIndex("a x", "b"))
+	second, _ := OpenIndexSearcher(createTestIndex("c", "a y"))
+	searcher, err := NewPolySearcher(first.GetSchema(), []Searcher{first, second})
+	if err != nil {
+		t.Fatalf("NewPolySearcher: %v", err)
+	}
+	if got := len(searcher.GetSearchers()); got != 2 {
+		t.Errorf("GetSearchers: %d", got)
+	}
+	if got := searcher.DocMax(); got != 4 {
+		t.Errorf("DocMax: %d", got)
+	}
+
+	doc := &simpleTestDoc{}
+	if err := searcher.ReadDoc(4, doc); err != nil || doc.Content != "a y" {
+		t.Errorf("ReadDoc from second searcher: %v, %v", doc, err)
+	}
+	hitDoc := NewHitDoc(0, 0.0)
+	if err := searcher.ReadDoc(1, hitDoc); err != nil || hitDoc.GetDocID() != 1 {
+		t.Errorf("ReadDoc into Doc: %d, %v", hitDoc.GetDocID(), err)
+	}
+	for _, docID := range []int32{0, 5} {
+		if err := searcher.ReadDoc(docID, doc); err == nil {
+			t.Errorf("ReadDoc(%d) should fail", docID)
+		}
+	}
+
+	hits, _ := searcher.Hits("a", 0, 10, nil)
+	var contents []string
+	fields := make(map[string]interface{})
+	for hits.Next(fields) {
+		contents = append(contents, fields["content"].(string))
+	}
+	if err := hits.Error(); err != nil {
+		t.Errorf("Hits.Next: %v", err)
+	}
+	sort.Strings(contents)
+	if !reflect.DeepEqual(contents, []string{"a x", "a y"}) {
+		t.Errorf("Hits across searchers: %v", contents)
+	}
+}
+
 func TestMatchDocBasics(t *testing.T) {
 	matchDoc := NewMatchDoc(0, 1.0, nil)
 	matchDoc.setDocID(42)
@@ -801,3 +1084,620 @@ func TestQueryParserAccessors(t *testing.T) {
 		t.Errorf("SetHeedColons/heedColons")
 	}
 }
+
+func TestGroupedHits(t *testing.T) {
+	index := createTestIndex("a x", "a y", "a x", "a z", "a x", "b x")
+	searcher, _ := OpenIndexSearcher(index)
+
+	grouped, err := searcher.GroupedHits("a", "content", 0, 2, 2)
+	if err != nil {
+		t.Fatalf("GroupedHits: %v", err)
+	}
+	if grouped.TotalHits != 5 || grouped.TotalGroups != 3 || len(grouped.Groups) != 2 {
+		t.Fatalf("Totals: %+v", grouped)
+	}
+	first := grouped.Groups[0]
+	if first.Value != "a x" || first.TotalHits != 3 || len(first.Hits) != 2 {
+		t.Errorf("First group: %+v", first)
+	}
+	if first.Hits[0].DocID != 1 || first.Hits[1].DocID != 3 {
+		t.Errorf("Top docs within group: %+v", first.Hits)
+	}
+	if second := grouped.Groups[1]; second.Value != "a y" || len(second.Hits) != 1 {
+		t.Errorf("Second group: %+v", second)
+	}
+
+	page, _ := searcher.GroupedHits("a", "content", 2, 2, 2)
+	if len(page.Groups) != 1 || page.Groups[0].Value != "a z" || page.TotalGroups != 3 {
+		t.Errorf("Paging by group: %+v", page)
+	}
+
+	if _, err := searcher.GroupedHits("a", "content", 0, 2, 0); err == nil {
+		t.Error("Zero groupSize should fail")
+	}
+	if _, err := searcher.GroupedHits("a", "nope", 0, 2, 2); err == nil {
+		t.Error("Grouping by an unknown field should fail")
+	}
+}
+
+func TestHitsAfter(t *testing.T) {
+	index := createTestIndex("a c", "a a", "a e", "a b", "a d", "b b")
+	searcher, _ := OpenIndexSearcher(index)
+
+	pages := func(sortSpec SortSpec) [][]string {
+		var pages [][]string
+		cursor := ""
+		for i := 0; i < 5; i++ {
+			hits, err := searcher.HitsAfter("a", cursor, 2, sortSpec)
+			if err != nil {
+				t.Fatalf("HitsAfter: %v", err)
+			}
+			if hits.TotalHits() != 5 {
+				t.Errorf("TotalHits: %d", hits.TotalHits())
+			}
+			var page []string
+			var doc simpleTestDoc
+			for hits.Next(&doc) {
+				page = append(page, doc.Content)
+			}
+			if len(page) == 0 {
+				break
+			}
+			pages = append(pages, page)
+			cursor = hits.Cursor()
+		}
+		return pages
+	}
+
+	byField := NewSortSpec([]SortRule{NewFieldSortRule("content", false)})
+	expected := [][]string{{"a a", "a b"}, {"a c", "a d"}, {"a e"}}
+	if got := pages(byField); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Pages sorted by field: %v", got)
+	}
+	reversed := NewSortSpec([]SortRule{NewFieldSortRule("content", true)})
+	expected = [][]string{{"a e", "a d"}, {"a c", "a b"}, {"a a"}}
+	if got := pages(reversed); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Pages sorted by reversed field: %v", got)
+	}
+	expected = [][]string{{"a c", "a a"}, {"a e", "a b"}, {"a d"}}
+	if got := pages(nil); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Pages sorted by score: %v", got)
+	}
+
+	if _, err := searcher.HitsAfter("a", "garbage!", 2, nil); err == nil {
+		t.Error("Malformed cursor should fail")
+	}
+}
+
+func TestScan(t *testing.T) {
+	index := createTestIndex("a", "b", "a", "c", "a")
+	searcher, _ := OpenIndexSearcher(index)
+
+	var docIDs []int32
+	for hit, err := range searcher.Scan("a", []string{"content"}) {
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if hit.Fields["content"] != "a" {
+			t.Errorf("Fields: %v", hit.Fields)
+		}
+		docIDs = append(docIDs, hit.DocID)
+	}
+	if !reflect.DeepEqual(docIDs, []int32{1, 3, 5}) {
+		t.Errorf("Scanned doc IDs: %v", docIDs)
+	}
+
+	count := 0
+	for range searcher.Scan("a", nil) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("Break out of Scan: %d", count)
+	}
+
+	second, _ := OpenIndexSearcher(createTestIndex("c", "a"))
+	poly, _ := NewPolySearcher(searcher.GetSchema(), []Searcher{searcher, second})
+	docIDs = nil
+	for hit, err := range poly.Scan("a", nil) {
+		if err != nil {
+			t.Fatalf("Scan PolySearcher: %v", err)
+		}
+		docIDs = append(docIDs, hit.DocID)
+	}
+	if !reflect.DeepEqual(docIDs, []int32{1, 3, 5, 7}) {
+		t.Errorf("Scanned PolySearcher doc IDs: %v", docIDs)
+	}
+}
+
+func TestScanDeletions(t *testing.T) {
+	searcher, _ := OpenIndexSearcher(createDeletionTestIndex(t))
+	count := 0
+	for hit, err := range searcher.Scan("a", []string{"content"}) {
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if hit.Fields["content"] != "a" {
+			t.Errorf("Scanned deleted doc %d: %v", hit.DocID, hit.Fields)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Scanned %d docs", count)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	index := createTestIndex("a b", "a a c", "b c")
+	searcher, _ := OpenIndexSearcher(index)
+
+	hits, _ := searcher.Hits("a OR c", 0, 10, nil)
+	docID, score, _ := hits.nextMatch()
+	expl, err := searcher.Explain("a OR c", docID)
+	if err != nil {
+		t.Fatalf("Explain: %v", err)
+	}
+	if !expl.Match || math.Abs(float64(expl.Value-score)) > 1e-6 {
+		t.Errorf("Explained score %v, searched score %v", expl.Value, score)
+	}
+	if len(expl.Details) != 3 || !strings.HasPrefix(expl.Details[2].Description, "coord(2/2)") {
+		t.Errorf("Expected two clauses and coord: %s", expl)
+	}
+	term := expl.Details[0]
+	if !strings.HasPrefix(term.Description, "weight(") || len(term.Details) != 2 {
+		t.Errorf("Term explanation: %s", term)
+	}
+	if product := term.Details[0].Value * term.Details[1].Value; math.Abs(float64(product-term.Value)) > 1e-6 {
+		t.Errorf("queryWeight * fieldWeight %v != %v", product, term.Value)
+	}
+	if !strings.Contains(expl.String(), "tf(freq=2)") {
+		t.Errorf("Rendered explanation: %s", expl)
+	}
+	if _, err := json.Marshal(expl); err != nil {
+		t.Errorf("Marshal: %v", err)
+	}
+
+	if miss, _ := searcher.Explain("a", 3); miss == nil || miss.Match {
+		t.Errorf("Non-matching doc: %v", miss)
+	}
+	if _, err := searcher.Explain("a", 42); err == nil {
+		t.Error("Explain invalid docID should fail")
+	}
+}
+
+// Matches a sorted list of doc IDs, giving each the same score.
+type sliceMatcher struct {
+	docIDs []int32
+	tick   int
+	score  float32
+}
+
+func newSliceMatcher(docIDs []int32, score float32) *sliceMatcher {
+	return &sliceMatcher{docIDs: docIDs, tick: -1, score: score}
+}
+
+func (m *sliceMatcher) Next() int32 {
+	if m.tick < len(m.docIDs) {
+		m.tick++
+	}
+	return m.GetDocID()
+}
+
+func (m *sliceMatcher) Advance(target int32) int32 {
+	for {
+		if docID := m.Next(); docID == 0 || docID >= target {
+			return docID
+		}
+	}
+}
+
+func (m *sliceMatcher) GetDocID() int32 {
+	if m.tick < 0 || m.tick >= len(m.docIDs) {
+		return 0
+	}
+	return m.docIDs[m.tick]
+}
+
+func (m *sliceMatcher) Score() float32 { return m.score }
+
+// Matches docs whose content is no longer than max bytes.
+type shortContentQuery struct {
+	max int
+}
+
+type shortContentCompiler struct {
+	max    int
+	weight float32
+}
+
+func (q shortContentQuery) String() string { return fmt.Sprintf("short(%d)", q.max) }
+
+func (q shortContentQuery) MakeCompiler(searcher Searcher, boost float32) (CustomCompiler, error) {
+	return &shortContentCompiler{max: q.max, weight: boost}, nil
+}
+
+func (c *shortContentCompiler) SumOfSquaredWeights() float32   { return c.weight * c.weight }
+func (c *shortContentCompiler) ApplyNormFactor(factor float32) { c.weight *= factor }
+
+func (c *shortContentCompiler) MakeMatcher(reader SegReader, needScore bool) (Matcher, error) {
+	docReader := reader.Fetch("Lucy::Index::DocReader").(DocReader)
+	var docIDs []int32
+	for docID := int32(1); docID <= reader.DocMax(); docID++ {
+		doc := testDoc{}
+		if err := docReader.ReadDoc(docID, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) <= c.max {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	if len(docIDs) == 0 {
+		return nil, nil
+	}
+	return NewCustomMatcher(newSliceMatcher(docIDs, c.weight)), nil
+}
+
+func TestCustomMatcherBasics(t *testing.T) {
+	matcher := NewCustomMatcher(newSliceMatcher([]int32{42, 43, 100}, 2))
+	checkMatcher(t, matcher, true)
+}
+
+func TestCustomQuery(t *testing.T) {
+	index := createTestIndex("a", "a b c", "b", "a b")
+	searcher, _ := OpenIndexSearcher(index)
+	query := NewCustomQuery(shortContentQuery{1})
+	if got := query.ToString(); got != "short(1)" {
+		t.Errorf("ToString: %s", got)
+	}
+	checkQueryMakeCompiler(t, query)
+
+	hits, err := searcher.Hits(query, 0, 10, nil)
+	if err != nil || hits.TotalHits() != 2 {
+		t.Fatalf("Hits: %d, %v", hits.TotalHits(), err)
+	}
+	_, score, _ := hits.nextMatch()
+	if score != 1 {
+		t.Errorf("Normalized score of lone custom query: %v", score)
+	}
+
+	and := NewANDQuery([]Query{query, NewTermQuery("content", "a")})
+	hits, _ = searcher.Hits(and, 0, 10, nil)
+	if docID, _, _ := hits.nextMatch(); hits.TotalHits() != 1 || docID != 1 {
+		t.Errorf("ANDQuery with custom clause: %d hits, doc %d", hits.TotalHits(), docID)
+	}
+	or := NewORQuery([]Query{query, NewTermQuery("content", "a")})
+	if hits, _ = searcher.Hits(or, 0, 10, nil); hits.TotalHits() != 4 {
+		t.Errorf("ORQuery with custom clause: %d hits", hits.TotalHits())
+	}
+	if hits, _ = searcher.Hits(NewCustomQuery(shortContentQuery{0}), 0, 10, nil); hits.TotalHits() != 0 {
+		t.Errorf("Custom query without matches: %d hits", hits.TotalHits())
+	}
+}
+
+func TestTermPatternQueries(t *testing.T) {
+	index := createTestIndex("microsoft", "microscope", "micro", "macro", "mic")
+	searcher, _ := OpenIndexSearcher(index)
+	checkTotalHits := func(query interface{}, expected uint32) {
+		hits, err := searcher.Hits(query, 0, 10, nil)
+		if err != nil {
+			t.Errorf("Hits %v: %v", query, err)
+		} else if got := hits.TotalHits(); got != expected {
+			t.Errorf("Hits %v: expected %d hits, got %d", query, expected, got)
+		}
+	}
+
+	prefix := NewPrefixQuery("content", "micro", nil)
+	if got := prefix.ToString(); got != "content:micro*" {
+		t.Errorf("ToString: %s", got)
+	}
+	checkTotalHits(prefix, 3)
+	hits, _ := searcher.Hits(prefix, 0, 10, nil)
+	_, first, _ := hits.nextMatch()
+	_, second, _ := hits.nextMatch()
+	if first != second {
+		t.Errorf("Constant scores differ: %v %v", first, second)
+	}
+	checkTotalHits(NewPrefixQuery("content", "micro", &TermExpansionArgs{Scored: true}), 3)
+	checkTotalHits(NewPrefixQuery("content", "nope", nil), 0)
+	limited := NewPrefixQuery("content", "mic", &TermExpansionArgs{MaxExpansions: 2})
+	if _, err := searcher.Hits(limited, 0, 10, nil); err == nil {
+		t.Error("Exceeding MaxExpansions should fail")
+	}
+
+	checkTotalHits(NewWildcardQuery("content", "m?cro", nil), 2)
+	checkTotalHits(NewWildcardQuery("content", "micro*e", nil), 1)
+	checkTotalHits(NewWildcardQuery("content", "*cro", nil), 2)
+
+	regexpQuery, err := NewRegexpQuery("content", "mic(ro)?", nil)
+	if err != nil {
+		t.Fatalf("NewRegexpQuery: %v", err)
+	}
+	checkTotalHits(regexpQuery, 2)
+	if _, err := NewRegexpQuery("content", "mic(", nil); err == nil {
+		t.Error("Invalid regexp should fail")
+	}
+
+	and := NewANDQuery([]Query{prefix, NewTermQuery("content", "micro")})
+	checkTotalHits(and, 1)
+
+	checkTotalHits("micro*", 1) // Searchers don't expand terms in strings
+	qParser := NewQueryParser(searcher.GetSchema(), nil)
+	if query := qParser.Parse("mic*"); query.ToString() == "content:mic*" {
+		t.Error("Term expansion should be off by default")
+	}
+	if err := qParser.EnableTermExpansion(nil); err != nil {
+		t.Fatalf("EnableTermExpansion: %v", err)
+	}
+	if query := qParser.Parse("mic*"); query.ToString() != "content:mic*" {
+		t.Errorf("QueryParser expansion: %s", query.ToString())
+	}
+	checkTotalHits(qParser.Parse("micro*"), 3)
+	checkTotalHits(qParser.Parse("Micro*"), 0) // StandardTokenizer keeps case
+	checkTotalHits(qParser.Parse("content:m?cro"), 2)
+	checkTotalHits(qParser.Parse("micro* AND NOT microscope"), 2)
+	checkTotalHits(qParser.Parse(`micro\*`), 1) // escaped, so analyzed as "micro"
+	limitedParser := NewQueryParser(searcher.GetSchema(), nil)
+	limitedParser.EnableTermExpansion(&TermExpansionArgs{MaxExpansions: 2})
+	if _, err := searcher.Hits(limitedParser.Parse("mic*"), 0, 10, nil); err == nil {
+		t.Error("Parser's MaxExpansions should apply")
+	}
+}
+
+func TestTermExpansionNormalization(t *testing.T) {
+	schema := NewSchema()
+	schema.SpecField("title", NewFullTextType(NewEasyAnalyzer("en")))
+	schema.SpecField("sku", NewStringType())
+	folder := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Schema: schema, Index: folder, Create: true})
+	indexer.AddDoc(map[string]interface{}{"title": "Microscopes", "sku": "AB-12"})
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	searcher, _ := OpenIndexSearcher(folder)
+	qParser := NewQueryParser(schema, nil)
+	qParser.EnableTermExpansion(nil)
+	for query, expected := range map[string]uint32{
+		"MICROSCOPE*":  1, // case folded and stemmed like the title
+		"title:Mic?o*": 1,
+		"Microscops~1": 1,
+		"sku:AB*":      1,
+		"sku:ab*":      0, // StringType fields aren't normalized
+	} {
+		hits, err := searcher.Hits(qParser.Parse(query), 0, 10, nil)
+		if err != nil {
+			t.Errorf("Hits %q: %v", query, err)
+		} else if got := hits.TotalHits(); got != expected {
+			t.Errorf("Hits %q: expected %d, got %d", query, expected, got)
+		}
+	}
+}
+
+func TestFuzzyQuery(t *testing.T) {
+	index := createTestIndex("receive", "recipe", "deceive", "relieve", "believe")
+	searcher, _ := OpenIndexSearcher(index)
+	checkTotalHits := func(query interface{}, expected uint32) {
+		hits, err := searcher.Hits(query, 0, 10, nil)
+		if err != nil {
+			t.Errorf("Hits %v: %v", query, err)
+		} else if got := hits.TotalHits(); got != expected {
+			t.Errorf("Hits %v: expected %d hits, got %d", query, expected, got)
+		}
+	}
+	fuzzy := func(term string, maxEdits, prefixLength int) Query {
+		query, err := NewFuzzyQuery("content", term, maxEdits, prefixLength)
+		if err != nil {
+			t.Fatalf("NewFuzzyQuery: %v", err)
+		}
+		return query
+	}
+
+	oneEdit := fuzzy("recieve", 1, 0)
+	if got := oneEdit.ToString(); got != "content:recieve~1" {
+		t.Errorf("ToString: %s", got)
+	}
+	checkTotalHits(oneEdit, 2)
+	checkTotalHits(fuzzy("recieve", 2, 0), 5)
+	checkTotalHits(fuzzy("recieve", 2, 3), 2)
+	checkTotalHits(fuzzy("recieve", 0, 0), 0)
+	checkTotalHits(fuzzy("receive", 0, 0), 1)
+	checkTotalHits(fuzzy("zzz", 2, 0), 0)
+	if _, err := NewFuzzyQuery("content", "recieve", 3, 0); err == nil {
+		t.Error("maxEdits above MaxFuzzyEdits should fail")
+	}
+
+	hits, _ := searcher.Hits(fuzzy("recieve", 2, 0), 0, 10, nil)
+	var doc simpleTestDoc
+	if !hits.Next(&doc) || (doc.Content != "receive" && doc.Content != "relieve") {
+		t.Errorf("Closest term should score highest: %s", doc.Content)
+	}
+
+	qParser := NewQueryParser(searcher.GetSchema(), nil)
+	qParser.EnableTermExpansion(nil)
+	checkTotalHits(qParser.Parse("recieve~1"), 2)
+	checkTotalHits(qParser.Parse("recieve~"), 5)
+	checkTotalHits(qParser.Parse("content:recieve~1 AND NOT relieve"), 1)
+}
+
+type sliceTermCursor struct {
+	terms []string
+	tick  int
+	seeks int
+}
+
+func (c *sliceTermCursor) current() (string, bool) {
+	if c.tick >= len(c.terms) {
+		return "", false
+	}
+	return c.terms[c.tick], true
+}
+
+func (c *sliceTermCursor) next() {
+	c.tick++
+}
+
+func (c *sliceTermCursor) seek(target string) {
+	c.seeks++
+	c.tick = sort.SearchStrings(c.terms, target)
+}
+
+// Compute the edit distance counting transpositions directly.
+func restrictedEditDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j-1]+cost, minInt(d[i-1][j]+1, d[i][j-1]+1))
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func TestLevenshteinAutomatonIntersect(t *testing.T) {
+	var terms []string
+	letters := "abcde"
+	var build func(prefix string)
+	build = func(prefix string) {
+		if prefix != "" {
+			terms = append(terms, prefix)
+		}
+		if len(prefix) < 5 {
+			for _, r := range letters {
+				build(prefix + string(r))
+			}
+		}
+	}
+	build("")
+	terms = append(terms, "café", "écad")
+	sort.Strings(terms)
+
+	for _, word := range []string{"abc", "bad", "cede", "e", "café"} {
+		for maxEdits := 0; maxEdits <= MaxFuzzyEdits; maxEdits++ {
+			automaton := newLevenshteinAutomaton([]rune(word), maxEdits)
+			cursor := &sliceTermCursor{terms: terms}
+			found := make(map[string]int)
+			automaton.intersect(cursor, "", found)
+			for _, term := range terms {
+				dist := restrictedEditDistance([]rune(word), []rune(term))
+				got, ok := found[term]
+				if dist <= maxEdits && (!ok || got != dist) {
+					t.Errorf("%s~%d: %s should be found at %d, got %d %v",
+						word, maxEdits, term, dist, got, ok)
+				} else if dist > maxEdits && ok {
+					t.Errorf("%s~%d: %s should not be found", word, maxEdits, term)
+				}
+			}
+			if maxEdits < MaxFuzzyEdits && cursor.seeks == 0 {
+				t.Errorf("%s~%d: expected the cursor to seek ahead", word, maxEdits)
+			}
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	index := createTestIndex("receive the package", "receive mail", "deceive",
+		"recipe book", "relieve pain")
+	searcher, _ := OpenIndexSearcher(index)
+	checkSuggestion := func(query, expected string, totalHits uint32) {
+		suggestion, err := Suggest(searcher, query, nil)
+		if err != nil {
+			t.Errorf("Suggest %q: %v", query, err)
+		} else if suggestion == nil {
+			t.Errorf("Suggest %q: expected %q, got nil", query, expected)
+		} else if suggestion.Query != expected || suggestion.TotalHits != totalHits {
+			t.Errorf("Suggest %q: expected %q with %d hits, got %q with %d",
+				query, expected, totalHits, suggestion.Query, suggestion.TotalHits)
+		}
+	}
+	checkSuggestion("recieve mail", "receive mail", 2)
+	checkSuggestion("recieve AND mial", "receive AND mail", 1)
+	checkSuggestion("content:recipie", "content:recipe", 1)
+	checkSuggestion(`"recieve mail"`, `"receive mail"`, 1)
+	checkSuggestion("recieve mail~1", "receive mail~1", 2)
+	checkSuggestion("Receive mail", "receive mail", 2) // case sensitive field
+
+	for _, query := range []string{"receive mail", "recie*", "zzzzzz"} {
+		if suggestion, err := Suggest(searcher, query, nil); err != nil || suggestion != nil {
+			t.Errorf("Suggest %q: expected no suggestion, got %v %v", query, suggestion, err)
+		}
+	}
+	if _, err := Suggest(searcher, "recieve", &SuggestArgs{MaxEdits: 3}); err == nil {
+		t.Error("MaxEdits above MaxFuzzyEdits should fail")
+	}
+
+	terms, err := SuggestTerms(searcher, "content", "recieve", nil)
+	if err != nil {
+		t.Fatalf("SuggestTerms: %v", err)
+	}
+	expected := []TermSuggestion{
+		{"receive", 1, 2},
+		{"relieve", 1, 1},
+		{"deceive", 2, 1},
+		{"recipe", 2, 1},
+	}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("SuggestTerms: %v", terms)
+	}
+	terms, _ = SuggestTerms(searcher, "content", "recieve", &SuggestArgs{MaxEdits: 1, PrefixLength: 3})
+	if len(terms) != 1 || terms[0].Term != "receive" {
+		t.Errorf("SuggestTerms with MaxEdits and PrefixLength: %v", terms)
+	}
+}
+
+func TestSuggestAnalyzedField(t *testing.T) {
+	schema := NewSchema()
+	schema.SpecField("content", NewFullTextType(NewEasyAnalyzer("en")))
+	folder := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Schema: schema, Index: folder, Create: true})
+	for _, content := range []string{"received the package", "receiving mail", "mail received"} {
+		indexer.AddDoc(map[string]interface{}{"content": content})
+	}
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	searcher, _ := OpenIndexSearcher(folder)
+	for query, expected := range map[string]string{
+		"recieved mail": "received mail",
+		"Recieving":     "receiving",
+	} {
+		suggestion, err := Suggest(searcher, query, nil)
+		if err != nil || suggestion == nil || suggestion.Query != expected {
+			t.Errorf("Suggest %q: expected %q, got %v, %v", query, expected, suggestion, err)
+		}
+	}
+	if suggestion, err := Suggest(searcher, "Received MAIL", nil); err != nil || suggestion != nil {
+		t.Errorf("Terms found after analysis shouldn't be corrected: %v, %v", suggestion, err)
+	}
+}
+
+func TestSuggestUnderscoreField(t *testing.T) {
+	schema := NewSchema()
+	schema.SpecField("product_name", NewFullTextType(NewStandardTokenizer()))
+	folder := NewRAMFolder("")
+	indexer, _ := OpenIndexer(&OpenIndexerArgs{Schema: schema, Index: folder, Create: true})
+	indexer.AddDoc(map[string]interface{}{"product_name": "receive kit"})
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	searcher, _ := OpenIndexSearcher(folder)
+	suggestion, err := Suggest(searcher, "product_name:recieve", nil)
+	if err != nil || suggestion == nil || suggestion.Query != "product_name:receive" {
+		t.Errorf("Suggest with underscore field: %v, %v", suggestion, err)
+	}
+	suggestion, err = Suggest(searcher, "recieve_kit", nil)
+	if err != nil || suggestion != nil {
+		t.Errorf("Underscore should join a word: %v, %v", suggestion, err)
+	}
+}
@@ -0,0 +1,260 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+/*
+#include "Lucy/Index/Similarity.h"
+#include "Clownfish/Class.h"
+#include "Clownfish/Err.h"
+#include "Clownfish/Hash.h"
+#include "Clownfish/String.h"
+
+extern float
+GOLUCY_GoSimilarity_TF(lucy_Similarity *self, float freq);
+extern float
+GOLUCY_GoSimilarity_IDF(lucy_Similarity *self, int64_t doc_freq, int64_t total_docs);
+extern float
+GOLUCY_GoSimilarity_Coord(lucy_Similarity *self, uint32_t overlap, uint32_t max_overlap);
+extern float
+GOLUCY_GoSimilarity_Length_Norm(lucy_Similarity *self, uint32_t num_tokens);
+extern float
+GOLUCY_GoSimilarity_Query_Norm(lucy_Similarity *self, float sum_of_squared_weights);
+extern cfish_Obj*
+GOLUCY_GoSimilarity_Dump(lucy_Similarity *self);
+extern lucy_Similarity*
+GOLUCY_GoSimilarity_Load(lucy_Similarity *self, cfish_Obj *dump);
+extern bool
+GOLUCY_GoSimilarity_Equals(lucy_Similarity *self, cfish_Obj *other);
+extern void
+GOLUCY_GoSimilarity_Destroy(lucy_Similarity *self);
+
+// Create a subclass of Similarity whose methods are implemented by Go
+// CustomSimilarities.
+static cfish_Class*
+init_go_similarity_class() {
+	cfish_String *name = cfish_Str_newf("Lucy::Index::GoSimilarity");
+	cfish_Class *klass = cfish_Class_singleton(name, LUCY_SIMILARITY);
+	CFISH_DECREF(name);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_TF,
+						 LUCY_Sim_TF_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_IDF,
+						 LUCY_Sim_IDF_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_Coord,
+						 LUCY_Sim_Coord_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_Length_Norm,
+						 LUCY_Sim_Length_Norm_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_Query_Norm,
+						 LUCY_Sim_Query_Norm_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_Dump,
+						 LUCY_Sim_Dump_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_Load,
+						 LUCY_Sim_Load_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_Equals,
+						 CFISH_Obj_Equals_OFFSET);
+	CFISH_Class_Override(klass, (cfish_method_t)GOLUCY_GoSimilarity_Destroy,
+						 CFISH_Obj_Destroy_OFFSET);
+	return klass;
+}
+
+static lucy_Similarity*
+make_similarity(cfish_Class *klass) {
+	return lucy_Sim_init((lucy_Similarity*)CFISH_Class_Make_Obj(klass));
+}
+*/
+import "C"
+import "fmt"
+import "math"
+import "unsafe"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// CustomSimilarity is implemented by Go types which supply the scoring
+// formulas used by a Similarity.  Embed ClassicSimilarity to override only
+// some of them.  Wrap one with NewCustomSimilarity to use it in a FieldType
+// or a Schema.
+type CustomSimilarity interface {
+	TF(freq float32) float32
+	IDF(docFreq, totalDocs int64) float32
+	Coord(overlap, maxOverlap uint32) float32
+	LengthNorm(numTokens uint32) float32
+	QueryNorm(sumOfSquaredWeights float32) float32
+}
+
+// ClassicSimilarity implements the formulas of Lucy's default Similarity.
+type ClassicSimilarity struct{}
+
+// TF returns the square root of the term frequency.
+func (ClassicSimilarity) TF(freq float32) float32 {
+	return float32(math.Sqrt(float64(freq)))
+}
+
+// IDF returns 1 + ln(totalDocs / (docFreq + 1)).
+func (ClassicSimilarity) IDF(docFreq, totalDocs int64) float32 {
+	if totalDocs == 0 {
+		return 1
+	}
+	return float32(1 + math.Log(float64(totalDocs)/float64(docFreq+1)))
+}
+
+// Coord returns the fraction of a compound query's clauses which matched.
+func (ClassicSimilarity) Coord(overlap, maxOverlap uint32) float32 {
+	if maxOverlap == 0 {
+		return 1
+	}
+	return float32(overlap) / float32(maxOverlap)
+}
+
+// LengthNorm returns 1 / sqrt(numTokens), so that matches in short fields
+// score higher than matches in long ones.
+func (ClassicSimilarity) LengthNorm(numTokens uint32) float32 {
+	if numTokens == 0 {
+		return 0
+	}
+	return float32(1 / math.Sqrt(float64(numTokens)))
+}
+
+// QueryNorm returns 1 / sqrt(sumOfSquaredWeights).
+func (ClassicSimilarity) QueryNorm(sumOfSquaredWeights float32) float32 {
+	if sumOfSquaredWeights == 0 {
+		return 0
+	}
+	return float32(1 / math.Sqrt(float64(sumOfSquaredWeights)))
+}
+
+type customSimilarityEntry struct {
+	name string
+	impl CustomSimilarity
+}
+
+var goSimilarityClass *C.cfish_Class
+
+// Named CustomSimilarities, used to reconstitute similarities loaded from a
+// serialized Schema.
+var customSimilarities = newNameRegistry("CustomSimilarity")
+
+// The CustomSimilarities backing live GoSimilarity objects, keyed by C
+// pointer.
+var goSimilarityObjs = newHostObjRegistry()
+
+func initSimilarityClasses() {
+	goSimilarityClass = C.init_go_similarity_class()
+	clownfish.RegisterWrapFuncs(map[unsafe.Pointer]clownfish.WrapFunc{
+		unsafe.Pointer(goSimilarityClass): WRAPSimilarityASOBJ,
+	})
+}
+
+// NewCustomSimilarity wraps a CustomSimilarity as a Similarity.  The name
+// identifies the similarity when a Schema is serialized: an index which uses
+// a custom similarity can only be opened after a similarity with the same
+// name has been created in the current process.  Reusing a name for a
+// different CustomSimilarity is an error.
+func NewCustomSimilarity(name string, impl CustomSimilarity) (Similarity, error) {
+	if err := customSimilarities.register(name, impl); err != nil {
+		return nil, err
+	}
+	return WRAPSimilarity(unsafe.Pointer(newGoSimilarity(name, impl))), nil
+}
+
+func newGoSimilarity(name string, impl CustomSimilarity) *C.lucy_Similarity {
+	objC := C.make_similarity(goSimilarityClass)
+	goSimilarityObjs.store(unsafe.Pointer(objC), &customSimilarityEntry{name, impl})
+	return objC
+}
+
+func fetchGoSimilarity(self *C.lucy_Similarity) *customSimilarityEntry {
+	entry, ok := goSimilarityObjs.fetch(unsafe.Pointer(self)).(*customSimilarityEntry)
+	if !ok {
+		panic(clownfish.NewErr("No CustomSimilarity registered for GoSimilarity"))
+	}
+	return entry
+}
+
+//export GOLUCY_GoSimilarity_TF
+func GOLUCY_GoSimilarity_TF(self *C.lucy_Similarity, freq C.float) C.float {
+	return C.float(fetchGoSimilarity(self).impl.TF(float32(freq)))
+}
+
+//export GOLUCY_GoSimilarity_IDF
+func GOLUCY_GoSimilarity_IDF(self *C.lucy_Similarity, docFreq, totalDocs C.int64_t) C.float {
+	return C.float(fetchGoSimilarity(self).impl.IDF(int64(docFreq), int64(totalDocs)))
+}
+
+//export GOLUCY_GoSimilarity_Coord
+func GOLUCY_GoSimilarity_Coord(self *C.lucy_Similarity, overlap, maxOverlap C.uint32_t) C.float {
+	return C.float(fetchGoSimilarity(self).impl.Coord(uint32(overlap), uint32(maxOverlap)))
+}
+
+//export GOLUCY_GoSimilarity_Length_Norm
+func GOLUCY_GoSimilarity_Length_Norm(self *C.lucy_Similarity, numTokens C.uint32_t) C.float {
+	return C.float(fetchGoSimilarity(self).impl.LengthNorm(uint32(numTokens)))
+}
+
+//export GOLUCY_GoSimilarity_Query_Norm
+func GOLUCY_GoSimilarity_Query_Norm(self *C.lucy_Similarity, sumOfSquaredWeights C.float) C.float {
+	return C.float(fetchGoSimilarity(self).impl.QueryNorm(float32(sumOfSquaredWeights)))
+}
+
+//export GOLUCY_GoSimilarity_Dump
+func GOLUCY_GoSimilarity_Dump(self *C.lucy_Similarity) *C.cfish_Obj {
+	entry := fetchGoSimilarity(self)
+	dump := map[string]interface{}{
+		"_class": "Lucy::Index::GoSimilarity",
+		"name":   entry.name,
+	}
+	return (*C.cfish_Obj)(clownfish.GoToClownfish(dump,
+		unsafe.Pointer(C.CFISH_HASH), false))
+}
+
+//export GOLUCY_GoSimilarity_Load
+func GOLUCY_GoSimilarity_Load(self *C.lucy_Similarity, dump *C.cfish_Obj) *C.lucy_Similarity {
+	dumpGo, ok := clownfish.ToGo(unsafe.Pointer(dump)).(map[string]interface{})
+	if !ok {
+		panic(clownfish.NewErr("GoSimilarity dump is not a Hash"))
+	}
+	name, _ := dumpGo["name"].(string)
+	impl, ok := customSimilarities.lookup(name)
+	if !ok {
+		mess := fmt.Sprintf("No CustomSimilarity named '%s' has been created", name)
+		panic(clownfish.NewErr(mess))
+	}
+	return newGoSimilarity(name, impl.(CustomSimilarity))
+}
+
+//export GOLUCY_GoSimilarity_Equals
+func GOLUCY_GoSimilarity_Equals(self *C.lucy_Similarity, other *C.cfish_Obj) C.bool {
+	if unsafe.Pointer(self) == unsafe.Pointer(other) {
+		return true
+	}
+	if C.cfish_Obj_get_class(other) != goSimilarityClass {
+		return false
+	}
+	entry, ok := goSimilarityObjs.fetch(unsafe.Pointer(self)).(*customSimilarityEntry)
+	otherEntry, otherOK := goSimilarityObjs.fetch(unsafe.Pointer(other)).(*customSimilarityEntry)
+	return C.bool(ok && otherOK && entry.name == otherEntry.name)
+}
+
+//export GOLUCY_GoSimilarity_Destroy
+func GOLUCY_GoSimilarity_Destroy(self *C.lucy_Similarity) {
+	goSimilarityObjs.delete(unsafe.Pointer(self))
+	C.cfish_super_destroy(unsafe.Pointer(self), goSimilarityClass)
+}
+
+// Default parameters for NewBM25Similarity.
+const (
+	DefaultBM25K1 = 1.2
+	DefaultBM25B  = 0.75
+)
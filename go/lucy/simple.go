@@ -82,11 +82,12 @@ func (s *SimpleIMP) AddDoc(doc interface{}) error {
 		}
 
 		// Copy field values into stockDoc.
-		docType := docValue.Type()
-		for i := 0; i < docValue.NumField(); i++ {
-			field := docType.Field(i).Name
-			value := docValue.Field(i).String()
-			docFields[field] = value
+		for _, sf := range structFields(docValue.Type()) {
+			fieldValue := docValue.Field(sf.index)
+			if !sf.holdsValue() || (sf.omitEmpty && fieldValue.IsZero()) {
+				continue
+			}
+			docFields[sf.name] = fieldValue.String()
 		}
 	}
 
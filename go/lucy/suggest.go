@@ -0,0 +1,384 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lucy
+
+import "fmt"
+import "sort"
+import "strings"
+import "unicode"
+import "unicode/utf8"
+
+import "git-wip-us.apache.org/repos/asf/lucy-clownfish.git/runtime/go/clownfish"
+
+// SuggestArgs adjusts how Suggest corrects a query string.  Zero values
+// select the defaults.
+type SuggestArgs struct {
+	// Fields whose vocabulary is consulted for terms without an explicit
+	// field.  Defaults to the fields a QueryParser would search.
+	Fields []string
+	// The most edits a correction may make to a term.  Defaults to
+	// MaxFuzzyEdits.
+	MaxEdits int
+	// The number of leading characters a correction must share with the
+	// term.
+	PrefixLength int
+	// Terms found in fewer docs than this are corrected.  Defaults to 1, so
+	// that only terms missing from the index are corrected.
+	MinDocFreq uint32
+}
+
+// Suggestion is a corrected query string and the number of hits it yields.
+type Suggestion struct {
+	Query     string
+	TotalHits uint32
+}
+
+// TermSuggestion is a candidate correction for a single term.
+type TermSuggestion struct {
+	Term    string
+	Edits   int
+	DocFreq uint32
+}
+
+// Suggest proposes a "did you mean" alternative for a query string using
+// only the vocabulary of the searcher's index.  Each term which is rare in
+// the index is replaced by the candidate from SuggestTerms with the fewest
+// edits, preferring the candidate found in the most docs.  Operators,
+// field names, phrases and wildcard or fuzzy terms keep their structure.
+// If no term needs correcting, the returned Suggestion is nil.
+//
+// Words are looked up as each field's analyzer indexes them, so case
+// sensitive fields are matched exactly and stemmed fields by stem.  A
+// correction to a stem is suggested as the stem plus the ending which the
+// analyzer stripped from the original word, and only if that analyzes back
+// to the stem, so that raw stems aren't suggested.
+func Suggest(searcher Searcher, query string, args *SuggestArgs) (*Suggestion, error) {
+	s, err := newSuggester(searcher, args)
+	if err != nil {
+		return nil, err
+	}
+	corrected, changed, err := s.correct(query)
+	if err != nil || !changed {
+		return nil, err
+	}
+	hits, err := searcher.Hits(corrected, 0, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Suggestion{Query: corrected, TotalHits: hits.TotalHits()}, nil
+}
+
+// SuggestTerms returns the terms in field which are more common than term
+// and within args.MaxEdits edits of it, ordered by the number of edits and
+// then by descending doc frequency.  The term is compared with the indexed
+// terms as it is, without analysis.  args.Fields and args.MinDocFreq are
+// ignored.
+func SuggestTerms(searcher Searcher, field, term string, args *SuggestArgs) ([]TermSuggestion, error) {
+	s, err := newSuggester(searcher, args)
+	if err != nil {
+		return nil, err
+	}
+	return s.candidates([]string{field}, term)
+}
+
+type suggester struct {
+	schema       Schema
+	lexReaders   []LexiconReader
+	fields       []string
+	maxEdits     int
+	prefixLength int
+	minDocFreq   uint32
+}
+
+func newSuggester(searcher Searcher, args *SuggestArgs) (*suggester, error) {
+	var s suggester
+	if args != nil {
+		s.fields = args.Fields
+		s.maxEdits = args.MaxEdits
+		s.prefixLength = args.PrefixLength
+		s.minDocFreq = args.MinDocFreq
+	}
+	if s.maxEdits == 0 {
+		s.maxEdits = MaxFuzzyEdits
+	} else if s.maxEdits < 0 || s.maxEdits > MaxFuzzyEdits {
+		mess := fmt.Sprintf("MaxEdits must be between 0 and %d: %d", MaxFuzzyEdits, s.maxEdits)
+		return nil, clownfish.NewErr(mess)
+	}
+	if s.prefixLength < 0 {
+		return nil, clownfish.NewErr(fmt.Sprintf("Invalid PrefixLength: %d", s.prefixLength))
+	}
+	if s.minDocFreq == 0 {
+		s.minDocFreq = 1
+	}
+	s.schema = searcher.GetSchema()
+	if s.fields == nil {
+		qParser := NewQueryParser(s.schema, nil).(*QueryParserIMP)
+		s.fields = qParser.getFields()
+	}
+	segReaders, err := searcherSegReaders(searcher)
+	if err != nil {
+		return nil, err
+	}
+	s.lexReaders = segLexiconReaders(segReaders)
+	return &s, nil
+}
+
+// Sum the number of docs which contain term in any of the fields.
+func (s *suggester) docFreq(fields []string, term string) (uint32, error) {
+	var total uint32
+	for _, field := range fields {
+		for _, lexReader := range s.lexReaders {
+			docFreq, err := lexReader.DocFreq(field, term)
+			if err != nil {
+				return 0, err
+			}
+			total += docFreq
+		}
+	}
+	return total, nil
+}
+
+func (s *suggester) candidates(fields []string, term string) ([]TermSuggestion, error) {
+	termFreq, err := s.docFreq(fields, term)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(term)
+	prefixLength := s.prefixLength
+	if prefixLength > len(runes) {
+		prefixLength = len(runes)
+	}
+	prefix := string(runes[:prefixLength])
+	automaton := newLevenshteinAutomaton(runes[prefixLength:], s.maxEdits)
+	found := make(map[string]int)
+	for _, field := range fields {
+		err := findFuzzyTerms(s.lexReaders, field, prefix, automaton, found)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var suggestions termSuggestions
+	for candidate, edits := range found {
+		if candidate == term {
+			continue
+		}
+		docFreq, err := s.docFreq(fields, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if docFreq > termFreq {
+			suggestions = append(suggestions, TermSuggestion{candidate, edits, docFreq})
+		}
+	}
+	sort.Sort(suggestions)
+	return suggestions, nil
+}
+
+// termSuggestions sorts the fewest edits first, then the most docs.
+type termSuggestions []TermSuggestion
+
+func (t termSuggestions) Len() int      { return len(t) }
+func (t termSuggestions) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t termSuggestions) Less(i, j int) bool {
+	if t[i].Edits != t[j].Edits {
+		return t[i].Edits < t[j].Edits
+	}
+	if t[i].DocFreq != t[j].DocFreq {
+		return t[i].DocFreq > t[j].DocFreq
+	}
+	return t[i].Term < t[j].Term
+}
+
+// Report whether text[start:colon] is a field name followed by a colon,
+// following the rules of QueryLexer: the name must start with an ASCII
+// letter or an underscore, hold only ASCII alphanumerics and underscores,
+// and the colon must be followed by a term, a phrase or a group.
+func isQueryField(text []rune, start, colon int) bool {
+	if colon+1 >= len(text) || text[colon] != ':' {
+		return false
+	}
+	for j := start; j < colon; j++ {
+		r := text[j]
+		if r >= utf8.RuneSelf || !(r == '_' || unicode.IsLetter(r) || j > start && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	next := text[colon+1]
+	return next >= utf8.RuneSelf || next == '_' || next == '"' || next == '(' ||
+		unicode.IsLetter(next) || unicode.IsDigit(next)
+}
+
+// Rewrite the query string, replacing the words which need correcting and
+// leaving everything else untouched.
+func (s *suggester) correct(query string) (string, bool, error) {
+	text := []rune(query)
+	var b strings.Builder
+	changed := false
+	inQuotes := false
+	var phraseField []string
+	var nextField []string
+	isWordRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+	isSpecial := func(i int) bool {
+		if i < 0 || i >= len(text) {
+			return false
+		}
+		switch text[i] {
+		case '*', '?', '~', '\\':
+			return true
+		}
+		return false
+	}
+	for i := 0; i < len(text); {
+		r := text[i]
+		if !isWordRune(r) {
+			if r == '"' {
+				inQuotes = !inQuotes
+				phraseField = nil
+				if inQuotes {
+					phraseField = nextField
+				}
+			}
+			if !unicode.IsSpace(r) || !inQuotes {
+				nextField = nil
+			}
+			b.WriteRune(r)
+			i++
+			continue
+		}
+		start := i
+		for i < len(text) && isWordRune(text[i]) {
+			i++
+		}
+		word := string(text[start:i])
+		fields := nextField
+		if inQuotes {
+			fields = phraseField
+		}
+		nextField = nil
+
+		switch {
+		case !inQuotes && isQueryField(text, start, i):
+			// A field name which applies to the following term or phrase.
+			b.WriteString(word)
+			b.WriteRune(':')
+			i++
+			nextField = []string{word}
+			continue
+		case !inQuotes && (word == "AND" || word == "OR" || word == "NOT"):
+		case isSpecial(start-1) || isSpecial(i):
+		default:
+			if fields == nil {
+				fields = s.fields
+			}
+			replacement, err := s.correctWord(fields, word)
+			if err != nil {
+				return "", false, err
+			}
+			if replacement != "" {
+				word = replacement
+				changed = true
+			}
+		}
+		b.WriteString(word)
+	}
+	return b.String(), changed, nil
+}
+
+// Return the best correction for a word, or "" if it should be kept.  The
+// word is analyzed for each field, and fields which index it as the same
+// term are searched together.
+func (s *suggester) correctWord(fields []string, word string) (string, error) {
+	var terms []string
+	termFields := make(map[string][]string)
+	for _, field := range fields {
+		term, ok := s.analyze(field, word)
+		if !ok {
+			continue
+		}
+		if _, seen := termFields[term]; !seen {
+			terms = append(terms, term)
+		}
+		termFields[term] = append(termFields[term], field)
+	}
+	var docFreq uint32
+	for _, term := range terms {
+		termFreq, err := s.docFreq(termFields[term], term)
+		if err != nil {
+			return "", err
+		}
+		docFreq += termFreq
+	}
+	if len(terms) == 0 || docFreq >= s.minDocFreq {
+		return "", nil
+	}
+	var corrections termSuggestions
+	for _, term := range terms {
+		suggestions, err := s.candidates(termFields[term], term)
+		if err != nil {
+			return "", err
+		}
+		for _, suggestion := range suggestions {
+			corrected, ok := s.unanalyze(termFields[term][0], word, term, suggestion.Term)
+			if ok {
+				suggestion.Term = corrected
+				corrections = append(corrections, suggestion)
+			}
+		}
+	}
+	if len(corrections) == 0 {
+		return "", nil
+	}
+	sort.Sort(corrections)
+	return corrections[0].Term, nil
+}
+
+// Analyze a word as the field's analyzer would index it.  Fields without an
+// analyzer, such as StringType fields, index the word as it is.  Reports
+// false unless the word yields exactly one term.
+func (s *suggester) analyze(field, word string) (string, bool) {
+	analyzer := s.schema.fetchAnalyzer(field)
+	if analyzer == nil {
+		return word, true
+	}
+	terms := analyzer.Split(word)
+	if len(terms) != 1 {
+		return "", false
+	}
+	return terms[0], true
+}
+
+// Turn a correction of the term which a word was analyzed to back into a
+// word, by appending the ending which analysis stripped from the word.
+// Reports false if analysis changed the word in some other way, or if the
+// result doesn't analyze to the correction.
+func (s *suggester) unanalyze(field, word, term, correction string) (string, bool) {
+	var ending string
+	if strings.HasPrefix(word, term) {
+		ending = word[len(term):]
+	} else if lower := strings.ToLower(word); strings.HasPrefix(lower, term) {
+		ending = lower[len(term):]
+	} else {
+		return "", false
+	}
+	corrected := correction + ending
+	if analyzed, ok := s.analyze(field, corrected); !ok || analyzed != correction {
+		return "", false
+	}
+	return corrected, true
+}
@@ -42,23 +42,19 @@ func main() {
 }
 
 func createSchema() lucy.Schema {
-	// Create a new schema.
-	schema := lucy.NewSchema()
-
-	// Create an analyzer.
-	analyzer := lucy.NewEasyAnalyzer("en")
-
-	// Specify fields.
-	fieldType := lucy.NewFullTextType(analyzer)
-	schema.SpecField("title", fieldType)
-	schema.SpecField("content", fieldType)
-
+	// Derive a schema from the struct tags of MyDoc.
+	schema, err := lucy.SchemaFor[MyDoc]()
+	if err != nil {
+		log.Fatal(err)
+	}
 	return schema
 }
 
+// Both fields are full text fields analyzed with an EasyAnalyzer for
+// English.
 type MyDoc struct {
-	Title   string
-	Content string
+	Title   string `lucy:"title,fulltext,analyzer=en"`
+	Content string `lucy:"content,fulltext,analyzer=en"`
 }
 
 var docs []MyDoc = []MyDoc{
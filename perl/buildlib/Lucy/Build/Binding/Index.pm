@@ -22,6 +22,7 @@ $VERSION = eval $VERSION;
 sub bind_all {
     my $class = shift;
     $class->bind_backgroundmerger;
+    $class->bind_bm25similarity;
     $class->bind_datareader;
     $class->bind_datawriter;
     $class->bind_deletionswriter;
@@ -71,6 +72,33 @@ END_CONSTRUCTOR
     Clownfish::CFC::Binding::Perl::Class->register($binding);
 }
 
+sub bind_bm25similarity {
+    my $pod_spec = Clownfish::CFC::Binding::Perl::Pod->new;
+    my $synopsis = <<'END_SYNOPSIS';
+    my $sim  = Lucy::Index::BM25Similarity->new;
+    my $type = Lucy::Plan::FullTextType->new( analyzer => $analyzer );
+    $type->set_similarity($sim);
+    $schema->spec_field( name => 'content', type => $type );
+    $schema->set_similarity($sim);
+END_SYNOPSIS
+    my $constructor = <<'END_CONSTRUCTOR';
+    my $sim = Lucy::Index::BM25Similarity->new(
+        k1 => 1.2,     # default: 1.2
+        b  => 0.75,    # default: 0.75
+    );
+END_CONSTRUCTOR
+    $pod_spec->set_synopsis($synopsis);
+    $pod_spec->add_constructor( alias => 'new', sample => $constructor, );
+
+    my $binding = Clownfish::CFC::Binding::Perl::Class->new(
+        parcel     => "Lucy",
+        class_name => "Lucy::Index::BM25Similarity",
+    );
+    $binding->set_pod_spec($pod_spec);
+
+    Clownfish::CFC::Binding::Perl::Class->register($binding);
+}
+
 sub bind_datareader {
     my $pod_spec = Clownfish::CFC::Binding::Perl::Pod->new;
     my $synopsis = <<'END_SYNOPSIS';
@@ -33,9 +33,11 @@
 #include "Lucy/Test/Highlight/TestHeatMap.h"
 #include "Lucy/Test/Highlight/TestHighlighter.h"
 #include "Lucy/Test/Index/TestBackgroundMerger.h"
+#include "Lucy/Test/Index/TestBM25Similarity.h"
 #include "Lucy/Test/Index/TestDocWriter.h"
 #include "Lucy/Test/Index/TestHighlightWriter.h"
 #include "Lucy/Test/Index/TestIndexManager.h"
+#include "Lucy/Test/Index/TestIndexer.h"
 #include "Lucy/Test/Index/TestPolyReader.h"
 #include "Lucy/Test/Index/TestPostingListWriter.h"
 #include "Lucy/Test/Index/TestSegWriter.h"
@@ -142,6 +144,8 @@ Test_create_test_suite() {
     TestSuite_Add_Batch(suite, (TestBatch*)TestNumericType_new());
     TestSuite_Add_Batch(suite, (TestBatch*)TestFType_new());
     TestSuite_Add_Batch(suite, (TestBatch*)TestBGMerger_new());
+    TestSuite_Add_Batch(suite, (TestBatch*)TestIndexer_new());
+    TestSuite_Add_Batch(suite, (TestBatch*)TestBM25Similarity_new());
     TestSuite_Add_Batch(suite, (TestBatch*)TestSeg_new());
     TestSuite_Add_Batch(suite, (TestBatch*)TestHighlighter_new());
     TestSuite_Add_Batch(suite, (TestBatch*)TestSimple_new());
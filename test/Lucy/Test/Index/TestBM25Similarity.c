@@ -0,0 +1,227 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#define TESTLUCY_USE_SHORT_NAMES
+#include "Lucy/Util/ToolSet.h"
+#include <math.h>
+
+#include "Lucy/Test/Index/TestBM25Similarity.h"
+#include "Clownfish/TestHarness/TestBatchRunner.h"
+#include "Lucy/Analysis/StandardTokenizer.h"
+#include "Lucy/Document/Doc.h"
+#include "Lucy/Document/HitDoc.h"
+#include "Lucy/Index/BM25Similarity.h"
+#include "Lucy/Index/Indexer.h"
+#include "Lucy/Index/PolyReader.h"
+#include "Lucy/Index/SegReader.h"
+#include "Lucy/Index/Segment.h"
+#include "Lucy/Plan/FullTextType.h"
+#include "Lucy/Plan/Schema.h"
+#include "Lucy/Search/Hits.h"
+#include "Lucy/Search/IndexSearcher.h"
+#include "Lucy/Search/TermQuery.h"
+#include "Lucy/Store/RAMFolder.h"
+#include "Lucy/Test/TestUtils.h"
+#include "Lucy/Util/Freezer.h"
+#include "Lucy/Util/Json.h"
+
+TestBM25Similarity*
+TestBM25Similarity_new() {
+    return (TestBM25Similarity*)Class_Make_Obj(TESTBM25SIMILARITY);
+}
+
+static Schema*
+S_create_schema(BM25Similarity *sim) {
+    Schema            *schema    = Schema_new();
+    StandardTokenizer *tokenizer = StandardTokenizer_new();
+    FullTextType      *type      = FullTextType_new((Analyzer*)tokenizer);
+    FullTextType_Set_Similarity(type, (Similarity*)sim);
+    Schema_Spec_Field(schema, SSTR_WRAP_C("content"), (FieldType*)type);
+    Schema_Set_Similarity(schema, (Similarity*)sim);
+    DECREF(type);
+    DECREF(tokenizer);
+    return schema;
+}
+
+static void
+S_add_doc(Indexer *indexer, const char *content, float boost) {
+    Doc    *doc   = Doc_new(NULL, 0);
+    String *value = Str_newf("%s", content);
+    Doc_Store(doc, SSTR_WRAP_C("content"), (Obj*)value);
+    Indexer_Add_Doc(indexer, doc, boost);
+    DECREF(value);
+    DECREF(doc);
+}
+
+// Add up one of the field totals recorded in the index's segments.
+static int64_t
+S_field_total(Folder *folder, const char *key) {
+    PolyReader *reader = PolyReader_open((Obj*)folder, NULL, NULL);
+    Vector *seg_readers = PolyReader_Get_Seg_Readers(reader);
+    int64_t total = 0;
+    for (size_t i = 0, max = Vec_Get_Size(seg_readers); i < max; i++) {
+        SegReader *seg_reader = (SegReader*)Vec_Fetch(seg_readers, i);
+        Segment *segment = SegReader_Get_Segment(seg_reader);
+        Hash *fields = (Hash*)Seg_Fetch_Metadata_Utf8(segment, "bm25", 4);
+        Hash *totals = fields
+                       ? (Hash*)Hash_Fetch_Utf8(fields, "content", 7)
+                       : NULL;
+        Obj *value = totals ? Hash_Fetch_Utf8(totals, key, strlen(key)) : NULL;
+        if (value) { total += Json_obj_to_i64(value); }
+    }
+    DECREF(reader);
+    return total;
+}
+
+static void
+test_TF_Norm(TestBatchRunner *runner) {
+    BM25Similarity *sim = BM25Sim_new(1.2f, 0.75f);
+    TEST_FLOAT_EQ(runner, BM25Sim_TF_Norm(sim, 1.0f, 4.0f, 2.0f),
+                  2.2 / 3.1, "TF_Norm of a long field");
+    TEST_FLOAT_EQ(runner, BM25Sim_TF_Norm(sim, 2.0f, 2.0f, 2.0f),
+                  4.4 / 3.2, "TF_Norm of a field of average length");
+    TEST_FLOAT_EQ(runner, BM25Sim_TF_Norm(sim, 1.0f, 4.0f, 0.0f), 1.0,
+                  "TF_Norm without an average length");
+    TEST_FLOAT_EQ(runner, BM25Sim_TF(sim, 2.0f), 4.4 / 3.2,
+                  "TF saturates without length normalization");
+    DECREF(sim);
+}
+
+static void
+test_Dump_Load_and_Equals(TestBatchRunner *runner) {
+    BM25Similarity *sim        = BM25Sim_new(1.2f, 0.75f);
+    BM25Similarity *k1_differs = BM25Sim_new(2.0f, 0.75f);
+    Similarity     *classic    = Sim_new();
+    Obj            *dump       = BM25Sim_Dump(sim);
+    Obj            *clone      = Freezer_load(dump);
+
+    TEST_TRUE(runner, BM25Sim_Equals(sim, clone), "Dump => Load round trip");
+    TEST_FALSE(runner, BM25Sim_Equals(sim, (Obj*)k1_differs),
+               "Equals() false with different k1");
+    TEST_FALSE(runner, BM25Sim_Equals(sim, (Obj*)classic),
+               "Equals() false with a different class");
+
+    DECREF(clone);
+    DECREF(dump);
+    DECREF(classic);
+    DECREF(k1_differs);
+    DECREF(sim);
+}
+
+static void
+test_scoring(TestBatchRunner *runner) {
+    BM25Similarity *sim     = BM25Sim_new(1.2f, 0.75f);
+    Schema         *schema  = S_create_schema(sim);
+    Folder         *folder  = (Folder*)RAMFolder_new(NULL);
+    Indexer        *indexer = Indexer_new(schema, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "a b c d", 1.0f);
+    S_add_doc(indexer, "a", 1.0f);
+    S_add_doc(indexer, "b c", 1.0f);
+    S_add_doc(indexer, "a", 2.0f);
+    Indexer_Commit(indexer);
+
+    // Four docs hold eight tokens, and three of them have "a".
+    double idf = log(1.0 + (4.0 - 3.0 + 0.5) / (3.0 + 0.5));
+    IndexSearcher *searcher = IxSearcher_new((Obj*)folder);
+    TermQuery *query = TestUtils_make_term_query("content", "a");
+    Hits *hits = IxSearcher_Hits(searcher, (Obj*)query, 0, 10, NULL);
+    TEST_INT_EQ(runner, Hits_Total_Hits(hits), 3, "Hits for term");
+    HitDoc *hit = Hits_Next(hits);
+    TEST_FLOAT_EQ(runner, HitDoc_Get_Score(hit), 2.0 * idf * 2.2 / 1.75,
+                  "Doc boost scales the score without changing the length");
+    DECREF(hit);
+    hit = Hits_Next(hits);
+    TEST_FLOAT_EQ(runner, HitDoc_Get_Score(hit), idf * 2.2 / 1.75,
+                  "Short field");
+    DECREF(hit);
+    hit = Hits_Next(hits);
+    TEST_FLOAT_EQ(runner, HitDoc_Get_Score(hit), idf * 2.2 / 3.1,
+                  "Long field");
+    DECREF(hit);
+
+    DECREF(hits);
+    DECREF(query);
+    DECREF(searcher);
+    DECREF(indexer);
+    DECREF(folder);
+    DECREF(schema);
+    DECREF(sim);
+}
+
+static void
+test_field_totals(TestBatchRunner *runner) {
+    BM25Similarity *sim     = BM25Sim_new(1.2f, 0.75f);
+    Schema         *schema  = S_create_schema(sim);
+    Folder         *folder  = (Folder*)RAMFolder_new(NULL);
+    Indexer        *indexer = Indexer_new(schema, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "a b c d", 1.0f);
+    S_add_doc(indexer, "x", 1.0f);
+    Indexer_Commit(indexer);
+    DECREF(indexer);
+    TEST_INT_EQ(runner, S_field_total(folder, "docs"), 2,
+                "Docs which have the field");
+    TEST_INT_EQ(runner, S_field_total(folder, "tokens"), 5,
+                "Tokens in the field");
+
+    indexer = Indexer_new(schema, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "b c", 1.0f);
+    Indexer_Delete_By_Term(indexer, SSTR_WRAP_C("content"),
+                           (Obj*)SSTR_WRAP_C("x"));
+    Indexer_Optimize(indexer);
+    Indexer_Commit(indexer);
+    DECREF(indexer);
+    TEST_INT_EQ(runner, S_field_total(folder, "docs"), 2,
+                "Merging drops deleted docs from the totals");
+    TEST_INT_EQ(runner, S_field_total(folder, "tokens"), 6,
+                "Merging drops the tokens of deleted docs");
+
+    DECREF(folder);
+    DECREF(schema);
+    DECREF(sim);
+}
+
+static void
+test_Schema_Dump_Load(TestBatchRunner *runner) {
+    BM25Similarity *sim    = BM25Sim_new(2.0f, 0.5f);
+    Schema         *schema = S_create_schema(sim);
+    Hash           *dump   = Schema_Dump(schema);
+    Schema         *loaded = Schema_Load(schema, (Obj*)dump);
+
+    Similarity     *field_sim
+        = Schema_Fetch_Sim(loaded, SSTR_WRAP_C("content"));
+
+    TEST_TRUE(runner, BM25Sim_Equals(sim, (Obj*)Schema_Get_Similarity(loaded)),
+              "Dump => Load round trip keeps the Schema's Similarity");
+    TEST_TRUE(runner, BM25Sim_Equals(sim, (Obj*)field_sim),
+              "Dump => Load round trip keeps the field's Similarity");
+
+    DECREF(loaded);
+    DECREF(dump);
+    DECREF(schema);
+    DECREF(sim);
+}
+
+void
+TestBM25Similarity_Run_IMP(TestBM25Similarity *self,
+                           TestBatchRunner *runner) {
+    TestBatchRunner_Plan(runner, (TestBatch*)self, 17);
+    test_TF_Norm(runner);
+    test_Dump_Load_and_Equals(runner);
+    test_scoring(runner);
+    test_field_totals(runner);
+    test_Schema_Dump_Load(runner);
+}
+
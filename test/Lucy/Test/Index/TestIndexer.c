@@ -0,0 +1,204 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#define TESTLUCY_USE_SHORT_NAMES
+#include "Lucy/Util/ToolSet.h"
+
+#include "Lucy/Test/Index/TestIndexer.h"
+#include "Clownfish/TestHarness/TestBatchRunner.h"
+#include "Lucy/Document/Doc.h"
+#include "Lucy/Index/Indexer.h"
+#include "Lucy/Search/IndexSearcher.h"
+#include "Lucy/Store/RAMFolder.h"
+#include "Lucy/Test/TestSchema.h"
+
+TestIndexer*
+TestIndexer_new() {
+    return (TestIndexer*)Class_Make_Obj(TESTINDEXER);
+}
+
+static void
+S_add_doc(Indexer *indexer, const char *content) {
+    Doc    *doc   = Doc_new(NULL, 0);
+    String *field = SSTR_WRAP_C("content");
+    String *value = Str_newf("%s", content);
+    Doc_Store(doc, field, (Obj*)value);
+    Indexer_Add_Doc(indexer, doc, 1.0f);
+    DECREF(value);
+    DECREF(doc);
+}
+
+static Folder*
+S_create_index(Schema *schema) {
+    Folder  *folder  = (Folder*)RAMFolder_new(NULL);
+    Indexer *indexer = Indexer_new(schema, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "a");
+    Indexer_Commit(indexer);
+    DECREF(indexer);
+    return folder;
+}
+
+static int32_t
+S_doc_max(Folder *folder) {
+    IndexSearcher *searcher = IxSearcher_new((Obj*)folder);
+    int32_t doc_max = IxSearcher_Doc_Max(searcher);
+    DECREF(searcher);
+    return doc_max;
+}
+
+static void
+S_try_add_doc(void *context) {
+    Indexer *indexer = (Indexer*)context;
+    Indexer_Add_Doc(indexer, Indexer_Get_Stock_Doc(indexer), 1.0f);
+}
+
+static void
+S_try_delete_by_term(void *context) {
+    Indexer_Delete_By_Term((Indexer*)context, SSTR_WRAP_C("content"),
+                           (Obj*)SSTR_WRAP_C("a"));
+}
+
+static void
+S_try_prepare_commit(void *context) {
+    Indexer_Prepare_Commit((Indexer*)context);
+}
+
+static void
+S_try_commit(void *context) {
+    Indexer_Commit((Indexer*)context);
+}
+
+static void
+S_try_rollback(void *context) {
+    Indexer_Rollback((Indexer*)context);
+}
+
+static void
+S_try_close(void *context) {
+    Indexer_Close((Indexer*)context);
+}
+
+static void
+S_test_throws(TestBatchRunner *runner, Err_Attempt_t routine,
+              Indexer *indexer, const char *msg) {
+    Err *error = Err_trap(routine, indexer);
+    TEST_TRUE(runner, error != NULL, "%s", msg);
+    DECREF(error);
+}
+
+static void
+test_rollback(TestBatchRunner *runner) {
+    Schema *schema = (Schema*)TestSchema_new(false);
+    Folder *folder = S_create_index(schema);
+    Vector *before = Folder_List_R(folder, NULL);
+    Vec_Sort(before);
+
+    Indexer *indexer = Indexer_new(NULL, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "b");
+    Indexer_Rollback(indexer);
+    TEST_INT_EQ(runner, S_doc_max(folder), 1,
+                "Rollback discards added docs");
+    Vector *after = Folder_List_R(folder, NULL);
+    Vec_Sort(after);
+    TEST_TRUE(runner, Vec_Equals(before, (Obj*)after),
+              "Rollback removes the new segment");
+    DECREF(after);
+    S_test_throws(runner, S_try_rollback, indexer,
+                  "Rollback twice throws");
+    S_test_throws(runner, S_try_commit, indexer,
+                  "Commit after Rollback throws");
+    S_test_throws(runner, S_try_add_doc, indexer,
+                  "Add_Doc after Rollback throws");
+    S_test_throws(runner, S_try_delete_by_term, indexer,
+                  "Delete_By_Term after Rollback throws");
+    DECREF(indexer);
+
+    indexer = Indexer_new(NULL, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "c");
+    Indexer_Prepare_Commit(indexer);
+    Indexer_Rollback(indexer);
+    after = Folder_List_R(folder, NULL);
+    Vec_Sort(after);
+    TEST_TRUE(runner, Vec_Equals(before, (Obj*)after),
+              "Rollback after Prepare_Commit removes temporary files");
+    DECREF(after);
+    DECREF(indexer);
+
+    // The write lock must have been released.
+    indexer = Indexer_new(NULL, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "d");
+    Indexer_Commit(indexer);
+    TEST_INT_EQ(runner, S_doc_max(folder), 2,
+                "New Indexer may commit after Rollback");
+    DECREF(indexer);
+
+    DECREF(before);
+    DECREF(folder);
+    DECREF(schema);
+}
+
+static void
+test_close(TestBatchRunner *runner) {
+    Schema *schema = (Schema*)TestSchema_new(false);
+    Folder *folder = S_create_index(schema);
+
+    Indexer *indexer = Indexer_new(NULL, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "b");
+    Indexer_Close(indexer);
+    TEST_INT_EQ(runner, S_doc_max(folder), 1,
+                "Close discards uncommitted docs");
+    S_test_throws(runner, S_try_add_doc, indexer,
+                  "Add_Doc after Close throws");
+    S_test_throws(runner, S_try_delete_by_term, indexer,
+                  "Delete_By_Term after Close throws");
+    S_test_throws(runner, S_try_prepare_commit, indexer,
+                  "Prepare_Commit after Close throws");
+    S_test_throws(runner, S_try_commit, indexer,
+                  "Commit after Close throws");
+    S_test_throws(runner, S_try_rollback, indexer,
+                  "Rollback after Close throws");
+    Err *error = Err_trap(S_try_close, indexer);
+    TEST_TRUE(runner, error == NULL, "Close twice is a no-op");
+    DECREF(error);
+    DECREF(indexer);
+
+    indexer = Indexer_new(NULL, (Obj*)folder, NULL, 0);
+    S_add_doc(indexer, "c");
+    Indexer_Commit(indexer);
+    error = Err_trap(S_try_close, indexer);
+    TEST_TRUE(runner, error == NULL, "Close after Commit is a no-op");
+    DECREF(error);
+    TEST_INT_EQ(runner, S_doc_max(folder), 2,
+                "Close after Commit keeps committed docs");
+    DECREF(indexer);
+
+    // The write lock must have been released.
+    indexer = Indexer_new(NULL, (Obj*)folder, NULL, 0);
+    Indexer_Commit(indexer);
+    TEST_TRUE(runner, true, "New Indexer may be opened after Close");
+    DECREF(indexer);
+
+    DECREF(folder);
+    DECREF(schema);
+}
+
+void
+TestIndexer_Run_IMP(TestIndexer *self, TestBatchRunner *runner) {
+    TestBatchRunner_Plan(runner, (TestBatch*)self, 18);
+    test_rollback(runner);
+    test_close(runner);
+}
+
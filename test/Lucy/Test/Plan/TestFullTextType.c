@@ -25,6 +25,7 @@
 #include "Lucy/Plan/FullTextType.h"
 #include "Lucy/Analysis/Normalizer.h"
 #include "Lucy/Analysis/StandardTokenizer.h"
+#include "Lucy/Index/BM25Similarity.h"
 #include "Lucy/Util/Freezer.h"
 
 TestFullTextType*
@@ -42,6 +43,8 @@ test_Dump_Load_and_Equals(TestBatchRunner *runner) {
     FullTextType      *not_indexed   = FullTextType_new((Analyzer*)tokenizer);
     FullTextType      *not_stored    = FullTextType_new((Analyzer*)tokenizer);
     FullTextType      *highlightable = FullTextType_new((Analyzer*)tokenizer);
+    FullTextType      *sim_differs   = FullTextType_new((Analyzer*)tokenizer);
+    BM25Similarity    *sim           = BM25Sim_new(1.2f, 0.75f);
     Obj               *dump          = (Obj*)FullTextType_Dump(type);
     Obj               *clone         = Freezer_load(dump);
     Obj               *another_dump  = (Obj*)FullTextType_Dump_For_Schema(type);
@@ -50,6 +53,9 @@ test_Dump_Load_and_Equals(TestBatchRunner *runner) {
     FullTextType_Set_Indexed(not_indexed, false);
     FullTextType_Set_Stored(not_stored, false);
     FullTextType_Set_Highlightable(highlightable, true);
+    FullTextType_Set_Similarity(sim_differs, (Similarity*)sim);
+    Obj *sim_dump  = (Obj*)FullTextType_Dump(sim_differs);
+    Obj *sim_clone = Freezer_load(sim_dump);
 
     // (This step is normally performed by Schema_Load() internally.)
     Hash_Store_Utf8((Hash*)another_dump, "analyzer", 8, INCREF(tokenizer));
@@ -65,15 +71,23 @@ test_Dump_Load_and_Equals(TestBatchRunner *runner) {
                "Equals() false with stored => false");
     TEST_FALSE(runner, FullTextType_Equals(type, (Obj*)highlightable),
                "Equals() false with highlightable => true");
+    TEST_FALSE(runner, FullTextType_Equals(type, (Obj*)sim_differs),
+               "Equals() false with different Similarity");
     TEST_TRUE(runner, FullTextType_Equals(type, (Obj*)clone),
               "Dump => Load round trip");
     TEST_TRUE(runner, FullTextType_Equals(type, (Obj*)another_clone),
               "Dump_For_Schema => Load round trip");
+    TEST_TRUE(runner, FullTextType_Equals(sim_differs, sim_clone),
+              "Dump => Load round trip with Similarity");
 
+    DECREF(sim_clone);
+    DECREF(sim_dump);
     DECREF(another_clone);
     DECREF(dump);
     DECREF(clone);
     DECREF(another_dump);
+    DECREF(sim);
+    DECREF(sim_differs);
     DECREF(highlightable);
     DECREF(not_stored);
     DECREF(not_indexed);
@@ -104,7 +118,7 @@ test_Compare_Values(TestBatchRunner *runner) {
 
 void
 TestFullTextType_Run_IMP(TestFullTextType *self, TestBatchRunner *runner) {
-    TestBatchRunner_Plan(runner, (TestBatch*)self, 10);
+    TestBatchRunner_Plan(runner, (TestBatch*)self, 12);
     test_Dump_Load_and_Equals(runner);
     test_Compare_Values(runner);
 }